@@ -24,35 +24,106 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"fmt"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/gommon/log"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
+	"vql/internal/authz"
+	"vql/internal/cache"
+	"vql/internal/config"
 	"vql/internal/db"
 	"vql/internal/defs"
+	"vql/internal/hub"
+	"vql/internal/journal"
+	"vql/internal/logging"
+	"vql/internal/mail"
+	"vql/internal/push"
 	"vql/internal/routes"
+	"vql/internal/store"
 )
 
-var (
-	standbyMode int
-	repMode     bool
-	logDir      string
+const (
+	// wait in-flight requests on shutdown
+	ShutdownTimeout = 30 * time.Second
+	// keep old db pools alive after reload for in-flight requests
+	ReloadDrain = 30 * time.Second
+	// service prefix of the fresh databases used by replay mode
+	ReplayServicePrefix = "replay"
 )
 
-func param() {
-	flag.IntVar(&standbyMode, "standbymode", -1, "0=allcold, 1<standbymode is pre wake room, -1=allhot")
-	flag.BoolVar(&repMode, "repmode", false, "replay mode ... false=off, true=on ")
-	flag.StringVar(&logDir, "logdir", "/var/log/vqld/", "base log directory")
-	flag.Parse()
+// Configuration with the options of each package
+type settings struct {
+	*config.Config
+	db        db.Options
+	dbOp      db.Options
+	placement db.Placement
+	log       logging.Options
+	cache     cache.Options
+	sso       authz.Options
+	mail      mail.Options
+	push      push.Options
+}
+
+// Load configuration and the package options, problems are reported by Validate
+func load(args []string) (*settings, error) {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	s := &settings{
+		Config:    cfg,
+		db:        db.LoadOptions(cfg.Values),
+		placement: db.LoadPlacement(cfg.Values),
+		log:       logging.LoadOptions(cfg.Values),
+		cache:     cache.LoadOptions(cfg.Values),
+		sso:       authz.LoadOptions(cfg.Values),
+		mail:      mail.LoadOptions(cfg.Values),
+		push:      push.LoadOptions(cfg.Values),
+	}
+	s.dbOp = s.db.Operation(cfg.Values)
+	return s, nil
 }
 
 func main() {
-	param()
 	var err error
+	cfg, err := load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.VersionOnly {
+		fmt.Println(defs.Version)
+		return
+	}
+	if err = cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "configuration error in "+cfg.File+":")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.ConfigTest {
+		fmt.Println("configuration ok: " + cfg.File)
+		return
+	}
 
 	e := echo.New()
+	e.Logger.SetLevel(cfg.Lvl())
 	defs.InitRand(true)
+	db.SetPlacement(cfg.placement)
+	db.Conns.Configure(cfg.db)
+	db.OpConns.Configure(cfg.dbOp)
+	if cfg.RepMode {
+		os.Exit(replay(e, cfg.Config))
+	}
+	if err = logging.Open(cfg.log); err != nil {
+		fmt.Fprintln(os.Stderr, "open log: "+err.Error())
+		os.Exit(1)
+	}
+	defer logging.Close()
+	e.Logger.SetOutput(logging.App)
 	err = db.Conns.Init()
 	if err != nil {
 		e.Logger.Fatal(err)
@@ -61,12 +132,175 @@ func main() {
 	if err != nil {
 		e.Logger.Fatal(err)
 	}
+	// vendor tables provisioned by older releases get the new columns before serving
+	err = db.OpConns.Migrate(func(num int, statement string) {
+		e.Logger.Infof("shard %02x migrate: %s", num, statement)
+	})
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	e.Logger.Infof("%d shard pools warmed up, standby mode %d", db.Conns.OpenShards(), cfg.db.Standby)
+	db.Conns.StartHealthCheck(db.HealthCheckInterval, func(num int, side db.Side) {
+		e.Logger.Warnf("shard %02x switched to %s", num, side)
+	})
+	db.OpConns.StartHealthCheck(db.HealthCheckInterval, nil)
+	if lookup := cache.New(cfg.cache); lookup != nil {
+		defer lookup.Close()
+		store.UseCache(lookup)
+		e.Logger.Infof("lookup cache size %d ttl %s remote %q", cfg.cache.Size, cfg.cache.TTL, cfg.cache.Addr)
+	}
+	authz.Configure(cfg.sso)
+	// notice workers read the store, stopped on shutdown before the pools close
+	workers := []func(){}
+	if notifier := mail.New(cfg.mail, func(err error) { e.Logger.Warn(err) }); notifier != nil {
+		workers = append(workers, hub.Observe(notifier.Notify), notifier.Close)
+		e.Logger.Infof("mail notices through %s", cfg.mail.SmtpAddr)
+	}
+	dispatcher, err := push.New(cfg.push, func(err error) { e.Logger.Warn(err) })
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	if dispatcher != nil {
+		push.Default = dispatcher
+		workers = append(workers, hub.Observe(dispatcher.Notify), dispatcher.Close)
+		e.Logger.Info("push notices enabled")
+	}
 	route.Init(e)
-	e.Logger.SetLevel(log.DEBUG)
-	e.Logger.Fatal(e.Start(":7000"))
+	if cfg.JournalRecord {
+		recorder, err := journal.Open(cfg.JournalPath())
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+		defer recorder.Close()
+		e.Use(journal.Middleware(recorder))
+		e.Logger.Infof("recording requests to %s", cfg.JournalPath())
+	}
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	go func() {
+		if err := e.Start(cfg.Listen()); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
 
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			e.Logger.Infof("%s received, shutting down", sig)
+			break
+		}
+		e.Logger.Infof("%s received, reloading %s", sig, cfg.File)
+		if next, err := reload(e, cfg); err != nil {
+			e.Logger.Errorf("reload failed, keep current configuration: %s", err)
+		} else {
+			cfg = next
+		}
+	}
+	shutdown(e, workers)
+}
+
+// Replay journal against fresh databases with fixed seed, returns exit code
+func replay(e *echo.Echo, cfg *config.Config) int {
+	file, err := os.Open(cfg.JournalPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+	entries, err := journal.Read(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read journal "+cfg.JournalPath()+": "+err.Error())
+		return 1
+	}
+
+	defs.ServicePrefix = ReplayServicePrefix
+	defs.InitRand(false)
+	if err = db.Teardown(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err = db.Setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err = db.Conns.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Conns.Close()
+	if err = db.OpConns.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.OpConns.Close()
+	route.Init(e)
+
+	result := journal.Replay(e, entries)
+	for _, diff := range result.Diffs {
+		fmt.Println(diff)
+	}
+	fmt.Printf("replayed %d requests, %d differences\n", result.Entries, len(result.Diffs))
+	if len(result.Diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Re-read configuration and rebuild db pools, the listener is kept as is
+func reload(e *echo.Echo, current *settings) (*settings, error) {
+	cfg, err := load(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Listen() != current.Listen() {
+		e.Logger.Warnf("listen address change %s -> %s requires restart", current.Listen(), cfg.Listen())
+	}
+	if cfg.cache != current.cache {
+		e.Logger.Warn("cache settings change requires restart")
+	}
+	if cfg.mail != current.mail {
+		e.Logger.Warn("mail settings change requires restart")
+	}
+	if cfg.push != current.push {
+		e.Logger.Warn("push settings change requires restart")
+	}
+	if cfg.sso != current.sso {
+		// pending authorizations of the old provider are dropped
+		authz.Configure(cfg.sso)
+	}
+	// reopen also picks up files moved by external logrotate
+	if err = logging.Open(cfg.log); err != nil {
+		return nil, err
+	}
+	if err = db.Conns.Reload(cfg.db, ReloadDrain); err != nil {
+		return nil, err
+	}
+	if err = db.OpConns.Reload(cfg.dbOp, ReloadDrain); err != nil {
+		return nil, err
+	}
+	db.SetPlacement(cfg.placement)
+	e.Logger.SetLevel(cfg.Lvl())
+	return cfg, nil
+}
+
+// Stop accepting, wait in-flight requests, stop workers in order then close db pools
+func shutdown(e *echo.Echo, workers []func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Errorf("shutdown: %s", err)
+	}
+	for _, stop := range workers {
+		stop()
+	}
+	if err := db.Conns.Close(); err != nil {
+		e.Logger.Errorf("close db: %s", err)
+	}
+	if err := db.OpConns.Close(); err != nil {
+		e.Logger.Errorf("close op db: %s", err)
+	}
+	e.Logger.Info("stopped")
 }
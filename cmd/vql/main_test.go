@@ -25,30 +25,94 @@
 package main
 
 import (
+	"bufio"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+	"vql/internal/authz"
+	"vql/internal/authz/idptest"
 	"vql/internal/defs"
 	"vql/internal/db"
+	"vql/internal/push"
+	"vql/internal/push/pushtest"
 	"vql/internal/routes"
 	"vql/internal/routes/priv"
 	"vql/internal/routes/queue"
 	"vql/internal/routes/vendor"
+	"vql/internal/store"
 )
 
-// Enqueue test no require admit
-func TestEnqueueNoRequireAdmit(t *testing.T) {
+// run scenarios against live MySQL if set, in-memory store otherwise
+const EnvTestMySQL = "VQL_TEST_MYSQL"
+
+func setupStore(t *testing.T) {
+	if os.Getenv(EnvTestMySQL) == "" {
+		store.UseMemory()
+		return
+	}
 	defs.ServicePrefix = "gotest"
 	assert.NoError(t, db.Teardown())
 	assert.NoError(t, db.Setup())
 	assert.NoError(t, db.Conns.Init())
 	assert.NoError(t, db.OpConns.Init())
+	store.UseMySQL(&db.Conns, &db.OpConns)
+}
+
+func teardownStore(t *testing.T) {
+	if os.Getenv(EnvTestMySQL) == "" {
+		return
+	}
+	assert.NoError(t, db.Teardown())
+}
+
+// Build client request with encoded body, empty body if nil
+func newRequest(method string, target string, body interface{}) *http.Request {
+	reader := strings.NewReader("")
+	if body != nil {
+		reader = strings.NewReader(url.QueryEscape(defs.Encode(body, 1592619000)))
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("User-Agent", "vQL-Client")
+	req.Header.Set("Platform", "Windows")
+	req.Header.Set("IV", "0")
+	req.Header.Set("Nonce", "637295289927929882")
+	return req
+}
+
+// Phone activated account of the fixed identifier and seed
+func newReqBodyCreate() queue.ReqBodyCreate {
+	reqBody := queue.ReqBodyCreate{}
+	reqBody.CheckedAgreement = true
+	reqBody.AgreementVersion = defs.RequireAgreementVersion
+	reqBody.ActivateType = uint8(defs.PhoneAuth)
+	reqBody.ActivateKeyword = "0x000000000"
+	reqBody.Identifier = "57ea5c1f17211a2c384a05030a88fcace73d9d92bd1c714da5c68ede09847304"
+	reqBody.Seed = "9c463571a92614f5ed8ff55c249e7b8c458860e030284d2b5bcc7a529ac58741"
+	reqBody.Ticks = 1592619000
+	return reqBody
+}
+
+// Create account 1 on the empty store
+func createAccount(t *testing.T, e *echo.Echo) queue.ResBodyCreate {
+	rec := httptest.NewRecorder()
+	assert.NoError(t, queue.Create(e.NewContext(newRequest(http.MethodPost, "/new", newReqBodyCreate()), rec)))
+	resCreate := queue.ResBodyCreate{}
+	bodyBytes, _ := ioutil.ReadAll(rec.Body)
+	defs.Decode(bodyBytes, &resCreate, resCreate.Ticks)
+	return resCreate
+}
+
+// Enqueue test no require admit
+func TestEnqueueNoRequireAdmit(t *testing.T) {
+	setupStore(t)
 	e := echo.New()
 	route.Init(e)
 	e.Logger.SetLevel(log.DEBUG)
@@ -274,15 +338,11 @@ func TestEnqueueNoRequireAdmit(t *testing.T) {
 	assert.NoError(t, queue.Dequeue(authCtx))
 
 	assert.NoError(t, priv.DropVendor(authCtx))
-	assert.NoError(t, db.Teardown())
+	teardownStore(t)
 }
 
 func TestEnqueueUserCancel(t *testing.T) {
-	defs.ServicePrefix = "gotest"
-	assert.NoError(t, db.Teardown())
-	assert.NoError(t, db.Setup())
-	assert.NoError(t, db.Conns.Init())
-	assert.NoError(t, db.OpConns.Init())
+	setupStore(t)
 	e := echo.New()
 	route.Init(e)
 	e.Logger.SetLevel(log.DEBUG)
@@ -508,16 +568,12 @@ func TestEnqueueUserCancel(t *testing.T) {
 	assert.NoError(t, queue.Cancel(authCtx))
 
 	assert.NoError(t, priv.DropVendor(authCtx))
-	assert.NoError(t, db.Teardown())
+	teardownStore(t)
 }
 
 // Enqueue test require admit polite dequeue
 func TestEnqueueRequireAdmitPolite(t *testing.T) {
-	defs.ServicePrefix = "gotest"
-	assert.NoError(t, db.Teardown())
-	assert.NoError(t, db.Setup())
-	assert.NoError(t, db.Conns.Init())
-	assert.NoError(t, db.OpConns.Init())
+	setupStore(t)
 	e := echo.New()
 	route.Init(e)
 	e.Logger.SetLevel(log.DEBUG)
@@ -742,16 +798,12 @@ func TestEnqueueRequireAdmitPolite(t *testing.T) {
 	assert.NoError(t, vendor.Dequeue(authCtx))
 
 	assert.NoError(t, priv.DropVendor(authCtx))
-	assert.NoError(t, db.Teardown())
+	teardownStore(t)
 }
 
 // Enqueue test require admit force dequeue
 func TestEnqueueRequireAdmitForce(t *testing.T) {
-	defs.ServicePrefix = "gotest"
-	assert.NoError(t, db.Teardown())
-	assert.NoError(t, db.Setup())
-	assert.NoError(t, db.Conns.Init())
-	assert.NoError(t, db.OpConns.Init())
+	setupStore(t)
 	e := echo.New()
 	route.Init(e)
 	e.Logger.SetLevel(log.DEBUG)
@@ -976,5 +1028,572 @@ func TestEnqueueRequireAdmitForce(t *testing.T) {
 	assert.NoError(t, vendor.Dequeue(authCtx))
 
 	assert.NoError(t, priv.DropVendor(authCtx))
-	assert.NoError(t, db.Teardown())
+	teardownStore(t)
+}
+
+// Vendor sso link and logon against mock identity provider
+func TestVendorSso(t *testing.T) {
+	setupStore(t)
+	defer teardownStore(t)
+	idp := idptest.NewServer("vql")
+	defer idp.Close()
+	authz.Configure(authz.Options{Issuer: idp.URL, ClientId: "vql", RedirectUrl: "https://vql.example.com/sso/callback"})
+	defer authz.Configure(authz.Options{})
+	e := echo.New()
+	route.Init(e)
+	e.Logger.SetLevel(log.DEBUG)
+	resCreate := createAccount(t, e)
+
+	// normal user can not link
+	reqSso := vendor.ReqBodySsoBegin{}
+	reqSso.Ticks = 1592619000
+	rec := httptest.NewRecorder()
+	assert.NoError(t, vendor.SsoLink(&defs.AuthContext{e.NewContext(newRequest(http.MethodPost, "/on/vendor/sso", reqSso), rec), 1}))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	reqUpdate := vendor.ReqBodyUpdate{}
+	reqUpdate.Name = "vendor sample"
+	reqUpdate.Caption = "caption sample"
+	reqUpdate.Ticks = 1592619000
+	rec = httptest.NewRecorder()
+	assert.NoError(t, vendor.Upgrade(&defs.AuthContext{e.NewContext(newRequest(http.MethodPost, "/on/vendor/upgrade", reqUpdate), rec), 1}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// follows authorize url and calls back, returns callback response
+	callback := func(begin *httptest.ResponseRecorder) vendor.ResBodySsoCallback {
+		resBegin := vendor.ResBodySsoBegin{}
+		bodyBytes, _ := ioutil.ReadAll(begin.Body)
+		defs.Decode(bodyBytes, &resBegin, resBegin.Ticks)
+		redirect, err := idp.Authorize(resBegin.AuthorizeUrl)
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, vendor.SsoCallback(e.NewContext(newRequest(http.MethodGet, "/sso/callback?"+redirect.RawQuery, nil), rec)))
+		resCallback := vendor.ResBodySsoCallback{}
+		bodyBytes, _ = ioutil.ReadAll(rec.Body)
+		defs.Decode(bodyBytes, &resCallback, resCallback.Ticks)
+		return resCallback
+	}
+
+	// not linked yet
+	rec = httptest.NewRecorder()
+	assert.NoError(t, vendor.SsoLogon(e.NewContext(newRequest(http.MethodPost, "/sso", reqSso), rec)))
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgSecSquashed), callback(rec).ResponseCode)
+
+	rec = httptest.NewRecorder()
+	assert.NoError(t, vendor.SsoLink(&defs.AuthContext{e.NewContext(newRequest(http.MethodPost, "/on/vendor/sso", reqSso), rec), 1}))
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), callback(rec).ResponseCode)
+
+	rec = httptest.NewRecorder()
+	assert.NoError(t, vendor.SsoLogon(e.NewContext(newRequest(http.MethodPost, "/sso", reqSso), rec)))
+	resCallback := callback(rec)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkVendorAccountNoPrivkeyExistsSso), resCallback.ResponseCode)
+	assert.Equal(t, resCreate.PrivateCode, resCallback.PrivateCode)
+	assert.NotEmpty(t, resCallback.SessionId)
+
+	reqSso.PrivateCode = "YmFk"
+	rec = httptest.NewRecorder()
+	assert.NoError(t, vendor.SsoLogon(e.NewContext(newRequest(http.MethodPost, "/sso", reqSso), rec)))
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkVendorAccountBadPrivkeyExistsSso), callback(rec).ResponseCode)
+
+	reqSso.PrivateCode = resCreate.PrivateCode
+	rec = httptest.NewRecorder()
+	assert.NoError(t, vendor.SsoLogon(e.NewContext(newRequest(http.MethodPost, "/sso", reqSso), rec)))
+	resCallback = callback(rec)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkVendorAccountRecoveredFromSso), resCallback.ResponseCode)
+
+	// renewed session authenticates
+	req := newRequest(http.MethodGet, "/on/vendor", nil)
+	req.Header.Set("Session", resCallback.SessionId)
+	req.Header.Set("Hash", defs.ToHmacSha256(resCallback.SessionPrivate+"637295289927929882", defs.MagicKey))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// Recover account from seed
+func TestRecover(t *testing.T) {
+	setupStore(t)
+	defer teardownStore(t)
+	e := echo.New()
+	route.Init(e)
+	e.Logger.SetLevel(log.DEBUG)
+	post := func(target string, body interface{}, handler echo.HandlerFunc) queue.ResBodyCreate {
+		rec := httptest.NewRecorder()
+		assert.NoError(t, handler(e.NewContext(newRequest(http.MethodPost, target, body), rec)))
+		res := queue.ResBodyCreate{}
+		bodyBytes, _ := ioutil.ReadAll(rec.Body)
+		defs.Decode(bodyBytes, &res, res.Ticks)
+		return res
+	}
+
+	reqBody := newReqBodyCreate()
+	resCreate := createAccount(t, e)
+
+	reqRecover := queue.ReqBodyRecover{}
+	reqRecover.Identifier = reqBody.Identifier
+	reqRecover.Seed = "0000"
+	reqRecover.Ticks = reqBody.Ticks
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgSecSquashed), post("/recover", reqRecover, queue.Recover).ResponseCode)
+
+	reqRecover.Seed = reqBody.Seed
+	reqRecover.PrivateCode = resCreate.PrivateCode
+	resRecover := post("/recover", reqRecover, queue.Recover)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkVendorAccountRecoveredFromSeed), resRecover.ResponseCode)
+	assert.NotEqual(t, resCreate.PrivateCode, resRecover.PrivateCode)
+
+	// rotated private code no longer logs on
+	reqLogon := queue.ReqBodyLogon{}
+	reqLogon.PrivateCode = resCreate.PrivateCode
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgSecSquashed), post("/logon", reqLogon, queue.Logon).ResponseCode)
+	reqLogon.PrivateCode = resRecover.PrivateCode
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), post("/logon", reqLogon, queue.Logon).ResponseCode)
+
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkVendorAccountBadPrivkeyExistsSeed), post("/recover", reqRecover, queue.Recover).ResponseCode)
+	reqRecover.PrivateCode = ""
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkVendorAccountNoPrivkeyExistsSeed), post("/recover", reqRecover, queue.Recover).ResponseCode)
+}
+
+// Stream queue position until no ticket of the account waits
+func TestStreamQueue(t *testing.T) {
+	setupStore(t)
+	defer teardownStore(t)
+	e := echo.New()
+	route.Init(e)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	session := createAccount(t, e)
+	// same request over the wire, signed by the session
+	send := func(method string, target string, body interface{}) *http.Response {
+		req := newRequest(method, server.URL+target, body)
+		req.RequestURI = ""
+		req.Header.Set("Session", session.SessionId)
+		req.Header.Set("Hash", defs.ToHmacSha256(session.SessionPrivate+req.Header.Get("Nonce"), defs.MagicKey))
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return res
+	}
+	call := func(method string, target string, body interface{}, v interface{}) {
+		res := send(method, target, body)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		bodyBytes, _ := ioutil.ReadAll(res.Body)
+		defs.Decode(bodyBytes, v, 0)
+	}
+
+	reqUpdate := vendor.ReqBodyUpdate{}
+	reqUpdate.Name = "vendor sample"
+	resUpdate := vendor.ResBodyUpdate{}
+	call(http.MethodPost, "/on/vendor/upgrade", reqUpdate, &resUpdate)
+	reqEnqueue := queue.ReqBodyEnqueue{}
+	reqEnqueue.VendorCode = resUpdate.VendorCode
+	reqEnqueue.QueueCode = resUpdate.QueueCode
+	resEnqueue := queue.ResBodyEnqueue{}
+	call(http.MethodPost, "/on/queue", reqEnqueue, &resEnqueue)
+
+	r := strings.NewReplacer("=", "-", "/", "_", "+", ".")
+	res := send(http.MethodGet, "/on/queue/"+r.Replace(resUpdate.VendorCode)+"/"+r.Replace(resUpdate.QueueCode)+"/stream", nil)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := bufio.NewReader(res.Body)
+	next := func() queue.ResBodyQueue {
+		line, err := events.ReadString('\n')
+		assert.NoError(t, err)
+		events.ReadString('\n')
+		event := queue.ResBodyQueue{}
+		assert.NoError(t, defs.Decode([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data: "))), &event, 0))
+		return event
+	}
+	event := next()
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkContinue), event.ResponseCode)
+	assert.Equal(t, int(defs.StatusEnqueue), event.Status)
+	assert.Equal(t, 1, event.TotalWaiting)
+
+	reqDummy := vendor.ReqBodyUpdate{}
+	resDummy := vendor.ResBodyEnqueueDummy{}
+	call(http.MethodPost, "/on/vendor/queue/dummy", reqDummy, &resDummy)
+	event = next()
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkContinue), event.ResponseCode)
+	assert.Equal(t, 2, event.TotalWaiting)
+
+	// dummy is of the same account, streamed until it is done as well
+	reqCancel := queue.ReqBodyDequeue{}
+	reqCancel.VendorCode = resUpdate.VendorCode
+	reqCancel.QueueCode = resUpdate.QueueCode
+	reqCancel.KeyCodePrefix = resEnqueue.KeyCodePrefix
+	call(http.MethodPost, "/on/cancel", reqCancel, &queue.ResBodyDequeue{})
+	event = next()
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkContinue), event.ResponseCode)
+	assert.Equal(t, 1, event.TotalWaiting)
+
+	reqDequeue := vendor.ReqBodyDequeue{}
+	reqDequeue.Force = true
+	reqDequeue.KeyCodePrefix = resDummy.KeyCodePrefix
+	call(http.MethodPost, "/on/vendor/dequeue", reqDequeue, &vendor.ResBodyDequeue{})
+	event = next()
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), event.ResponseCode)
+	assert.Equal(t, int(defs.StatusDequeue), event.Status)
+	_, err := events.ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+}
+
+// Vendor with its primary queue for handler scenarios, every call is made as account 1
+type vendorScenario struct {
+	t          *testing.T
+	e          *echo.Echo
+	vendorId   uint64
+	vendorCode string
+	queueCode  string
+}
+
+// Create account 1 and upgrade it to vendor with reqUpdate
+func newVendorScenario(t *testing.T, reqUpdate vendor.ReqBodyUpdate) *vendorScenario {
+	setupStore(t)
+	e := echo.New()
+	route.Init(e)
+	e.Logger.SetLevel(log.DEBUG)
+	s := &vendorScenario{t: t, e: e, vendorId: 1}
+
+	createAccount(t, e)
+	reqUpdate.Name = "vendor sample"
+	reqUpdate.Caption = "caption sample"
+	reqUpdate.Ticks = 1592619000
+	resUpdate := vendor.ResBodyUpdate{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Upgrade, http.MethodPost, "/on/vendor/upgrade", reqUpdate, &resUpdate))
+	s.vendorCode, s.queueCode = resUpdate.VendorCode, resUpdate.QueueCode
+	return s
+}
+
+// Call handler with body, res is decoded from the response. returns the response code before squash
+func (s *vendorScenario) call(handler echo.HandlerFunc, method string, target string, body interface{}, res interface{}, params ...string) defs.ResponseCode {
+	rec := httptest.NewRecorder()
+	c := s.e.NewContext(newRequest(method, target, body), rec)
+	names, values := []string{}, []string{}
+	for i := 0; i+1 < len(params); i += 2 {
+		names, values = append(names, params[i]), append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	assert.NoError(s.t, handler(&defs.AuthContext{c, s.vendorId}))
+	bodyBytes, _ := ioutil.ReadAll(rec.Body)
+	base := defs.ResponseBodyBase{}
+	defs.Decode(bodyBytes, &base, 0)
+	defs.Decode(bodyBytes, res, 0)
+	if code, ok := c.Get(defs.ContextKeyResponseCode).(defs.ResponseCode); ok {
+		return code
+	}
+	return base.ResponseCode
+}
+
+// Join the queue as the user
+func (s *vendorScenario) enqueue() queue.ResBodyEnqueue {
+	reqEnqueue := queue.ReqBodyEnqueue{}
+	reqEnqueue.VendorCode = s.vendorCode
+	reqEnqueue.QueueCode = s.queueCode
+	reqEnqueue.Ticks = 1592619000
+	resEnqueue := queue.ResBodyEnqueue{}
+	assert.Equal(s.t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Enqueue, http.MethodPost, "/on/queue", reqEnqueue, &resEnqueue))
+	return resEnqueue
+}
+
+// Add a waiting ticket by vendor
+func (s *vendorScenario) dummy() vendor.ResBodyEnqueueDummy {
+	resDummy := vendor.ResBodyEnqueueDummy{}
+	assert.Equal(s.t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.EnqueueDummy, http.MethodPost, "/on/vendor/queue/dummy", nil, &resDummy))
+	return resDummy
+}
+
+// Position of the user
+func (s *vendorScenario) showQueue() queue.ResBodyQueue {
+	r := strings.NewReplacer("=", "-", "/", "_", "+", ".")
+	vendorCode, queueCode := r.Replace(s.vendorCode), r.Replace(s.queueCode)
+	resQueue := queue.ResBodyQueue{}
+	assert.Equal(s.t, defs.ResponseCode(defs.ResponseOk), s.call(queue.ShowQueue, http.MethodGet, "/on/queue/"+vendorCode+"/"+queueCode, nil, &resQueue,
+		"vendor_code", vendorCode, "queue_code", queueCode))
+	return resQueue
+}
+
+// Prefixes of the entries in line order
+func (s *vendorScenario) line() []string {
+	rows, err := store.Queues.List(s.vendorId, s.queueCode, []defs.QueueStatus{defs.StatusEnqueue}, 100, 0)
+	assert.NoError(s.t, err)
+	prefixes := []string{}
+	for _, row := range rows {
+		prefixes = append(prefixes, row.KeyCodePrefix)
+	}
+	return prefixes
+}
+
+func (s *vendorScenario) close() {
+	s.call(priv.DropVendor, http.MethodDelete, "/on/priv/vendor", nil, &defs.ResponseBodyBase{})
+	teardownStore(s.t)
+}
+
+// User defers the turn up to the vendor limit
+func TestPendingScenario(t *testing.T) {
+	reqUpdate := vendor.ReqBodyUpdate{}
+	maxPending := uint16(1)
+	reqUpdate.MaxPending = &maxPending
+	s := newVendorScenario(t, reqUpdate)
+	defer s.close()
+	mine := s.enqueue()
+	first := s.dummy()
+	second := s.dummy()
+
+	reqPending := queue.ReqBodyPending{}
+	reqPending.VendorCode = s.vendorCode
+	reqPending.QueueCode = s.queueCode
+	reqPending.KeyCodePrefix = mine.KeyCodePrefix
+	reqPending.Steps = 1
+	reqPending.Ticks = 1592619000
+	resPending := queue.ResBodyPending{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Pending, http.MethodPost, "/on/pending", reqPending, &resPending))
+	assert.Equal(t, 1, resPending.PersonsWaitingBefore)
+	assert.Equal(t, []string{first.KeyCodePrefix, mine.KeyCodePrefix, second.KeyCodePrefix}, s.line())
+
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserCannotPending), s.call(queue.Pending, http.MethodPost, "/on/pending", reqPending, &resPending))
+}
+
+// Vendor parks a ticket and puts it back to its place
+func TestShelveScenario(t *testing.T) {
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	first := s.dummy()
+	second := s.dummy()
+	third := s.dummy()
+
+	reqShelve := vendor.ReqBodyShelve{}
+	reqShelve.KeyCodePrefix = first.KeyCodePrefix
+	reqShelve.Ticks = 1592619000
+	resShelve := vendor.ResBodyShelve{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Shelve, http.MethodPost, "/on/vendor/shelve", reqShelve, &resShelve))
+	assert.True(t, resShelve.Updated)
+	assert.Equal(t, []string{second.KeyCodePrefix, third.KeyCodePrefix}, s.line())
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgVendorAlreadyShelved), s.call(vendor.Shelve, http.MethodPost, "/on/vendor/shelve", reqShelve, &resShelve))
+
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Unshelve, http.MethodPost, "/on/vendor/unshelve", reqShelve, &resShelve))
+	assert.Equal(t, []string{first.KeyCodePrefix, second.KeyCodePrefix, third.KeyCodePrefix}, s.line())
+
+	// the owner cancels while shelved
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Shelve, http.MethodPost, "/on/vendor/shelve", reqShelve, &resShelve))
+	reqCancel := queue.ReqBodyDequeue{}
+	reqCancel.VendorCode = s.vendorCode
+	reqCancel.QueueCode = s.queueCode
+	reqCancel.KeyCodePrefix = first.KeyCodePrefix
+	reqCancel.Ticks = 1592619000
+	resCancel := queue.ResBodyDequeue{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Cancel, http.MethodPost, "/on/cancel", reqCancel, &resCancel))
+	assert.True(t, resCancel.Updated)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgKeyCodeCodeNotfound), s.call(vendor.Unshelve, http.MethodPost, "/on/vendor/unshelve", reqShelve, &resShelve))
+}
+
+// Vendor moves a ticket to the top
+func TestMoveScenario(t *testing.T) {
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	first := s.dummy()
+	second := s.dummy()
+	third := s.dummy()
+
+	reqMove := vendor.ReqBodyMove{}
+	reqMove.KeyCodePrefix = third.KeyCodePrefix
+	reqMove.Move = vendor.MoveTop
+	reqMove.Ticks = 1592619000
+	resMove := vendor.ResBodyMove{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Move, http.MethodPost, "/on/vendor/move", reqMove, &resMove))
+	assert.Equal(t, 0, resMove.PersonsWaitingBefore)
+	assert.Equal(t, []string{third.KeyCodePrefix, first.KeyCodePrefix, second.KeyCodePrefix}, s.line())
+}
+
+// Vendor cancels the user ticket, force dequeue leaves it canceled
+func TestVendorCancelScenario(t *testing.T) {
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	mine := s.enqueue()
+
+	reqCancel := vendor.ReqBodyCancel{}
+	reqCancel.KeyCodePrefix = mine.KeyCodePrefix
+	reqCancel.Reason = defs.CancelReasonNoShow
+	reqCancel.Note = "called twice"
+	reqCancel.Ticks = 1592619000
+	resCancel := vendor.ResBodyCancel{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Cancel, http.MethodPost, "/on/vendor/cancel", reqCancel, &resCancel))
+	assert.True(t, resCancel.Updated)
+	resQueue := s.showQueue()
+	assert.Equal(t, int(defs.StatusVendorCancel), resQueue.Status)
+	assert.Equal(t, int(defs.CancelReasonNoShow), resQueue.CancelReason)
+	assert.Equal(t, "called twice", resQueue.CancelNote)
+
+	reqDequeue := vendor.ReqBodyDequeue{}
+	reqDequeue.Force = true
+	reqDequeue.KeyCodePrefix = mine.KeyCodePrefix
+	reqDequeue.Ticks = 1592619000
+	resDequeue := vendor.ResBodyDequeue{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Dequeue, http.MethodPost, "/on/vendor/dequeue", reqDequeue, &resDequeue))
+	assert.False(t, resDequeue.Updated)
+	assert.Equal(t, int(defs.StatusVendorCancel), s.showQueue().Status)
+}
+
+// Vendor calls and the user confirms on require admit queue
+func TestAdmitScenario(t *testing.T) {
+	reqUpdate := vendor.ReqBodyUpdate{}
+	reqUpdate.RequireAdmit = true
+	admitWindow := uint32(60)
+	reqUpdate.AdmitWindow = &admitWindow
+	s := newVendorScenario(t, reqUpdate)
+	defer s.close()
+	mine := s.enqueue()
+
+	reqVendor := vendor.ReqBodyDequeue{}
+	reqVendor.KeyCodePrefix = mine.KeyCodePrefix
+	reqVendor.KeyCodeSuffix = mine.KeyCodeSuffix
+	reqVendor.Ticks = 1592619000
+	resVendor := vendor.ResBodyDequeue{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOkContinue), s.call(vendor.Dequeue, http.MethodPost, "/on/vendor/dequeue", reqVendor, &resVendor))
+	assert.False(t, resVendor.Updated)
+	resQueue := s.showQueue()
+	assert.Equal(t, int(defs.StatusEnqueue), resQueue.Status)
+	assert.NotZero(t, resQueue.CalledAt)
+
+	reqUser := queue.ReqBodyDequeue{}
+	reqUser.VendorCode = s.vendorCode
+	reqUser.QueueCode = s.queueCode
+	reqUser.KeyCodePrefix = mine.KeyCodePrefix
+	reqUser.Ticks = 1592619000
+	resUser := queue.ResBodyDequeue{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Dequeue, http.MethodPost, "/on/dequeue", reqUser, &resUser))
+	assert.True(t, resUser.Updated)
+	assert.Equal(t, int(defs.StatusDequeue), s.showQueue().Status)
+}
+
+// User turns mail notices on and off
+func TestMailScenario(t *testing.T) {
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	mine := s.enqueue()
+
+	reqMail := queue.ReqBodyMail{}
+	reqMail.VendorCode = s.vendorCode
+	reqMail.QueueCode = s.queueCode
+	reqMail.KeyCodePrefix = mine.KeyCodePrefix
+	reqMail.MailAddr = "user@example.com"
+	reqMail.Ticks = 1592619000
+	resMail := queue.ResBodyMail{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Mail, http.MethodPost, "/on/mail", reqMail, &resMail))
+	assert.True(t, resMail.MailOn)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAlreadyMailOn), s.call(queue.Mail, http.MethodPost, "/on/mail", reqMail, &resMail))
+	targets, err := store.Queues.MailTargets(s.vendorId)
+	assert.NoError(t, err)
+	if assert.Len(t, targets, 1) {
+		assert.Equal(t, mine.KeyCodePrefix, targets[0].KeyCodePrefix)
+		assert.Equal(t, "user@example.com", targets[0].MailAddr)
+		assert.Equal(t, 0, targets[0].Before)
+	}
+
+	reqMail.MailAddr = ""
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Mail, http.MethodPost, "/on/mail", reqMail, &resMail))
+	assert.False(t, resMail.MailOn)
+	targets, err = store.Queues.MailTargets(s.vendorId)
+	assert.NoError(t, err)
+	assert.Empty(t, targets)
+}
+
+// User subscribes to gateway push, a crossed threshold is pushed once
+func TestPushScenario(t *testing.T) {
+	provider := &pushtest.Provider{}
+	push.Default = push.NewWith(map[defs.PushType]push.Provider{defs.PushTypeGateway: provider}, push.Options{MaxPerTicket: push.DefaultMaxPerTicket}, nil)
+	defer func() {
+		push.Default.Close()
+		push.Default = nil
+	}()
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	mine := s.enqueue()
+
+	resSetup := queue.ResBodyPushSetup{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.ShowPush, http.MethodGet, "/on/push", nil, &resSetup))
+	assert.Equal(t, []int{int(defs.PushTypeGateway)}, resSetup.PushTypes)
+
+	reqPush := queue.ReqBodyPush{}
+	reqPush.VendorCode = s.vendorCode
+	reqPush.QueueCode = s.queueCode
+	reqPush.KeyCodePrefix = mine.KeyCodePrefix
+	reqPush.PushType = int(defs.PushTypeWebPush)
+	reqPush.Subscription.Token = "device"
+	reqPush.Ticks = 1592619000
+	resPush := queue.ResBodyPush{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgEncodeInvalid), s.call(queue.Push, http.MethodPost, "/on/push", reqPush, &resPush))
+	reqPush.PushType = int(defs.PushTypeGateway)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Push, http.MethodPost, "/on/push", reqPush, &resPush))
+	targets, err := store.Queues.PushTargets(s.vendorId)
+	assert.NoError(t, err)
+	if assert.Len(t, targets, 1) {
+		assert.Equal(t, mine.KeyCodePrefix, targets[0].KeyCodePrefix)
+		assert.Equal(t, defs.PushType(defs.PushTypeGateway), targets[0].PushType)
+	}
+
+	assert.NoError(t, push.Default.Scan(s.vendorId))
+	sent := provider.Take()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, "device", sent[0].Subscription.Token)
+		assert.Equal(t, 1, sent[0].Notification.Place)
+	}
+	assert.NoError(t, push.Default.Scan(s.vendorId))
+	assert.Empty(t, provider.Take())
+}
+
+// Vendor verifies keycodes across a queue reset
+func TestVerifyScenario(t *testing.T) {
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	mine := s.enqueue()
+
+	reqVerify := vendor.ReqBodyVerify{}
+	reqVerify.KeyCodePrefix = mine.KeyCodePrefix
+	reqVerify.KeyCodeSuffix = mine.KeyCodeSuffix
+	reqVerify.Ticks = 1592619000
+	resVerify := vendor.ResBodyVerify{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Verify, http.MethodPost, "/on/vendor/verify", reqVerify, &resVerify))
+	assert.Equal(t, s.queueCode, resVerify.QueueCode)
+	assert.True(t, resVerify.Current)
+	assert.Equal(t, int(defs.StatusEnqueue), resVerify.Status)
+
+	reqVerify.KeyCodeSuffix = "wrong"
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgSuffixCodeCodeNotfound), s.call(vendor.Verify, http.MethodPost, "/on/vendor/verify", reqVerify, &resVerify))
+
+	// still issued after reset, of the previous generation
+	reqUpdate := vendor.ReqBodyUpdate{}
+	reqUpdate.Name = "vendor sample"
+	reqUpdate.Caption = "caption sample"
+	reqUpdate.RequireInitQueue = true
+	reqUpdate.Ticks = 1592619000
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Update, http.MethodPost, "/on/vendor/update", reqUpdate, &vendor.ResBodyUpdate{}))
+	reqVerify.KeyCodeSuffix = mine.KeyCodeSuffix
+	resVerify = vendor.ResBodyVerify{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(vendor.Verify, http.MethodPost, "/on/vendor/verify", reqVerify, &resVerify))
+	assert.False(t, resVerify.Current)
+	assert.Equal(t, 0, resVerify.Status)
+}
+
+// User cancels and joins again, the position follows the new ticket
+func TestRejoinScenario(t *testing.T) {
+	s := newVendorScenario(t, vendor.ReqBodyUpdate{})
+	defer s.close()
+	mine := s.enqueue()
+
+	reqCancel := queue.ReqBodyDequeue{}
+	reqCancel.VendorCode = s.vendorCode
+	reqCancel.QueueCode = s.queueCode
+	reqCancel.KeyCodePrefix = mine.KeyCodePrefix
+	reqCancel.Ticks = 1592619000
+	resCancel := queue.ResBodyDequeue{}
+	assert.Equal(t, defs.ResponseCode(defs.ResponseOk), s.call(queue.Cancel, http.MethodPost, "/on/cancel", reqCancel, &resCancel))
+	assert.True(t, resCancel.Updated)
+	assert.Equal(t, int(defs.StatusCancel), s.showQueue().Status)
+
+	again := s.enqueue()
+	assert.NotEqual(t, mine.KeyCodePrefix, again.KeyCodePrefix)
+	resQueue := s.showQueue()
+	assert.Equal(t, int(defs.StatusEnqueue), resQueue.Status)
+	assert.Equal(t, 0, resQueue.PersonsWaitingBefore)
+	assert.Equal(t, 1, resQueue.TotalWaiting)
 }
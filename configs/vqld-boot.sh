@@ -61,6 +61,7 @@ do
 done
 
 ${DRYRUN} ${IMAGE_PATH}/${IMAGE_NAME} \
+-config=${ENV_FILE} \
 -config_test=${CONFIG_TEST} \
 -listen_addr=${LISTEN_ADDR} \
 -listen_port=${LISTEN_PORT} \
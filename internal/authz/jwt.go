@@ -0,0 +1,182 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package authz
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// json web key, only RSA keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// id token claims checked on verification
+type claims struct {
+	Issuer   string          `json:"iss"`
+	Subject  string          `json:"sub"`
+	Audience json.RawMessage `json:"aud"`
+	Expire   int64           `json:"exp"`
+	IssuedAt int64           `json:"iat"`
+	Nonce    string          `json:"nonce"`
+	Email    string          `json:"email"`
+}
+
+// verify id token signature (RS256) and claims
+func (p *Provider) verify(meta *metadata, token string, nonce string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("error: malformed id token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("error: id token alg %q not supported", header.Alg)
+	}
+	key, err := p.key(meta, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature); err != nil {
+		return nil, errors.New("error: id token signature mismatch")
+	}
+
+	c := claims{}
+	if err = decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	if c.Issuer != meta.Issuer {
+		return nil, fmt.Errorf("error: id token issuer %q mismatch", c.Issuer)
+	}
+	if !audience(c.Audience, p.opts.ClientId) {
+		return nil, errors.New("error: id token audience mismatch")
+	}
+	now := p.now()
+	if c.Expire == 0 || now.After(time.Unix(c.Expire, 0).Add(ClockSkew)) {
+		return nil, errors.New("error: id token expired")
+	}
+	if c.IssuedAt != 0 && now.Add(ClockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return nil, errors.New("error: id token issued in the future")
+	}
+	if c.Nonce != nonce {
+		return nil, errors.New("error: id token nonce mismatch")
+	}
+	if c.Subject == "" {
+		return nil, errors.New("error: id token lacks subject")
+	}
+	return &Identity{c.Issuer, c.Subject, c.Email}, nil
+}
+
+// signing key by id, JWKS is refetched on unknown key id
+func (p *Provider) key(meta *metadata, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	fresh := p.now().Sub(p.keysAt) < JwksMinRefresh
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if fresh {
+		return nil, fmt.Errorf("error: signing key %q not found", kid)
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := p.getJson(meta.JwksUri, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = p.now()
+	p.mu.Unlock()
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("error: signing key %q not found", kid)
+	}
+	return key, nil
+}
+
+// aud is a string or an array of strings
+func audience(raw json.RawMessage, clientId string) bool {
+	single := ""
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == clientId
+	}
+	multi := []string{}
+	if err := json.Unmarshal(raw, &multi); err != nil {
+		return false
+	}
+	for _, aud := range multi {
+		if aud == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
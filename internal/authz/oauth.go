@@ -22,8 +22,313 @@
 */
 
 // Autho[riz]ation package (not Authen[tic]ation)
+//
+// Vendors link an openid connect identity to their account and log on through it.
+// authorization code flow with PKCE (S256), the id token is verified by the provider JWKS.
 package authz
 
-//import (
-//	"net/http"
-//)
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"vql/internal/config"
+)
+
+const (
+	// authorization must complete within this
+	PendingTimeout = 10 * time.Minute
+	// pending authorizations upper bound, oldest expire first
+	PendingMax = 1 << 16
+	// provider request timeout
+	HttpTimeout = 10 * time.Second
+	// unknown key id refetches JWKS at most once per this
+	JwksMinRefresh = time.Minute
+	// allowed clock difference for token times
+	ClockSkew = time.Minute
+)
+
+var ErrPendingNotFound = errors.New("error: sso authorization not found or expired")
+
+// Provider settings
+type Options struct {
+	// issuer url, discovery is read from issuer/.well-known/openid-configuration
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// callback url registered at the provider
+	RedirectUrl string
+}
+
+// env file keys
+const (
+	KeyIssuer      = "SSO_ISSUER"
+	KeyClientId    = "SSO_CLIENT_ID"
+	KeySecret      = "SSO_CLIENT_SECRET"
+	KeyRedirectUrl = "SSO_REDIRECT_URL"
+)
+
+// Provider options, empty issuer disables sso. problems are recorded to values
+func LoadOptions(values *config.Values) Options {
+	o := Options{
+		Issuer:       values.String(KeyIssuer, ""),
+		ClientId:     values.String(KeyClientId, ""),
+		ClientSecret: values.String(KeySecret, ""),
+		RedirectUrl:  values.String(KeyRedirectUrl, ""),
+	}
+	if o.Issuer != "" {
+		if u, err := url.Parse(o.Issuer); err != nil || !u.IsAbs() || u.Host == "" {
+			values.Problem(KeyIssuer, "must be absolute url, got %q", o.Issuer)
+		}
+		if o.ClientId == "" {
+			values.Problem(KeyClientId, "required with %s", KeyIssuer)
+		}
+		if u, err := url.Parse(o.RedirectUrl); err != nil || !u.IsAbs() || u.Host == "" {
+			values.Problem(KeyRedirectUrl, "must be absolute url, got %q", o.RedirectUrl)
+		}
+	}
+	return o
+}
+
+// Started authorization waiting for callback
+type Pending struct {
+	// account to link, 0 for logon
+	Uid uint64
+	// base64 private code presented on logon, may be empty
+	PrivateCode string
+	verifier    string
+	nonce       string
+	expire      time.Time
+}
+
+// Verified identity
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+// provider metadata from discovery
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// OpenID connect relying party
+type Provider struct {
+	opts    Options
+	client  *http.Client
+	mu      sync.Mutex
+	meta    *metadata
+	keys    map[string]*rsa.PublicKey
+	keysAt  time.Time
+	pending map[string]*Pending
+	now     func() time.Time
+}
+
+// Configured provider, nil when sso is disabled
+var SSO *Provider
+
+// Configure sso provider, empty issuer disables sso
+func Configure(o Options) {
+	if o.Issuer == "" {
+		SSO = nil
+		return
+	}
+	SSO = NewProvider(o)
+}
+
+func NewProvider(o Options) *Provider {
+	return &Provider{
+		opts:    o,
+		client:  &http.Client{Timeout: HttpTimeout},
+		pending: map[string]*Pending{},
+		now:     time.Now,
+	}
+}
+
+func (p *Provider) Options() Options {
+	return p.opts
+}
+
+// Start authorization, returns provider authorize url and state.
+// uid is the account to link, 0 to log on with the linked account.
+func (p *Provider) Begin(uid uint64, privateCode string) (string, string, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return "", "", err
+	}
+	state, err := random()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := random()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := random()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	now := p.now()
+	for key, pending := range p.pending {
+		if now.After(pending.expire) {
+			delete(p.pending, key)
+		}
+	}
+	for len(p.pending) >= PendingMax {
+		oldest := ""
+		for key, pending := range p.pending {
+			if oldest == "" || pending.expire.Before(p.pending[oldest].expire) {
+				oldest = key
+			}
+		}
+		delete(p.pending, oldest)
+	}
+	p.pending[state] = &Pending{uid, privateCode, verifier, nonce, now.Add(PendingTimeout)}
+	p.mu.Unlock()
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.opts.ClientId)
+	query.Set("redirect_uri", p.opts.RedirectUrl)
+	query.Set("scope", "openid email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Complete authorization on callback, the state is consumed even on failure
+func (p *Provider) Complete(state string, code string) (*Pending, *Identity, error) {
+	p.mu.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || p.now().After(pending.expire) {
+		return nil, nil, ErrPendingNotFound
+	}
+	meta, err := p.discover()
+	if err != nil {
+		return nil, nil, err
+	}
+	idToken, err := p.exchange(meta, code, pending.verifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity, err := p.verify(meta, idToken, pending.nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pending, identity, nil
+}
+
+// read provider metadata once
+func (p *Provider) discover() (*metadata, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+	meta = &metadata{}
+	if err := p.getJson(strings.TrimSuffix(p.opts.Issuer, "/")+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != p.opts.Issuer {
+		return nil, fmt.Errorf("error: discovered issuer %q does not match %q", meta.Issuer, p.opts.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JwksUri == "" {
+		return nil, errors.New("error: provider metadata lacks endpoints")
+	}
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+// exchange authorization code for id token
+func (p *Provider) exchange(meta *metadata, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.opts.RedirectUrl)
+	form.Set("client_id", p.opts.ClientId)
+	form.Set("code_verifier", verifier)
+	if p.opts.ClientSecret != "" {
+		form.Set("client_secret", p.opts.ClientSecret)
+	}
+	res, err := p.client.PostForm(meta.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	token := struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err = json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("error: token response status %d: %s", res.StatusCode, err)
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("error: token request failed %d %s %s", res.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IdToken == "" {
+		return "", errors.New("error: token response lacks id_token")
+	}
+	return token.IdToken, nil
+}
+
+func (p *Provider) getJson(u string, v interface{}) error {
+	res, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error: %s status %d", u, res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// PKCE S256 code challenge
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// url safe random string, 256 bits
+func random() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,158 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"vql/internal/authz/idptest"
+	"vql/internal/config"
+)
+
+func TestAuthorizationCode(t *testing.T) {
+	idp := idptest.NewServer("vql")
+	defer idp.Close()
+	idp.Subject = "vendor-1"
+	idp.Email = "vendor@example.com"
+	p := NewProvider(Options{Issuer: idp.URL, ClientId: "vql", RedirectUrl: "https://vql.example.com/sso/callback"})
+
+	authorizeUrl, state, err := p.Begin(10, "private")
+	assert.NoError(t, err)
+	callback, err := idp.Authorize(authorizeUrl)
+	assert.NoError(t, err)
+	assert.Equal(t, state, callback.Query().Get("state"))
+
+	pending, identity, err := p.Complete(state, callback.Query().Get("code"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), pending.Uid)
+	assert.Equal(t, "private", pending.PrivateCode)
+	assert.Equal(t, Identity{idp.URL, "vendor-1", "vendor@example.com"}, *identity)
+
+	// state is single use
+	_, _, err = p.Complete(state, callback.Query().Get("code"))
+	assert.Equal(t, ErrPendingNotFound, err)
+
+	// expired authorization
+	authorizeUrl, state, err = p.Begin(0, "")
+	assert.NoError(t, err)
+	callback, err = idp.Authorize(authorizeUrl)
+	assert.NoError(t, err)
+	p.now = func() time.Time { return time.Now().Add(PendingTimeout + time.Second) }
+	_, _, err = p.Complete(state, callback.Query().Get("code"))
+	assert.Equal(t, ErrPendingNotFound, err)
+	p.now = time.Now
+
+	// code verifier mismatch is rejected by the provider
+	authorizeUrl, state, err = p.Begin(0, "")
+	assert.NoError(t, err)
+	u, _ := url.Parse(authorizeUrl)
+	q := u.Query()
+	q.Set("code_challenge", Challenge("other"))
+	u.RawQuery = q.Encode()
+	callback, err = idp.Authorize(u.String())
+	assert.NoError(t, err)
+	_, _, err = p.Complete(state, callback.Query().Get("code"))
+	assert.Error(t, err)
+}
+
+func TestPendingEviction(t *testing.T) {
+	idp := idptest.NewServer("vql")
+	defer idp.Close()
+	p := NewProvider(Options{Issuer: idp.URL, ClientId: "vql", RedirectUrl: "https://vql.example.com/sso/callback"})
+
+	// full table evicts the earliest expire, not an arbitrary entry
+	now := time.Now()
+	for i := 0; i < PendingMax; i++ {
+		p.pending[strconv.Itoa(i)] = &Pending{expire: now.Add(PendingTimeout - time.Duration(PendingMax-i)*time.Millisecond)}
+	}
+	_, state, err := p.Begin(0, "")
+	assert.NoError(t, err)
+	assert.Len(t, p.pending, PendingMax)
+	assert.NotContains(t, p.pending, "0")
+	assert.Contains(t, p.pending, "1")
+	assert.Contains(t, p.pending, state)
+}
+
+func TestVerify(t *testing.T) {
+	idp := idptest.NewServer("vql")
+	defer idp.Close()
+	p := NewProvider(Options{Issuer: idp.URL, ClientId: "vql", RedirectUrl: "https://vql.example.com/sso/callback"})
+	meta, err := p.discover()
+	assert.NoError(t, err)
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": idp.URL, "sub": "s", "aud": []string{"other", "vql"}, "nonce": "n",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	identity, err := p.verify(meta, idp.Sign(claims()), "n")
+	assert.NoError(t, err)
+	assert.Equal(t, "s", identity.Subject)
+
+	_, err = p.verify(meta, idp.Sign(claims()), "other")
+	assert.Error(t, err)
+	c := claims()
+	c["aud"] = "other"
+	_, err = p.verify(meta, idp.Sign(c), "n")
+	assert.Error(t, err)
+	c = claims()
+	c["iss"] = "https://evil.example.com"
+	_, err = p.verify(meta, idp.Sign(c), "n")
+	assert.Error(t, err)
+	c = claims()
+	c["exp"] = time.Now().Add(-ClockSkew - time.Minute).Unix()
+	_, err = p.verify(meta, idp.Sign(c), "n")
+	assert.Error(t, err)
+
+	// tampered signature
+	token := idp.Sign(claims())
+	other := idp.Sign(map[string]interface{}{"iss": idp.URL, "sub": "t"})
+	_, err = p.verify(meta, token[:len(token)-10]+other[len(other)-10:], "n")
+	assert.Error(t, err)
+}
+
+// Issuer requires client id and redirect url
+func TestLoadOptions(t *testing.T) {
+	values := config.NewValues(map[string]string{})
+	assert.Equal(t, Options{}, LoadOptions(values))
+	assert.NoError(t, values.Err())
+
+	values = config.NewValues(map[string]string{KeyIssuer: "https://idp.example.com"})
+	LoadOptions(values)
+	assert.Error(t, values.Err())
+	assert.Len(t, strings.Split(values.Err().Error(), "\n"), 2)
+
+	values = config.NewValues(map[string]string{
+		KeyIssuer:      "https://idp.example.com",
+		KeyClientId:    "vql",
+		KeyRedirectUrl: "https://vql.example.com/sso/callback",
+	})
+	assert.Equal(t, "vql", LoadOptions(values).ClientId)
+	assert.NoError(t, values.Err())
+}
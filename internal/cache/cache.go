@@ -23,3 +23,145 @@
 
 // Cache server cluster access package
 package cache
+
+import (
+	"time"
+	"vql/internal/config"
+)
+
+// default local entries
+const DefaultSize = 10000
+
+// default entry lifetime
+const DefaultTTL = time.Minute
+
+// local entry lifetime when a remote backend is shared by other instances,
+// bounds how long an invalidation on another instance stays unseen here
+const LocalTTL = 5 * time.Second
+
+// Key value backend
+type Backend interface {
+	// value and whether found
+	Get(key string) (string, bool, error)
+	Set(key string, value string, ttl time.Duration) error
+	Delete(keys ...string) error
+	Close() error
+}
+
+// Cache settings
+type Options struct {
+	// local lru entries, 0 means no local cache
+	Size int
+	// entry lifetime
+	TTL time.Duration
+	// remote server speaking redis protocol, empty means local only
+	Addr string
+}
+
+// env file keys
+const (
+	KeySize = "CACHE_SIZE"
+	KeyTTL  = "CACHE_TTL"
+	KeyAddr = "CACHE_ADDR"
+)
+
+// Cache options, ttl is given in seconds. problems are recorded to values
+func LoadOptions(values *config.Values) Options {
+	o := Options{
+		Size: values.Int(KeySize, DefaultSize),
+		TTL:  time.Duration(values.Int(KeyTTL, int(DefaultTTL/time.Second))) * time.Second,
+		Addr: values.String(KeyAddr, ""),
+	}
+	if o.Size < 0 {
+		values.Problem(KeySize, "must be 0 or more, got %d", o.Size)
+	}
+	if o.TTL < time.Second {
+		values.Problem(KeyTTL, "must be 1 or more, got %d", o.TTL/time.Second)
+	}
+	if o.Addr != "" {
+		if err := config.ValidateAddr(o.Addr); err != nil {
+			values.Problem(KeyAddr, "%s", err)
+		}
+	}
+	return o
+}
+
+// Two tier cache, local lru in front of optional remote backend.
+// backend failures are misses, the cache never fails a request.
+type Cache struct {
+	local  *LRU
+	remote Backend
+	ttl    time.Duration
+}
+
+// New cache, nil if neither local nor remote is configured
+func New(o Options) *Cache {
+	if o.TTL <= 0 {
+		o.TTL = DefaultTTL
+	}
+	var remote Backend
+	if o.Addr != "" {
+		remote = NewRemote(o.Addr)
+	}
+	return NewWith(o.Size, o.TTL, remote)
+}
+
+// New cache over given remote backend, remote may be nil
+func NewWith(size int, ttl time.Duration, remote Backend) *Cache {
+	if size <= 0 && remote == nil {
+		return nil
+	}
+	c := &Cache{remote: remote, ttl: ttl}
+	if size > 0 {
+		localTTL := ttl
+		if remote != nil && localTTL > LocalTTL {
+			localTTL = LocalTTL
+		}
+		c.local = NewLRU(size, localTTL)
+	}
+	return c
+}
+
+func (c *Cache) Get(key string) (string, bool) {
+	if c.local != nil {
+		if value, ok := c.local.Get(key); ok {
+			return value, true
+		}
+	}
+	if c.remote == nil {
+		return "", false
+	}
+	value, ok, err := c.remote.Get(key)
+	if err != nil || !ok {
+		return "", false
+	}
+	if c.local != nil {
+		c.local.Set(key, value)
+	}
+	return value, true
+}
+
+func (c *Cache) Set(key string, value string) {
+	if c.local != nil {
+		c.local.Set(key, value)
+	}
+	if c.remote != nil {
+		c.remote.Set(key, value, c.ttl)
+	}
+}
+
+func (c *Cache) Delete(keys ...string) {
+	if c.local != nil {
+		c.local.Delete(keys...)
+	}
+	if c.remote != nil {
+		c.remote.Delete(keys...)
+	}
+}
+
+func (c *Cache) Close() error {
+	if c.remote != nil {
+		return c.remote.Close()
+	}
+	return nil
+}
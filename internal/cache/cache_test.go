@@ -0,0 +1,134 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// minimal redis stand-in, GET SET DEL only, ttl is ignored
+func serveStandIn(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					reply, err := ReadReply(r)
+					if err != nil {
+						return
+					}
+					args := []string{}
+					for _, arg := range reply.([]interface{}) {
+						args = append(args, arg.(string))
+					}
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "GET":
+						if value, ok := data[args[1]]; ok {
+							conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+						} else {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						data[args[1]] = args[2]
+						conn.Write([]byte("+OK\r\n"))
+					case "DEL":
+						for _, key := range args[1:] {
+							delete(data, key)
+						}
+						conn.Write([]byte(":1\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+					mu.Unlock()
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLRU(2, time.Minute)
+	l.now = func() time.Time { return now }
+	l.Set("a", "1")
+	l.Set("b", "2")
+	l.Get("a")
+	l.Set("c", "3")
+	_, ok := l.Get("b")
+	assert.False(t, ok, "least recently used is evicted")
+	value, ok := l.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	now = now.Add(time.Minute)
+	_, ok = l.Get("a")
+	assert.False(t, ok, "expired")
+	assert.Equal(t, 1, l.Len())
+}
+
+func TestRemote(t *testing.T) {
+	addr, stop := serveStandIn(t)
+	defer stop()
+	remote := NewRemote(addr)
+	c := NewWith(10, time.Minute, remote)
+	defer c.Close()
+	other := NewWith(10, time.Minute, NewRemote(addr))
+	defer other.Close()
+
+	c.Set("key", "v")
+	value, ok := other.Get("key")
+	assert.True(t, ok, "shared through remote")
+	assert.Equal(t, "v", value)
+	c.Delete("key")
+	_, ok, err := remote.Get("key")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = remote.do("PING")
+	assert.Error(t, err)
+	assert.NoError(t, remote.Set("after", "error", time.Second), "connection kept after error reply")
+
+	stop()
+	remote.Close()
+	_, ok = NewWith(0, time.Minute, remote).Get("after")
+	assert.False(t, ok, "unreachable remote is a miss")
+	assert.Nil(t, New(Options{}))
+}
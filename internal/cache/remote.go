@@ -0,0 +1,188 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// remote dial and io timeout
+const RemoteTimeout = 500 * time.Millisecond
+
+// Redis protocol (RESP) backend, one connection redialed on failure
+type Remote struct {
+	mu      sync.Mutex
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	r       *bufio.Reader
+}
+
+// Remote backend at addr, connects on first use
+func NewRemote(addr string) *Remote {
+	return &Remote{addr: addr, timeout: RemoteTimeout}
+}
+
+func (r *Remote) Get(key string) (string, bool, error) {
+	reply, err := r.do("GET", key)
+	if err != nil {
+		return "", false, err
+	}
+	if reply == nil {
+		return "", false, nil
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", false, fmt.Errorf("error: unexpected GET reply %v", reply)
+	}
+	return value, true, nil
+}
+
+func (r *Remote) Set(key string, value string, ttl time.Duration) error {
+	_, err := r.do("SET", key, value, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	return err
+}
+
+func (r *Remote) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := r.do(append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+func (r *Remote) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeLocked()
+}
+
+func (r *Remote) closeLocked() error {
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn, r.r = nil, nil
+	return err
+}
+
+// send command and read reply, connection is dropped on io errors
+func (r *Remote) do(args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+		if err != nil {
+			return nil, err
+		}
+		r.conn, r.r = conn, bufio.NewReader(conn)
+	}
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+	if _, err := r.conn.Write(EncodeCommand(args...)); err != nil {
+		r.closeLocked()
+		return nil, err
+	}
+	reply, err := ReadReply(r.r)
+	var replyErr ReplyError
+	if err != nil && !errors.As(err, &replyErr) {
+		r.closeLocked()
+	}
+	return reply, err
+}
+
+// Error reply from server
+type ReplyError string
+
+func (e ReplyError) Error() string {
+	return "error: remote cache " + string(e)
+}
+
+// Encode command as array of bulk strings
+func EncodeCommand(args ...string) []byte {
+	b := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b = append(b, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	return b
+}
+
+// Read one reply, nil bulk is nil, integers are int64, arrays are []interface{}
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("error: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, ReplyError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("error: unknown reply %q", line)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("error: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
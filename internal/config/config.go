@@ -0,0 +1,390 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Service configuration package
+//
+// Settings are resolved in this order, later wins:
+//
+//	built-in defaults < env file (configs/vqld.env) < environment variables < command line flags
+//
+// Settings of the service itself are parsed here, other packages read their own
+// settings from the resolved Values with their Options loaders.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/labstack/gommon/log"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// default env file path, same as configs/vqld-boot.sh
+	DefaultFile = "/etc/sysconfig/vqld.env"
+	// default log directory
+	DefaultLogDir = "/var/log/vqld/"
+	// default log level ... 2=warn
+	DefaultLogLevel = 2
+	// default listen addr
+	DefaultListenAddr = "0.0.0.0"
+	// default listen port
+	DefaultListenPort = 7000
+	// default journal file name under log directory
+	DefaultJournalFile = "journal.jsonl"
+)
+
+// env file keys of the service itself and of command line flags
+const (
+	KeyConfigTest    = "CONFIG_TEST"
+	KeyVersionOnly   = "VERSIONONLY"
+	KeyStandbyMode   = "STANDBY_MODE"
+	KeyReplayMode    = "REPLAY_MODE"
+	KeyJournalRecord = "JOURNAL_RECORD"
+	KeyJournalFile   = "JOURNAL_FILE"
+	KeyLogLevel      = "LOG_LEVEL"
+	KeyLogDirectory  = "LOG_DIRECTORY"
+	KeyListenAddr    = "LISTEN_ADDR"
+	KeyListenPort    = "LISTEN_PORT"
+)
+
+// command line flag -> key
+type flagDef struct {
+	name   string
+	key    string
+	isBool bool
+	usage  string
+}
+
+var flagDefs = []flagDef{
+	{"config_test", KeyConfigTest, true, "validate configuration and exit"},
+	{"versiononly", KeyVersionOnly, true, "print version and exit"},
+	{"standbymode", KeyStandbyMode, false, "0=allcold, 1<standbymode is pre wake room, -1=allhot"},
+	{"repmode", KeyReplayMode, true, "replay mode ... false=off, true=on "},
+	{"record", KeyJournalRecord, true, "record requests to journal ... false=off, true=on"},
+	{"journal", KeyJournalFile, false, "journal file to record or replay, default logdir/" + DefaultJournalFile},
+	{"loglevel", KeyLogLevel, false, "0=none, 1=error 2=warn, 3=info, 4=debug"},
+	{"logdir", KeyLogDirectory, false, "base log directory"},
+	{"listen_addr", KeyListenAddr, false, "http service listen host"},
+	{"listen_port", KeyListenPort, false, "http service port"},
+}
+
+// Service configuration
+type Config struct {
+	File          string
+	ConfigTest    bool
+	VersionOnly   bool
+	RepMode       bool
+	JournalRecord bool
+	JournalFile   string
+	LogLevel      int
+	LogDir        string
+	ListenAddr    string
+	ListenPort    int
+	// every resolved setting, read by the packages owning them
+	Values *Values
+}
+
+// Create config filled with built-in defaults
+func Default() *Config {
+	return &Config{
+		File:       DefaultFile,
+		LogLevel:   DefaultLogLevel,
+		LogDir:     DefaultLogDir,
+		ListenAddr: DefaultListenAddr,
+		ListenPort: DefaultListenPort,
+		Values:     NewValues(map[string]string{}),
+	}
+}
+
+// flag value accepting empty string, as passed by configs/vqld-boot.sh
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string   { return f.value }
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+func (f *flagValue) Set(s string) error {
+	f.value = s
+	return nil
+}
+
+// Load configuration from command line args, env file and environment variables.
+// invalid values are reported by Validate.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("vqld", flag.ContinueOnError)
+	file := fs.String("config", DefaultFile, "env file path")
+	values := map[string]*flagValue{}
+	for _, d := range flagDefs {
+		v := &flagValue{isBool: d.isBool}
+		values[d.name] = v
+		fs.Var(v, d.name, d.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	explicitFile := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicitFile = true
+		}
+	})
+
+	c := Default()
+	c.File = *file
+	env, err := ReadFile(c.File)
+	if err != nil {
+		if !os.IsNotExist(err) || explicitFile {
+			return nil, err
+		}
+		env = map[string]string{}
+	}
+	for _, kv := range os.Environ() {
+		if eq := strings.Index(kv, "="); eq > 0 {
+			env[kv[:eq]] = kv[eq+1:]
+		}
+	}
+	for _, d := range flagDefs {
+		// empty flag value means "not set", e.g. -listen_port= from the boot script.
+		if v := values[d.name].value; v != "" {
+			env[d.key] = v
+		}
+	}
+	c.Values = NewValues(env)
+	c.ConfigTest = c.Values.Bool(KeyConfigTest)
+	c.VersionOnly = c.Values.Bool(KeyVersionOnly)
+	c.RepMode = c.Values.Bool(KeyReplayMode)
+	c.JournalRecord = c.Values.Bool(KeyJournalRecord)
+	c.JournalFile = c.Values.String(KeyJournalFile, c.JournalFile)
+	c.LogLevel = c.Values.Int(KeyLogLevel, c.LogLevel)
+	c.LogDir = c.Values.String(KeyLogDirectory, c.LogDir)
+	c.ListenAddr = c.Values.String(KeyListenAddr, c.ListenAddr)
+	c.ListenPort = c.Values.Int(KeyListenPort, c.ListenPort)
+	return c, nil
+}
+
+// Read shell style env file
+func ReadFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse shell style env, supports NAME=value, declare -a NAME=() and NAME[n]=value
+func Parse(r io.Reader) (map[string]string, error) {
+	env := map[string]string{}
+	arrays := map[string][]string{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		line = strings.TrimPrefix(line, "declare -a ")
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("env line %d: missing '=': %s", lineNo, line)
+		}
+		name, value := strings.TrimSpace(line[:eq]), unquote(strings.TrimSpace(line[eq+1:]))
+		if open := strings.Index(name, "["); open >= 0 {
+			if !strings.HasSuffix(name, "]") {
+				return nil, fmt.Errorf("env line %d: bad array index: %s", lineNo, name)
+			}
+			index, err := strconv.Atoi(name[open+1 : len(name)-1])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("env line %d: bad array index: %s", lineNo, name)
+			}
+			name = name[:open]
+			for len(arrays[name]) <= index {
+				arrays[name] = append(arrays[name], "")
+			}
+			arrays[name][index] = value
+			continue
+		}
+		if value == "()" {
+			arrays[name] = []string{}
+			continue
+		}
+		env[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for name, values := range arrays {
+		env[name] = strings.Join(values, " ")
+	}
+	return env, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Resolved settings, invalid values are collected and returned at once by Err
+type Values struct {
+	env      map[string]string
+	problems []string
+}
+
+func NewValues(env map[string]string) *Values {
+	return &Values{env: env}
+}
+
+// String value, def when unset
+func (v *Values) String(key string, def string) string {
+	if s, ok := v.env[key]; ok {
+		return s
+	}
+	return def
+}
+
+// Int value, def when unset or invalid
+func (v *Values) Int(key string, def int) int {
+	s, ok := v.env[key]
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		v.Problem(key, "invalid value %q", s)
+		return def
+	}
+	return n
+}
+
+// Bool value, unset and empty are false
+func (v *Values) Bool(key string) bool {
+	s := v.env[key]
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.Problem(key, "invalid value %q", s)
+	}
+	return b
+}
+
+// List value separated by space or comma, def when unset
+func (v *Values) List(key string, def []string) []string {
+	s, ok := v.env[key]
+	if !ok {
+		return def
+	}
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
+}
+
+// Record problem of key
+func (v *Values) Problem(key string, format string, args ...interface{}) {
+	v.problems = append(v.problems, key+": "+fmt.Sprintf(format, args...))
+}
+
+// All problems recorded so far, nil if none
+func (v *Values) Err() error {
+	if len(v.problems) > 0 {
+		return errors.New(strings.Join(v.problems, "\n"))
+	}
+	return nil
+}
+
+// Validate configuration, returns every problem recorded by Load and the Options loaders at once
+func (c *Config) Validate() error {
+	if c.LogLevel < 0 || c.LogLevel > 4 {
+		c.Values.Problem(KeyLogLevel, "must be 0-4, got %d", c.LogLevel)
+	}
+	if c.LogDir == "" {
+		c.Values.Problem(KeyLogDirectory, "must not be empty")
+	}
+	if c.RepMode && c.JournalRecord {
+		c.Values.Problem(KeyJournalRecord, "cannot record in replay mode")
+	}
+	if c.ListenAddr == "" {
+		c.Values.Problem(KeyListenAddr, "must not be empty")
+	}
+	if c.ListenPort < 1 || c.ListenPort > 65535 {
+		c.Values.Problem(KeyListenPort, "must be 1-65535, got %d", c.ListenPort)
+	}
+	return c.Values.Err()
+}
+
+// Check host:port address
+func ValidateAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", addr)
+	}
+	if host == "" {
+		return fmt.Errorf("missing host in %q", addr)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+	return nil
+}
+
+// Http listen address
+func (c *Config) Listen() string {
+	return net.JoinHostPort(c.ListenAddr, strconv.Itoa(c.ListenPort))
+}
+
+// Map LOG_LEVEL 0-4 (none, error, warn, info, debug) to echo logger level
+func (c *Config) Lvl() log.Lvl {
+	switch c.LogLevel {
+	case 0:
+		return log.OFF
+	case 1:
+		return log.ERROR
+	case 2:
+		return log.WARN
+	case 3:
+		return log.INFO
+	default:
+		return log.DEBUG
+	}
+}
+
+// Journal file path
+func (c *Config) JournalPath() string {
+	if c.JournalFile != "" {
+		return c.JournalFile
+	}
+	return filepath.Join(c.LogDir, DefaultJournalFile)
+}
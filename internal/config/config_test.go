@@ -0,0 +1,144 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleEnv = `
+# comment
+LOG_LEVEL=3
+LOG_DIRECTORY=/tmp/vqld
+LISTEN_ADDR=127.0.0.1
+LISTEN_PORT=7100
+DB_USER=vql_user
+DB_PASS="secret"
+DB_MASTER_ADDR=db-master:3306
+
+declare -a DB_SHARD_ADDR_PRI=()
+declare -a DB_SHARD_ADDR_SEC=()
+DB_SHARD_ADDR_PRI[0]=shard0-a:3306
+DB_SHARD_ADDR_PRI[1]=shard1-a:3306
+DB_SHARD_ADDR_SEC[0]=shard0-b:3306
+DB_SHARD_ADDR_SEC[1]=shard1-b:3306
+`
+
+func writeEnv(t *testing.T, body string) string {
+	dir, err := ioutil.TempDir("", "vqld-config")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "vqld.env")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(body), 0644))
+	return path
+}
+
+// Parse shell style env file
+func TestParse(t *testing.T) {
+	env, err := Parse(strings.NewReader(sampleEnv))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", env[KeyLogLevel])
+	assert.Equal(t, "secret", env["DB_PASS"])
+	assert.Equal(t, "shard0-a:3306 shard1-a:3306", env["DB_SHARD_ADDR_PRI"])
+	assert.Equal(t, "shard0-b:3306 shard1-b:3306", env["DB_SHARD_ADDR_SEC"])
+
+	_, err = Parse(strings.NewReader("NOEQUAL\n"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("X[a]=1\n"))
+	assert.Error(t, err)
+}
+
+// Env file < environment variables < flags
+func TestLoadPrecedence(t *testing.T) {
+	path := writeEnv(t, sampleEnv)
+	defer os.RemoveAll(filepath.Dir(path))
+	os.Setenv(KeyListenPort, "7200")
+	os.Setenv(KeyLogLevel, "1")
+	defer os.Unsetenv(KeyListenPort)
+	defer os.Unsetenv(KeyLogLevel)
+
+	c, err := Load([]string{"-config=" + path, "-listen_port=7300", "-listen_addr=", "-config_test=", "-versiononly="})
+	assert.NoError(t, err)
+	assert.Equal(t, 7300, c.ListenPort)
+	assert.Equal(t, "127.0.0.1", c.ListenAddr)
+	assert.Equal(t, 1, c.LogLevel)
+	assert.Equal(t, "/tmp/vqld", c.LogDir)
+	assert.False(t, c.ConfigTest)
+	assert.False(t, c.VersionOnly)
+	assert.Equal(t, []string{"shard0-a:3306", "shard1-a:3306"}, c.Values.List("DB_SHARD_ADDR_PRI", nil))
+	assert.Equal(t, "127.0.0.1:7300", c.Listen())
+	assert.NoError(t, c.Validate())
+
+	c, err = Load([]string{"-config=" + path, "-config_test"})
+	assert.NoError(t, err)
+	assert.True(t, c.ConfigTest)
+}
+
+// Missing explicit env file is an error, bad values are reported by Validate
+func TestLoadErrors(t *testing.T) {
+	_, err := Load([]string{"-config=/nonexistent/vqld.env"})
+	assert.Error(t, err)
+
+	path := writeEnv(t, "LISTEN_PORT=abc\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	c, err := Load([]string{"-config=" + path})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultListenPort, c.ListenPort)
+	assert.EqualError(t, c.Validate(), `LISTEN_PORT: invalid value "abc"`)
+}
+
+// Validate collects every problem, including the ones recorded by other packages
+func TestValidate(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Validate())
+
+	c.ListenPort = 0
+	c.LogLevel = 9
+	c.Values.Problem("DB_MASTER_ADDR", "missing port")
+	err := c.Validate()
+	assert.Error(t, err)
+	lines := strings.Split(err.Error(), "\n")
+	assert.Equal(t, []string{"DB_MASTER_ADDR: missing port", "LOG_LEVEL: must be 0-4, got 9", "LISTEN_PORT: must be 1-65535, got 0"}, lines)
+}
+
+// Typed values fall back to defaults when unset
+func TestValues(t *testing.T) {
+	v := NewValues(map[string]string{"N": "3", "B": "true", "L": "a, b c", "E": ""})
+	assert.Equal(t, 3, v.Int("N", 1))
+	assert.Equal(t, 1, v.Int("X", 1))
+	assert.True(t, v.Bool("B"))
+	assert.False(t, v.Bool("E"))
+	assert.Equal(t, []string{"a", "b", "c"}, v.List("L", nil))
+	assert.Equal(t, []string{"d"}, v.List("X", []string{"d"}))
+	assert.Equal(t, "", v.String("E", "def"))
+	assert.Equal(t, "def", v.String("X", "def"))
+	assert.NoError(t, v.Err())
+
+	assert.Equal(t, 1, v.Int("B", 1))
+	assert.EqualError(t, v.Err(), `B: invalid value "true"`)
+}
@@ -25,10 +25,12 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"database/sql"
 	"github.com/jmoiron/sqlx"
+	"sync"
 	"time"
 	"vql/internal/defs"
 )
@@ -67,18 +69,63 @@ const (
 )
 
 type Conn struct {
-	master *sqlx.DB
-	shard  [ShardDivide]*sqlx.DB
-	user   string
-	pass   string
+	mu            sync.RWMutex
+	master        *sqlx.DB
+	shard         [ShardDivide]*shardPair
+	user          string
+	pass          string
+	masterAddr    string
+	shardAddrs    []string
+	shardAddrsSec []string
+	standby       int
+	stop          chan struct{}
+}
+
+// Connection settings, see configs/vqld.env
+type Options struct {
+	User       string
+	Pass       string
+	MasterAddr string
+	// shard primary server addrs, shard n is placed on ShardAddrs[n % len(ShardAddrs)]
+	ShardAddrs []string
+	// shard secondary server addrs, pairs with ShardAddrs by index. empty means no failover.
+	ShardAddrsSec []string
+	// shard warm-up, -1=allhot 0=allcold n=shard 0..n-1 are opened at boot, others on first access
+	Standby int
+}
+
+var Conns = Conn{user: DefaultUser, pass: DefaultPass, masterAddr: MasterAddr + ":" + MasterPort, shardAddrs: []string{ShardAddr + ":" + ShardPort}, standby: StandbyAllHot}
+var OpConns = Conn{user: OperateUser, pass: OperatePass, masterAddr: MasterAddr + ":" + MasterPort, shardAddrs: []string{ShardAddr + ":" + ShardPort}, standby: StandbyAllCold}
+
+// Apply connection settings, call before Init
+func (d *Conn) Configure(o Options) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.user = o.User
+	d.pass = o.Pass
+	d.masterAddr = o.MasterAddr
+	d.shardAddrs = append([]string{}, o.ShardAddrs...)
+	d.shardAddrsSec = append([]string{}, o.ShardAddrsSec...)
+	d.standby = o.Standby
+}
+
+// Resolve shard primary server addr
+func (d *Conn) shardAddr(num int) string {
+	return d.shardAddrs[num%len(d.shardAddrs)]
+}
+
+// Resolve shard secondary server addr, empty if not configured
+func (d *Conn) shardAddrSec(num int) string {
+	index := num % len(d.shardAddrs)
+	if index >= len(d.shardAddrsSec) {
+		return ""
+	}
+	return d.shardAddrsSec[index]
 }
 
-var Conns = Conn{user: DefaultUser, pass: DefaultPass}
-var OpConns = Conn{user: OperateUser, pass: OperatePass}
-
-// Setup
+// Setup, databases are created on primaries only. secondaries are expected to replicate.
 func Setup() error {
-	master, err := sqlx.Open(Name, OperateUser+":"+OperatePass+"@tcp("+MasterAddr+":"+MasterPort+")/")
+	master, err := sqlx.Open(Name, OpConns.user+":"+OpConns.pass+"@tcp("+OpConns.masterAddr+")/")
 	if err != nil {
 		return err
 	}
@@ -119,10 +166,15 @@ func Setup() error {
 		return err
 	}
 	_, err = stmt.Exec()
+	stmt, err = tx.Preparex(CreateSsoQuery())
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec()
 	err = tx.Commit()
 
 	for i := 0; i < ShardDivide; i++ {
-		shard, err := sqlx.Open(Name, OperateUser+":"+OperatePass+"@tcp("+ShardAddr+":"+ShardPort+")/")
+		shard, err := sqlx.Open(Name, OpConns.user+":"+OpConns.pass+"@tcp("+OpConns.shardAddr(i)+")/")
 		if err != nil {
 			return err
 		}
@@ -146,7 +198,7 @@ func Setup() error {
 
 // Teardown
 func Teardown() error {
-	master, err := sqlx.Open(Name, OperateUser+":"+OperatePass+"@tcp("+MasterAddr+":"+MasterPort+")/")
+	master, err := sqlx.Open(Name, OpConns.user+":"+OpConns.pass+"@tcp("+OpConns.masterAddr+")/")
 	if err != nil {
 		return err
 	}
@@ -154,7 +206,7 @@ func Teardown() error {
 	defer master.Close()
 
 	for i := 0; i < ShardDivide; i++ {
-		shard, err := sqlx.Open(Name, OperateUser+":"+OperatePass+"@tcp("+ShardAddr+":"+ShardPort+")/")
+		shard, err := sqlx.Open(Name, OpConns.user+":"+OpConns.pass+"@tcp("+OpConns.shardAddr(i)+")/")
 		if err != nil {
 			return err
 		}
@@ -164,41 +216,110 @@ func Teardown() error {
 	return nil
 }
 
-// initialize all normal db connections
+// initialize db connections, shards are warmed up by standby mode
 func (d *Conn) Init() error {
-	var err error
-	d.master, err = sqlx.Open(Name, d.user+":"+d.pass+"@tcp("+MasterAddr+":"+MasterPort+")/"+defs.ServicePrefix+"_"+Master+"?parseTime=true")
+	master, shard, err := d.open()
 	if err != nil {
 		return err
 	}
-	d.master.SetMaxOpenConns(MaxOpenConns)
-	d.master.SetMaxIdleConns(MaxIdleConns)
-	d.master.SetConnMaxLifetime(ConnMaxLifetime)
+	d.mu.Lock()
+	d.master, d.shard = master, shard
+	d.mu.Unlock()
+	return nil
+}
 
-	for i, _ := range d.shard {
-		d.shard[i], err = sqlx.Open(Name, d.user+":"+d.pass+"@tcp("+ShardAddr+":"+ShardPort+")/"+fmt.Sprintf("%s_%s_%02x", defs.ServicePrefix, Shard, i)+"?parseTime=true")
-		if err != nil {
-			return err
-		}
-		d.shard[i].SetMaxOpenConns(MaxOpenConns)
-		d.shard[i].SetMaxIdleConns(MaxIdleConns)
-		d.shard[i].SetConnMaxLifetime(ConnMaxLifetime)
+// Rebuild all pools with new settings. in-flight requests keep the old pools,
+// which are closed after drain. on error the current pools are kept.
+func (d *Conn) Reload(o Options, drain time.Duration) error {
+	next := &Conn{}
+	next.Configure(o)
+	master, shard, err := next.open()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+	if err = master.PingContext(ctx); err != nil {
+		closePools(master, shard)
+		return err
 	}
 
+	d.mu.Lock()
+	oldMaster, oldShard := d.master, d.shard
+	d.user, d.pass = next.user, next.pass
+	d.masterAddr, d.shardAddrs, d.shardAddrsSec = next.masterAddr, next.shardAddrs, next.shardAddrsSec
+	d.standby = next.standby
+	d.master, d.shard = master, shard
+	d.mu.Unlock()
+
+	go func() {
+		time.Sleep(drain)
+		closePools(oldMaster, oldShard)
+	}()
 	return nil
 }
 
+// open master pool and shard pairs with current settings, pinned pairs are opened and pinged
+func (d *Conn) open() (*sqlx.DB, [ShardDivide]*shardPair, error) {
+	var shard [ShardDivide]*shardPair
+	d.mu.RLock()
+	user, pass, masterAddr, standby := d.user, d.pass, d.masterAddr, d.standby
+	d.mu.RUnlock()
+	master, err := sqlx.Open(Name, user+":"+pass+"@tcp("+masterAddr+")/"+defs.ServicePrefix+"_"+Master+"?parseTime=true")
+	if err != nil {
+		return nil, shard, err
+	}
+	master.SetMaxOpenConns(MaxOpenConns)
+	master.SetMaxIdleConns(MaxIdleConns)
+	master.SetConnMaxLifetime(ConnMaxLifetime)
+
+	for i, _ := range shard {
+		shard[i] = &shardPair{open: d.shardOpener(i), pinned: pinned(standby, i)}
+	}
+	if err = wakePinned(shard); err != nil {
+		closePools(master, shard)
+		return nil, shard, err
+	}
+
+	return master, shard, nil
+}
+
+func openShard(user string, pass string, addr string, num int) (*sqlx.DB, error) {
+	shard, err := sqlx.Open(Name, user+":"+pass+"@tcp("+addr+")/"+fmt.Sprintf("%s_%s_%02x", defs.ServicePrefix, Shard, num)+"?parseTime=true")
+	if err != nil {
+		return nil, err
+	}
+	shard.SetMaxOpenConns(MaxOpenConns)
+	shard.SetMaxIdleConns(MaxIdleConns)
+	shard.SetConnMaxLifetime(ConnMaxLifetime)
+	return shard, nil
+}
+
 // Resolve database master
 func (d *Conn) Master() *sqlx.DB {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	return d.master
 }
 
-// Resolve database shard
+// Resolve database shard by shard num, see VendorShard for vendor id
 func (d *Conn) Shard(num uint64) (*sqlx.DB, error) {
-	if num > ShardDivide {
-		return nil, fmt.Errorf("error: shard num is over %d > %d", num, ShardDivide)
+	shard, _, err := d.ShardSide(num)
+	return shard, err
+}
+
+// Resolve database shard by shard num and which side of the pair serves it
+func (d *Conn) ShardSide(num uint64) (*sqlx.DB, Side, error) {
+	if num >= ShardDivide {
+		return nil, Primary, fmt.Errorf("error: shard num is over %d >= %d", num, ShardDivide)
 	}
-	return d.shard[GetShardNum(num)], nil
+	d.mu.RLock()
+	pair := d.shard[num]
+	d.mu.RUnlock()
+	if pair == nil {
+		return nil, Primary, fmt.Errorf("error: shard %02x is not open", num)
+	}
+	return pair.serve()
 }
 
 func GetShardNum(num uint64) int {
@@ -440,6 +561,37 @@ type Subscription struct {
         UpdateAt         time.Time `db:"update_at"`
 }
 
+// Create table sso query string, links an openid connect identity to auth
+func CreateSsoQuery() string {
+	query := `
+create table sso (
+    issuer      varchar(255) not null,
+    subject     varchar(255) not null,
+    id          bigint unsigned not null,
+    create_at   datetime not null,
+    update_at   datetime not null,
+    primary key (issuer, subject),
+    unique key (id)
+  ) engine=innodb;`
+	return query
+}
+
+// Drop table sso query string
+func DropSsoQuery() string {
+	query := `
+drop table sso;`
+	return query
+}
+
+// Sso table adaptor struct
+type Sso struct {
+	Issuer   string
+	Subject  string
+	Id       uint64
+	CreateAt time.Time `db:"create_at"`
+	UpdateAt time.Time `db:"update_at"`
+}
+
 // Create table summary query string
 func CreateSummaryQuery(num uint64) string {
 	query := `
@@ -450,7 +602,16 @@ create table summary_` + ToSuffix(num) + ` (
     name		varchar(1024) not null,
     caption		varchar(4096) not null,
     require_admit       boolean not null,
+    max_waiting		int unsigned not null default 0,
+    daily_cap		int unsigned not null default 0,
+    max_pending		smallint unsigned not null default 0,
+    admit_window	int unsigned not null default 0,
+    schedule		text not null,
     maintenance		boolean not null,
+    maintenance_message	varchar(1024) not null default '',
+    maintenance_until	bigint not null default 0,
+    mail_template	text not null,
+    push_thresholds	varchar(256) not null default '',
     delete_flag		tinyint unsigned not null,
     create_at		datetime not null,
     update_at		datetime not null,
@@ -475,7 +636,25 @@ type Summary struct {
 	Name        string
 	Caption     string
 	RequireAdmit bool  `db:"require_admit"`
+	// waiting entries limit, 0 is unlimited
+	MaxWaiting  uint32 `db:"max_waiting"`
+	// entries issued per utc day limit, 0 is unlimited
+	DailyCap    uint32 `db:"daily_cap"`
+	// deferrals allowed per ticket, 0 disables pending
+	MaxPending  uint16 `db:"max_pending"`
+	// seconds for the other side to confirm a dequeue, 0 is default
+	AdmitWindow uint32 `db:"admit_window"`
+	// business hours json, empty is always open
+	Schedule    string
 	Maintenance bool
+	// shown to waiting users while in maintenance
+	MaintenanceMessage string `db:"maintenance_message"`
+	// unix time maintenance ends, 0 is until turned off
+	MaintenanceUntil int64 `db:"maintenance_until"`
+	// mail templates json on the primary queue, empty is default
+	MailTemplate string `db:"mail_template"`
+	// places in line notified by push on the primary queue, comma separated, empty is default
+	PushThresholds string `db:"push_thresholds"`
 	DeleteFlag  uint8     `db:"delete_flag"`
 	CreateAt    time.Time `db:"create_at"`
 	UpdateAt    time.Time `db:"update_at"`
@@ -492,14 +671,24 @@ create table queue_` + ToSuffix(num) + ` (
     keycode_suffix	varchar(128) not null,
     mail_addr		varchar(1024) not null,
     mail_count		smallint unsigned not null,
+    mail_notice		tinyint unsigned not null default 0,
     push_type		tinyint unsigned not null,
     push_count		smallint unsigned not null,
+    push_subscription	varchar(4096) not null default '',
+    push_notice		smallint unsigned not null default 0,
+    position		bigint unsigned not null default 0,
+    pending_count	smallint unsigned not null default 0,
+    cancel_reason	tinyint unsigned not null default 0,
+    cancel_note		varchar(1024) not null default '',
+    vendor_auth_at	bigint not null default 0,
+    user_auth_at	bigint not null default 0,
     status		tinyint unsigned not null,
     delete_flag		tinyint unsigned not null,
     create_at		datetime not null,
     update_at		datetime not null,
     primary key (id),
-    unique (queue_code, keycode_prefix)
+    unique (queue_code, keycode_prefix),
+    key (queue_code, position)
   ) engine=innodb;`
 	return query
 }
@@ -520,8 +709,24 @@ type Queue struct {
 	KeyCodeSuffix string `db:"keycode_suffix"`
 	MailAddr      string `db:"mail_addr"`
 	MailCount     uint16 `db:"mail_count"`
+	// mail notices already sent
+	MailNotice    uint8  `db:"mail_notice"`
 	PushType      uint8  `db:"push_type"`
 	PushCount     uint16 `db:"push_count"`
+	// subscription json for push type
+	PushSubscription string `db:"push_subscription"`
+	// lowest place in line notified by push, 0 is none
+	PushNotice    uint16 `db:"push_notice"`
+	// waiting order in queue, ids keep joining order
+	Position      uint64 `db:"position"`
+	// times the user deferred the turn
+	PendingCount  uint16 `db:"pending_count"`
+	// set when canceled by vendor
+	CancelReason  uint8  `db:"cancel_reason"`
+	CancelNote    string `db:"cancel_note"`
+	// unix time each side confirmed the dequeue, 0 is not yet
+	VendorAuthAt  int64  `db:"vendor_auth_at"`
+	UserAuthAt    int64  `db:"user_auth_at"`
 	Status        uint8
 	DeleteFlag    uint8     `db:"delete_flag"`
 	CreateAt      time.Time `db:"create_at"`
@@ -532,14 +737,18 @@ type Queue struct {
 func CreateKeyCodeQuery(num uint64) string {
 	query := `
 create table keycode_` + ToSuffix(num) + ` (
-    id			bigint unsigned not null,
+    id			bigint unsigned not null auto_increment,
+    queue_id		bigint unsigned not null,
+    queue_code  	varbinary(256) not null,
+    reset_count		smallint unsigned not null,
     keycode_prefix	varchar(3) not null,
     keycode_suffix	varchar(128) not null,
     delete_flag		tinyint unsigned not null,
     create_at		datetime not null,
     update_at		datetime not null,
     primary key (id),
-    unique (keycode_prefix, keycode_suffix)
+    unique (keycode_prefix, keycode_suffix),
+    key (queue_id)
   ) engine=innodb;`
 	return query
 }
@@ -554,9 +763,15 @@ drop table keycode_` + ToSuffix(num) + `;`
 // KeyCode table adaptor struct
 type KeyCode struct {
 	Id            uint64
+	// queue entry id at issue, queue ids are reused after reset
+	QueueId       uint64    `db:"queue_id"`
+	QueueCode     []byte    `db:"queue_code"`
+	// queue generation at issue
+	ResetCount    uint16    `db:"reset_count"`
 	KeyCodePrefix string    `db:"keycode_prefix"`
 	KeyCodeSuffix string    `db:"keycode_suffix"`
 	DeleteFlag    uint8     `db:"delete_flag"`
+	// issue time
 	CreateAt      time.Time `db:"create_at"`
 	UpdateAt      time.Time `db:"update_at"`
 }
@@ -0,0 +1,238 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// response header reporting which side of the shard pair served the request
+	HeaderShardSide = "Shard-Side"
+	// health check interval
+	HealthCheckInterval = 5 * time.Second
+	// health check ping timeout
+	HealthCheckTimeout = 2 * time.Second
+	// consecutive primary ping failures before failover to secondary
+	FailoverThreshold = 3
+	// consecutive primary ping successes before failback to primary
+	FailbackThreshold = 3
+)
+
+// Shard pair side
+type Side uint8
+
+const (
+	Primary   Side = 0
+	Secondary Side = 1
+)
+
+func (s Side) String() string {
+	if s == Secondary {
+		return "secondary"
+	}
+	return "primary"
+}
+
+// Shard primary/secondary pool pair
+type shardPair struct {
+	mu        sync.RWMutex
+	pri       *sqlx.DB
+	sec       *sqlx.DB
+	active    Side
+	failures  int
+	successes int
+	// opens pools of a cold pair, see standby.go
+	open func() (*sqlx.DB, *sqlx.DB, error)
+	// pinned pairs are never idle-closed
+	pinned bool
+	// last served, unix nano
+	used int64
+}
+
+// Resolve active pool, cold pair is opened here
+func (p *shardPair) serve() (*sqlx.DB, Side, error) {
+	atomic.StoreInt64(&p.used, time.Now().UnixNano())
+	p.mu.RLock()
+	if p.pri != nil {
+		defer p.mu.RUnlock()
+		return p.activeLocked()
+	}
+	p.mu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.openLocked(); err != nil {
+		return nil, Primary, err
+	}
+	return p.activeLocked()
+}
+
+func (p *shardPair) activeLocked() (*sqlx.DB, Side, error) {
+	if p.active == Secondary {
+		return p.sec, Secondary, nil
+	}
+	return p.pri, Primary, nil
+}
+
+func ping(shard *sqlx.DB) bool {
+	if shard == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+	return shard.PingContext(ctx) == nil
+}
+
+// Ping primary, switch sides when thresholds are crossed. returns true if side changed.
+func (p *shardPair) check() bool {
+	p.mu.RLock()
+	pri, sec := p.pri, p.sec
+	p.mu.RUnlock()
+	// cold pair or no failover
+	if pri == nil || sec == nil {
+		return false
+	}
+	priAlive := ping(pri)
+	secAlive := false
+	if !priAlive {
+		secAlive = ping(sec)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if priAlive {
+		p.failures = 0
+		p.successes++
+		if p.active == Secondary && p.successes >= FailbackThreshold {
+			p.active = Primary
+			return true
+		}
+		return false
+	}
+	p.successes = 0
+	p.failures++
+	if p.active == Primary && p.failures >= FailoverThreshold && secAlive {
+		p.active = Secondary
+		return true
+	}
+	return false
+}
+
+func (p *shardPair) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closeLocked()
+}
+
+// close pools and go back to cold, failover state is reset
+func (p *shardPair) closeLocked() error {
+	var err error
+	if p.pri != nil {
+		err = p.pri.Close()
+	}
+	if p.sec != nil {
+		if res := p.sec.Close(); res != nil && err == nil {
+			err = res
+		}
+	}
+	p.pri, p.sec = nil, nil
+	p.active, p.failures, p.successes = Primary, 0, 0
+	return err
+}
+
+// Run one health check round over all shard pairs, returns shard nums which changed side
+func (d *Conn) CheckHealth() map[int]Side {
+	d.mu.RLock()
+	shard := d.shard
+	d.mu.RUnlock()
+	changed := map[int]Side{}
+	for i, pair := range shard {
+		if pair != nil && pair.check() {
+			pair.mu.RLock()
+			changed[i] = pair.active
+			pair.mu.RUnlock()
+		}
+	}
+	return changed
+}
+
+// Start background health check, report is called with shard num and new side on every switch.
+// unpinned shards idle for ShardIdleTimeout are closed on the same tick.
+func (d *Conn) StartHealthCheck(interval time.Duration, report func(num int, side Side)) {
+	d.mu.Lock()
+	d.stop = make(chan struct{})
+	stop := d.stop
+	d.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for num, side := range d.CheckHealth() {
+					if report != nil {
+						report(num, side)
+					}
+				}
+				d.CloseIdle(ShardIdleTimeout)
+			}
+		}
+	}()
+}
+
+// Stop health check and close all pools
+func (d *Conn) Close() error {
+	d.mu.Lock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+	master, shard := d.master, d.shard
+	d.master, d.shard = nil, [ShardDivide]*shardPair{}
+	d.mu.Unlock()
+	return closePools(master, shard)
+}
+
+func closePools(master *sqlx.DB, shard [ShardDivide]*shardPair) error {
+	var err error
+	if master != nil {
+		err = master.Close()
+	}
+	for _, pair := range shard {
+		if pair == nil {
+			continue
+		}
+		if res := pair.close(); res != nil && err == nil {
+			err = res
+		}
+	}
+	return err
+}
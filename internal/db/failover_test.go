@@ -0,0 +1,175 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fake driver, ping answers by server name
+var alive = struct {
+	sync.Mutex
+	servers map[string]bool
+}{servers: map[string]bool{}}
+
+type fakeDriver struct{}
+type fakeConn struct{ name string }
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{name}, nil }
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Ping(ctx context.Context) error {
+	alive.Lock()
+	defer alive.Unlock()
+	if !alive.servers[c.name] {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func setAlive(name string, up bool) {
+	alive.Lock()
+	alive.servers[name] = up
+	alive.Unlock()
+}
+
+func fakeShard(name string) *sqlx.DB {
+	return sqlx.NewDb(sql.OpenDB(connector{name}), Name)
+}
+
+type connector struct{ name string }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{c.name}, nil }
+func (c connector) Driver() driver.Driver                        { return fakeDriver{} }
+
+// Failover after threshold, failback after primary recovers
+func TestShardPairFailover(t *testing.T) {
+	pair := &shardPair{pri: fakeShard("pri"), sec: fakeShard("sec")}
+	setAlive("pri", true)
+	setAlive("sec", true)
+	_, side, _ := pair.serve()
+	assert.Equal(t, Primary, side)
+
+	setAlive("pri", false)
+	for i := 0; i < FailoverThreshold-1; i++ {
+		assert.False(t, pair.check())
+	}
+	assert.True(t, pair.check())
+	shard, side, _ := pair.serve()
+	assert.Equal(t, Secondary, side)
+	assert.Equal(t, pair.sec, shard)
+
+	setAlive("pri", true)
+	for i := 0; i < FailbackThreshold-1; i++ {
+		assert.False(t, pair.check())
+	}
+	assert.True(t, pair.check())
+	_, side, _ = pair.serve()
+	assert.Equal(t, Primary, side)
+}
+
+// No failover to a dead secondary, nor without one
+func TestShardPairNoFailover(t *testing.T) {
+	pair := &shardPair{pri: fakeShard("pri2"), sec: fakeShard("sec2")}
+	setAlive("pri2", false)
+	setAlive("sec2", false)
+	for i := 0; i < FailoverThreshold*2; i++ {
+		assert.False(t, pair.check())
+	}
+	_, side, _ := pair.serve()
+	assert.Equal(t, Primary, side)
+
+	single := &shardPair{pri: fakeShard("pri3")}
+	for i := 0; i < FailoverThreshold*2; i++ {
+		assert.False(t, single.check())
+	}
+	_, side, _ = single.serve()
+	assert.Equal(t, Primary, side)
+}
+
+// Cold pair opens on first serve, idle pair closes unless pinned
+func TestShardPairStandby(t *testing.T) {
+	opened := 0
+	open := func() (*sqlx.DB, *sqlx.DB, error) {
+		opened++
+		return fakeShard("cold"), nil, nil
+	}
+	setAlive("cold", true)
+	pair := &shardPair{open: open}
+	assert.False(t, pair.opened())
+	assert.False(t, pair.check())
+	shard, side, err := pair.serve()
+	assert.Nil(t, err)
+	assert.NotNil(t, shard)
+	assert.Equal(t, Primary, side)
+	assert.Equal(t, 1, opened)
+	_, _, _ = pair.serve()
+	assert.Equal(t, 1, opened)
+
+	assert.False(t, pair.closeIdle(time.Hour))
+	assert.True(t, pair.closeIdle(0))
+	assert.False(t, pair.opened())
+	_, _, _ = pair.serve()
+	assert.Equal(t, 2, opened)
+
+	pinned := &shardPair{open: open, pinned: true}
+	assert.Nil(t, pinned.wake(0))
+	assert.False(t, pinned.closeIdle(0))
+	assert.True(t, pinned.opened())
+
+	setAlive("cold", false)
+	dead := &shardPair{open: open}
+	assert.NotNil(t, dead.wake(1))
+}
+
+func TestPinned(t *testing.T) {
+	assert.True(t, pinned(StandbyAllHot, ShardDivide-1))
+	assert.False(t, pinned(StandbyAllCold, 0))
+	assert.True(t, pinned(2, 1))
+	assert.False(t, pinned(2, 2))
+}
+
+func TestShardSideClosed(t *testing.T) {
+	conn := &Conn{}
+	conn.shard[1] = &shardPair{pri: fakeShard("closing")}
+	setAlive("closing", true)
+	_, side, err := conn.ShardSide(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Primary, side)
+
+	assert.Nil(t, conn.Close())
+	_, _, err = conn.ShardSide(1)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,165 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Column added to vendor tables after they were first provisioned
+type Migration struct {
+	// table name prefix, e.g. "queue_"
+	Table string
+	// skipped when the column already exists
+	Column string
+	// alter table clauses adding the column and its keys
+	Alter string
+	// update set clause filling existing rows
+	Backfill string
+	// where clause of rows the backfill has not reached, rerun until none is left
+	Pending string
+}
+
+// Vendor table migrations in apply order, keep in sync with the create table queries
+var Migrations = []Migration{
+	{"summary_", "max_waiting", "add column max_waiting int unsigned not null default 0", "", ""},
+	{"summary_", "daily_cap", "add column daily_cap int unsigned not null default 0", "", ""},
+	{"summary_", "schedule", "add column schedule text not null", "", ""},
+	{"summary_", "maintenance_message", "add column maintenance_message varchar(1024) not null default ''", "", ""},
+	{"summary_", "maintenance_until", "add column maintenance_until bigint not null default 0", "", ""},
+	{"summary_", "max_pending", "add column max_pending smallint unsigned not null default 0", "", ""},
+	// waiting order of existing entries is their joining order
+	{"queue_", "position", "add column position bigint unsigned not null default 0, add key (queue_code, position)", "position = id", "position = 0"},
+	{"queue_", "pending_count", "add column pending_count smallint unsigned not null default 0", "", ""},
+	{"queue_", "cancel_reason", "add column cancel_reason tinyint unsigned not null default 0", "", ""},
+	{"queue_", "cancel_note", "add column cancel_note varchar(1024) not null default ''", "", ""},
+	{"summary_", "admit_window", "add column admit_window int unsigned not null default 0", "", ""},
+	{"queue_", "vendor_auth_at", "add column vendor_auth_at bigint not null default 0", "", ""},
+	{"queue_", "user_auth_at", "add column user_auth_at bigint not null default 0", "", ""},
+	{"summary_", "mail_template", "add column mail_template text not null", "", ""},
+	{"queue_", "mail_notice", "add column mail_notice tinyint unsigned not null default 0", "", ""},
+	{"summary_", "push_thresholds", "add column push_thresholds varchar(256) not null default ''", "", ""},
+	{"queue_", "push_subscription", "add column push_subscription varchar(4096) not null default ''", "", ""},
+	{"queue_", "push_notice", "add column push_notice smallint unsigned not null default 0", "", ""},
+	// registry rows get their own id, the queue entry id moves to queue_id
+	{"keycode_", "queue_id", "add column queue_id bigint unsigned not null default 0, modify id bigint unsigned not null auto_increment, add key (queue_id)", "queue_id = id", "queue_id = 0 and id > 0"},
+	{"keycode_", "queue_code", "add column queue_code varbinary(256) not null default ''", "", ""},
+	{"keycode_", "reset_count", "add column reset_count smallint unsigned not null default 0", "", ""},
+}
+
+// vendor table name -> column -> flag
+type tableColumns map[string]map[string]bool
+
+func (tables tableColumns) set(table string, column string) {
+	if tables[table] == nil {
+		tables[table] = map[string]bool{}
+	}
+	tables[table][column] = true
+}
+
+// migration targets the vendor table, backup tables have a longer prefix
+func (m Migration) targets(table string) bool {
+	return strings.HasPrefix(table, m.Table) && len(table) == len(m.Table)+len(ToSuffix(0))
+}
+
+// Statements bringing tables up to date, vendor tables without summary, queue, keycode
+// are left to Provision. backup tables are recreated on reset and not migrated.
+// pending marks existing columns whose backfill was interrupted.
+func (tables tableColumns) plan(migrations []Migration, pending tableColumns) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statements := []string{}
+	for _, name := range names {
+		for _, m := range migrations {
+			if !m.targets(name) {
+				continue
+			}
+			if !tables[name][m.Column] {
+				statements = append(statements, "alter table "+name+" "+m.Alter)
+			} else if !pending[name][m.Column] {
+				continue
+			}
+			if m.Backfill != "" {
+				statements = append(statements, "update "+name+" set "+m.Backfill+" where "+m.Pending)
+			}
+		}
+	}
+	return statements
+}
+
+// Apply vendor table migrations on every shard primary, already migrated tables are skipped.
+// report is called before each statement.
+func (d *Conn) Migrate(report func(num int, statement string)) error {
+	for num := 0; num < ShardDivide; num++ {
+		shard, side, err := d.ShardSide(uint64(num))
+		if err != nil {
+			return err
+		}
+		if side != Primary {
+			return fmt.Errorf("error: shard %02x is served by %s, migrate on primary", num, side)
+		}
+		columns := []struct {
+			Table  string `db:"table_name"`
+			Column string `db:"column_name"`
+		}{}
+		if err = PreparexSelect(shard, `select table_name as table_name, column_name as column_name from information_schema.columns
+			where table_schema = database() and (table_name like 'summary\_%' or table_name like 'queue\_%' or table_name like 'keycode\_%')`, &columns); err != nil {
+			return err
+		}
+		tables := tableColumns{}
+		for _, c := range columns {
+			tables.set(c.Table, c.Column)
+		}
+		// a failed backfill is not skipped on the next run with its column in place
+		pending := tableColumns{}
+		for name := range tables {
+			for _, m := range Migrations {
+				if m.Backfill == "" || !m.targets(name) || !tables[name][m.Column] {
+					continue
+				}
+				var left bool
+				if err = PreparexGet(shard, "select exists(select 1 from "+name+" where "+m.Pending+")", &left); err != nil {
+					return err
+				}
+				if left {
+					pending.set(name, m.Column)
+				}
+			}
+		}
+		for _, statement := range tables.plan(Migrations, pending) {
+			if report != nil {
+				report(num, statement)
+			}
+			if _, err = shard.Exec(statement); err != nil {
+				return fmt.Errorf("error: shard %02x %s: %s", num, statement, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Only missing columns of vendor tables are altered, backups and other tables are left alone
+func TestMigrationPlan(t *testing.T) {
+	migrations := []Migration{
+		{"summary_", "max_waiting", "add column max_waiting int", "", ""},
+		{"queue_", "position", "add column position bigint, add key (queue_code, position)", "position = id", "position = 0"},
+	}
+	summary, queue := "summary_"+ToSuffix(1), "queue_"+ToSuffix(1)
+	tables := tableColumns{
+		summary:                       {"id": true},
+		queue:                         {"id": true},
+		"queue_backup_" + ToSuffix(1): {"id": true},
+		"summary_" + ToSuffix(2):      {"id": true, "max_waiting": true},
+	}
+	assert.Equal(t, []string{
+		"alter table " + queue + " add column position bigint, add key (queue_code, position)",
+		"update " + queue + " set position = id where position = 0",
+		"alter table " + summary + " add column max_waiting int",
+	}, tables.plan(migrations, tableColumns{}))
+
+	tables[summary]["max_waiting"] = true
+	tables[queue]["position"] = true
+	assert.Empty(t, tables.plan(migrations, tableColumns{}))
+
+	// interrupted backfill is rerun without the alter
+	assert.Equal(t, []string{
+		"update " + queue + " set position = id where position = 0",
+	}, tables.plan(migrations, tableColumns{queue: {"position": true}}))
+}
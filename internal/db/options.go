@@ -0,0 +1,128 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"vql/internal/config"
+)
+
+// env file keys, standby mode is config.KeyStandbyMode shared with its flag
+const (
+	KeyUser           = "DB_USER"
+	KeyPass           = "DB_PASS"
+	KeyOpUser         = "DB_OP_USER"
+	KeyOpPass         = "DB_OP_PASS"
+	KeyMasterAddr     = "DB_MASTER_ADDR"
+	KeyShardAddrPri   = "DB_SHARD_ADDR_PRI"
+	KeyShardAddrSec   = "DB_SHARD_ADDR_SEC"
+	KeyShardPlacement = "DB_SHARD_PLACEMENT"
+	KeyShardWeights   = "DB_SHARD_WEIGHTS"
+)
+
+// shard placement policies
+const (
+	PlacementModulo   = "modulo"
+	PlacementWeighted = "weighted"
+)
+
+// Normal user db options, problems are recorded to values
+func LoadOptions(values *config.Values) Options {
+	o := Options{
+		User:          values.String(KeyUser, DefaultUser),
+		Pass:          values.String(KeyPass, DefaultPass),
+		MasterAddr:    values.String(KeyMasterAddr, MasterAddr+":"+MasterPort),
+		ShardAddrs:    values.List(KeyShardAddrPri, []string{ShardAddr + ":" + ShardPort}),
+		ShardAddrsSec: values.List(KeyShardAddrSec, []string{}),
+		Standby:       values.Int(config.KeyStandbyMode, StandbyAllHot),
+	}
+	if o.Standby < StandbyAllHot {
+		values.Problem(config.KeyStandbyMode, "must be -1 or more, got %d", o.Standby)
+	}
+	if o.User == "" {
+		values.Problem(KeyUser, "must not be empty")
+	}
+	if err := config.ValidateAddr(o.MasterAddr); err != nil {
+		values.Problem(KeyMasterAddr, "%s", err)
+	}
+	if len(o.ShardAddrs) == 0 {
+		values.Problem(KeyShardAddrPri, "at least one shard address required")
+	}
+	if len(o.ShardAddrs) > ShardDivide {
+		values.Problem(KeyShardAddrPri, "at most %d shard addresses, got %d", ShardDivide, len(o.ShardAddrs))
+	}
+	for i, addr := range o.ShardAddrs {
+		if err := config.ValidateAddr(addr); err != nil {
+			values.Problem(fmt.Sprintf("%s[%d]", KeyShardAddrPri, i), "%s", err)
+		}
+	}
+	if len(o.ShardAddrsSec) > len(o.ShardAddrs) {
+		values.Problem(KeyShardAddrSec, "%d entries but only %d primaries", len(o.ShardAddrsSec), len(o.ShardAddrs))
+	}
+	for i, addr := range o.ShardAddrsSec {
+		if err := config.ValidateAddr(addr); err != nil {
+			values.Problem(fmt.Sprintf("%s[%d]", KeyShardAddrSec, i), "%s", err)
+		}
+	}
+	return o
+}
+
+// Operation user options on the same servers, operation pools are rarely used
+func (o Options) Operation(values *config.Values) Options {
+	o.User = values.String(KeyOpUser, OperateUser)
+	o.Pass = values.String(KeyOpPass, OperatePass)
+	o.Standby = StandbyAllCold
+	if o.User == "" {
+		values.Problem(KeyOpUser, "must not be empty")
+	}
+	return o
+}
+
+// Shard placement policy for new vendors, modulo when invalid
+func LoadPlacement(values *config.Values) Placement {
+	switch policy := values.String(KeyShardPlacement, PlacementModulo); policy {
+	case PlacementModulo:
+		return ModuloPlacement{}
+	case PlacementWeighted:
+		weights := []int{}
+		for _, w := range values.List(KeyShardWeights, nil) {
+			weight, err := strconv.Atoi(w)
+			if err != nil {
+				values.Problem(KeyShardWeights, "invalid value %q", w)
+				return ModuloPlacement{}
+			}
+			weights = append(weights, weight)
+		}
+		placement, err := NewWeightedPlacement(weights)
+		if err != nil {
+			values.Problem(KeyShardPlacement, "%s", err)
+			return ModuloPlacement{}
+		}
+		return placement
+	default:
+		values.Problem(KeyShardPlacement, "unknown placement %q", policy)
+	}
+	return ModuloPlacement{}
+}
@@ -0,0 +1,60 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"vql/internal/config"
+)
+
+// Defaults when unset, every address problem is recorded
+func TestLoadOptions(t *testing.T) {
+	values := config.NewValues(map[string]string{})
+	o := LoadOptions(values)
+	assert.Equal(t, []string{ShardAddr + ":" + ShardPort}, o.ShardAddrs)
+	assert.Equal(t, StandbyAllHot, o.Standby)
+	op := o.Operation(values)
+	assert.Equal(t, OperateUser, op.User)
+	assert.Equal(t, StandbyAllCold, op.Standby)
+	assert.Equal(t, o.ShardAddrs, op.ShardAddrs)
+	assert.Equal(t, ModuloPlacement{}, LoadPlacement(values))
+	assert.NoError(t, values.Err())
+
+	values = config.NewValues(map[string]string{
+		KeyMasterAddr:         "localhost",
+		KeyShardAddrSec:       "a:1 b:2",
+		KeyShardPlacement:     PlacementWeighted,
+		KeyShardWeights:       "1 0 3",
+		config.KeyStandbyMode: "2",
+	})
+	o = LoadOptions(values)
+	assert.Equal(t, 2, o.Standby)
+	_, ok := LoadPlacement(values).(*WeightedPlacement)
+	assert.True(t, ok)
+	err := values.Err()
+	assert.Error(t, err)
+	assert.Len(t, strings.Split(err.Error(), "\n"), 2)
+}
@@ -0,0 +1,179 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"sync"
+)
+
+// vendor shard cache upper bound, cleared when reached
+const VendorShardCacheMax = 1 << 20
+
+// Unassigned domain.shard value, vendor not upgraded yet
+const ShardUnassigned = -1
+
+var ErrShardUnassigned = errors.New("error: vendor shard is not assigned")
+
+// Vendor shard placement policy. it only decides the shard of a vendor on upgrade,
+// after that domain.shard is the source of truth, so changing policy never moves existing vendors.
+type Placement interface {
+	Place(vendorId uint64) int
+}
+
+// Place by vendor id modulo shard count
+type ModuloPlacement struct{}
+
+func (ModuloPlacement) Place(vendorId uint64) int {
+	return GetShardNum(vendorId)
+}
+
+// Place by weight, shard with weight 0 receives no new vendors
+type WeightedPlacement struct {
+	Weights []int
+	total   int
+}
+
+func NewWeightedPlacement(weights []int) (*WeightedPlacement, error) {
+	if len(weights) > ShardDivide {
+		return nil, fmt.Errorf("error: too many shard weights %d > %d", len(weights), ShardDivide)
+	}
+	w := &WeightedPlacement{Weights: append([]int{}, weights...)}
+	for _, weight := range w.Weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("error: negative shard weight %d", weight)
+		}
+		w.total += weight
+	}
+	if w.total == 0 {
+		return nil, errors.New("error: all shard weights are zero")
+	}
+	return w, nil
+}
+
+func (w *WeightedPlacement) Place(vendorId uint64) int {
+	// spread sequential ids (splitmix64 finalizer) then pick by cumulative weight.
+	h := vendorId + 0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h = h ^ (h >> 31)
+	point := int(h % uint64(w.total))
+	for num, weight := range w.Weights {
+		if point < weight {
+			return num
+		}
+		point -= weight
+	}
+	return 0
+}
+
+// Active placement policy for new vendors
+var placer = struct {
+	sync.RWMutex
+	p Placement
+}{p: ModuloPlacement{}}
+
+// Change placement policy for new vendors
+func SetPlacement(p Placement) {
+	placer.Lock()
+	defer placer.Unlock()
+	placer.p = p
+}
+
+func currentPlacement() Placement {
+	placer.RLock()
+	defer placer.RUnlock()
+	return placer.p
+}
+
+// vendor id -> shard num, shared by all Conn, placement never changes once assigned.
+var vendorShards = struct {
+	sync.RWMutex
+	m map[uint64]int
+}{m: map[uint64]int{}}
+
+func rememberVendorShard(vendorId uint64, num int) {
+	vendorShards.Lock()
+	defer vendorShards.Unlock()
+	if len(vendorShards.m) >= VendorShardCacheMax {
+		vendorShards.m = map[uint64]int{}
+	}
+	vendorShards.m[vendorId] = num
+}
+
+// Forget cached vendor shard, e.g. vendor dropped
+func ForgetVendorShard(vendorId uint64) {
+	vendorShards.Lock()
+	defer vendorShards.Unlock()
+	delete(vendorShards.m, vendorId)
+}
+
+// Resolve vendor shard num from domain.shard
+func (d *Conn) VendorShardNum(vendorId uint64) (int, error) {
+	vendorShards.RLock()
+	num, ok := vendorShards.m[vendorId]
+	vendorShards.RUnlock()
+	if ok {
+		return num, nil
+	}
+	var shard int
+	if err := PreparexGet(d.Master(), "select shard from domain where id = ?", &shard, vendorId); err != nil {
+		return 0, err
+	}
+	if shard < 0 {
+		return 0, ErrShardUnassigned
+	}
+	if shard >= ShardDivide {
+		return 0, fmt.Errorf("error: vendor %d shard num is over %d >= %d", vendorId, shard, ShardDivide)
+	}
+	rememberVendorShard(vendorId, shard)
+	return shard, nil
+}
+
+// Resolve vendor database shard and which side of the pair serves it
+func (d *Conn) VendorShard(vendorId uint64) (*sqlx.DB, Side, error) {
+	num, err := d.VendorShardNum(vendorId)
+	if err != nil {
+		return nil, Primary, err
+	}
+	return d.ShardSide(uint64(num))
+}
+
+// Assign vendor shard by placement policy if not assigned yet, inside master transaction
+func AssignShard(tx *sqlx.Tx, vendorId uint64) (int, error) {
+	var shard int
+	if err := TxPreparexGet(tx, "select shard from domain where id = ? for update", &shard, vendorId); err != nil {
+		return 0, err
+	}
+	if shard >= 0 {
+		return shard, nil
+	}
+	shard = currentPlacement().Place(vendorId)
+	if _, err := TxPreparexExec(tx, "update domain set shard = ?, update_at = utc_timestamp() where id = ?", shard, vendorId); err != nil {
+		return 0, err
+	}
+	return shard, nil
+}
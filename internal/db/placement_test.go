@@ -0,0 +1,51 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Weighted placement never picks zero weight shards and is stable per vendor
+func TestWeightedPlacement(t *testing.T) {
+	_, err := NewWeightedPlacement([]int{0, 0})
+	assert.Error(t, err)
+	_, err = NewWeightedPlacement([]int{1, -1})
+	assert.Error(t, err)
+
+	w, err := NewWeightedPlacement([]int{1, 0, 3})
+	assert.NoError(t, err)
+	counts := map[int]int{}
+	for id := uint64(1); id <= 4000; id++ {
+		num := w.Place(id)
+		assert.Equal(t, num, w.Place(id))
+		counts[num]++
+	}
+	assert.Equal(t, 0, counts[1])
+	assert.Len(t, counts, 2)
+	assert.True(t, counts[2] > counts[0]*2)
+
+	assert.Equal(t, 1, ModuloPlacement{}.Place(33))
+}
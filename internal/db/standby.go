@@ -0,0 +1,181 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package db
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// all shard pools are opened and pinged at boot
+	StandbyAllHot = -1
+	// all shard pools are opened on first access
+	StandbyAllCold = 0
+	// unpinned shard pools unused for this long are closed
+	ShardIdleTimeout = 10 * time.Minute
+)
+
+// Is shard num warmed up at boot, standby 1<n pins shard 0..n-1
+func pinned(standby int, num int) bool {
+	return standby == StandbyAllHot || num < standby
+}
+
+// open pools of a cold pair
+func (p *shardPair) openLocked() error {
+	if p.pri != nil {
+		return nil
+	}
+	if p.open == nil {
+		return fmt.Errorf("error: shard pair has no opener")
+	}
+	pri, sec, err := p.open()
+	if err != nil {
+		return err
+	}
+	p.pri, p.sec = pri, sec
+	p.active, p.failures, p.successes = Primary, 0, 0
+	return nil
+}
+
+// Open and ping, start on secondary if primary does not answer
+func (p *shardPair) wake(num int) error {
+	p.mu.Lock()
+	err := p.openLocked()
+	pri, sec := p.pri, p.sec
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if ping(pri) {
+		return nil
+	}
+	if sec != nil && ping(sec) {
+		p.mu.Lock()
+		p.active = Secondary
+		p.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("error: shard %02x does not answer", num)
+}
+
+// Close pools unused for timeout, returns true if closed
+func (p *shardPair) closeIdle(timeout time.Duration) bool {
+	if p.pinned {
+		return false
+	}
+	if time.Since(time.Unix(0, atomic.LoadInt64(&p.used))) < timeout {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pri == nil {
+		return false
+	}
+	p.closeLocked()
+	return true
+}
+
+func (p *shardPair) opened() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pri != nil
+}
+
+// Close unpinned shard pools unused for timeout, returns closed shard nums
+func (d *Conn) CloseIdle(timeout time.Duration) []int {
+	d.mu.RLock()
+	shard := d.shard
+	d.mu.RUnlock()
+	closed := []int{}
+	for i, pair := range shard {
+		if pair != nil && pair.closeIdle(timeout) {
+			closed = append(closed, i)
+		}
+	}
+	return closed
+}
+
+// Count of currently open shard pairs
+func (d *Conn) OpenShards() int {
+	d.mu.RLock()
+	shard := d.shard
+	d.mu.RUnlock()
+	count := 0
+	for _, pair := range shard {
+		if pair != nil && pair.opened() {
+			count++
+		}
+	}
+	return count
+}
+
+// Open and ping pinned pairs in parallel
+func wakePinned(shard [ShardDivide]*shardPair) error {
+	var wg sync.WaitGroup
+	errs := make([]error, ShardDivide)
+	for i, pair := range shard {
+		if pair == nil || !pair.pinned {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, pair *shardPair) {
+			defer wg.Done()
+			errs[i] = pair.wake(i)
+		}(i, pair)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opener for shard num with current settings
+func (d *Conn) shardOpener(num int) func() (*sqlx.DB, *sqlx.DB, error) {
+	d.mu.RLock()
+	user, pass := d.user, d.pass
+	priAddr, secAddr := d.shardAddr(num), d.shardAddrSec(num)
+	d.mu.RUnlock()
+	return func() (*sqlx.DB, *sqlx.DB, error) {
+		pri, err := openShard(user, pass, priAddr, num)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secAddr == "" {
+			return pri, nil, nil
+		}
+		sec, err := openShard(user, pass, secAddr, num)
+		if err != nil {
+			pri.Close()
+			return nil, nil, err
+		}
+		return pri, sec, nil
+	}
+}
@@ -89,10 +89,11 @@ const (
 	ResponseOkVendorAccountRecoveredFromSso     = -112 // ok, vendor account recovered from sso.
 	ResponseOkVendorRequireInitialize           = -113 // ok, require initialize queue.
 	// VendorView XX2XX
-	ResponseNgVendorConnotMoveup     = 200 // ng, this is top, cannot more moveup
-	ResponseNgVendorAlreadyShelved   = 201 // ng, already sheleved.
-	ResponseNgVendorAlreadyUnshelved = 202 // ng, already unshelved.
-	ResponseNgVendorAlreadyCanceled  = 203 // ng, already canceled by vendor.
+	ResponseNgVendorConnotMoveup      = 200 // ng, this is top, cannot more moveup
+	ResponseNgVendorAlreadyShelved    = 201 // ng, already sheleved.
+	ResponseNgVendorAlreadyUnshelved  = 202 // ng, already unshelved.
+	ResponseNgVendorAlreadyCanceled   = 203 // ng, already canceled by vendor.
+	ResponseNgVendorCannotDeleteQueue = 204 // ng, primary queue cannot be deleted.
 	// VendorDequeueAuth XX3XX
 	ResponseNgVendorCannotAuthDequeue = 300 // ng, user dequeue auth not executed, dequeue auth failed.
 	ResponseNgVendorDequeueFailed     = 301 // ng, vendor dequeue failed.
@@ -101,8 +102,9 @@ const (
 	ResponseNgVendorAuthLacked = 500 // ng, vendor auth info lacked.
 	ResponseNgVendorAuthFailed = 501 // ng, vendor auth failed.
 	// UserQueing XX6XX
-	ResponseNgUserMaxover   = 600 // ng, user cannot queing, user max over.
-	ResponseNgUserOutoftime = 601 // ng, user cannot queing, out of time.
+	ResponseNgUserMaxover     = 600 // ng, user cannot queing, user max over.
+	ResponseNgUserOutoftime   = 601 // ng, user cannot queing, out of time.
+	ResponseNgUserMaintenance = 602 // ng, user cannot queing, queue in maintenance.
 	// UserView XX7XX
 	ResponseNgUserAlreadyMailOn   = 700 // ng, user already mail on.
 	ResponseNgUserAlreadyMailOff  = 701 // ng, user already mail off.
@@ -161,12 +163,14 @@ var responseCodeText = map[ResponseCode]string{
 	ResponseNgVendorAlreadyShelved:              "ResponseNgVendorAlreadyShelved",
 	ResponseNgVendorAlreadyUnshelved:            "ResponseNgVendorAlreadyUnshelved",
 	ResponseNgVendorAlreadyCanceled:             "ResponseNgVendorAlreadyCanceled",
+	ResponseNgVendorCannotDeleteQueue:           "ResponseNgVendorCannotDeleteQueue",
 	ResponseNgVendorCannotAuthDequeue:           "ResponseNgVendorCannotAuthDequeue",
 	ResponseNgVendorDequeueFailed:               "ResponseNgVendorDequeueFailed",
 	ResponseNgVendorAuthLacked:                  "ResponseNgVendorAuthLacked",
 	ResponseNgVendorAuthFailed:                  "ResponseNgVendorAuthFailed",
 	ResponseNgUserMaxover:                       "ResponseNgUserMaxover",
 	ResponseNgUserOutoftime:                     "ResponseNgUserOutoftime",
+	ResponseNgUserMaintenance:                   "ResponseNgUserMaintenance",
 	ResponseNgUserAlreadyMailOn:                 "ResponseNgUserAlreadyMailOn",
 	ResponseNgUserAlreadyMailOff:                "ResponseNgUserAlreadyMailOff",
 	ResponseNgUserAlreadyPushOn:                 "ResponseNgUserAlreadyPushOn",
@@ -212,6 +216,33 @@ const (
 	StatusEnqueue                                   = 1
 	StatusDequeue                                   = 2
 	StatusCancel                                    = 3
+	StatusShelved                                   = 4 // parked by vendor, keeps its place
+	StatusVendorCancel                              = 5 // canceled by vendor, StatusCancel is by user
+)
+
+type CancelReason uint8
+
+const (
+	CancelReasonNone         CancelReason = 0
+	CancelReasonNoShow                    = 1 // not present when called
+	CancelReasonClosing                   = 2 // vendor closed before the turn
+	CancelReasonRefused                   = 3 // service refused
+	CancelReasonQueueDeleted              = 4 // queue deleted by vendor, not requested by clients
+	CancelReasonOther                     = 9 // see note
+)
+
+type PushType uint8
+
+const (
+	PushTypeNone    PushType = 0
+	PushTypeWebPush          = 1 // web push with vapid
+	PushTypeGateway          = 2 // http gateway relaying to fcm, apns and the like
+)
+
+// echo context keys read by the access log
+const (
+	ContextKeyResponseCode = "response_code"
+	ContextKeyUid          = "uid"
 )
 
 type AuthContext struct {
@@ -418,6 +449,7 @@ type ResponseHandle interface {
 
 func ErrorDispose(cx echo.Context, r ResponseHandle, c ResponseCode, securitySquash bool, e error) string {
 	cx.Logger().Debugf("response code %s : %s", ResponseCodeText(c), e.Error())
+	cx.Set(ContextKeyResponseCode, c)
 	if !ProdMode {
 		printStacktrace(cx)
 	}
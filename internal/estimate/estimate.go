@@ -0,0 +1,95 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Waiting time estimate package
+//
+// Dequeue times in a rolling window give the mean interval between calls.
+// intervals are taken as exponential, so the wait of k calls ahead is gamma
+// distributed, widened by the uncertainty of the mean from few samples.
+package estimate
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// dequeues older than this are not sampled
+	Window = time.Hour
+	// most recent dequeues sampled
+	MaxSamples = 50
+	// two dequeues give the first interval
+	MinSamples = 2
+	// normal quantile of the bounds, 90% two sided
+	z = 1.645
+)
+
+// Estimated wait
+type Estimate struct {
+	// false when too few dequeues in window, for vendors just opened
+	Known bool
+	Wait  time.Duration
+	Low   time.Duration
+	High  time.Duration
+	// dequeues sampled
+	Samples int
+}
+
+// Estimate wait of an entry with before entries ahead from ascending dequeue times
+func Wait(dequeued []time.Time, before int, now time.Time) Estimate {
+	from := now.Add(-Window)
+	samples := []time.Time{}
+	for _, t := range dequeued {
+		if !t.Before(from) && !t.After(now) {
+			samples = append(samples, t)
+		}
+	}
+	if len(samples) > MaxSamples {
+		samples = samples[len(samples)-MaxSamples:]
+	}
+	e := Estimate{Samples: len(samples)}
+	if len(samples) < MinSamples {
+		return e
+	}
+
+	n := float64(len(samples))
+	first, last := samples[0], samples[len(samples)-1]
+	interval := float64(last.Sub(first)) / (n - 1)
+	// idle longer than usual since the last call slows the estimate down
+	if idle := float64(now.Sub(last)); idle > interval {
+		interval = float64(now.Sub(first)) / n
+	}
+	if interval <= 0 {
+		return e
+	}
+
+	// the entry is called on the dequeue after those ahead
+	k := float64(before + 1)
+	wait := k * interval
+	sd := interval * math.Sqrt(k+k*k/(n-1))
+	e.Known = true
+	e.Wait = time.Duration(wait)
+	e.Low = time.Duration(math.Max(0, wait-z*sd))
+	e.High = time.Duration(wait + z*sd)
+	return e
+}
@@ -0,0 +1,62 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package estimate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	now := time.Unix(1592619000, 0)
+	minutes := func(ago ...int) []time.Time {
+		times := []time.Time{}
+		for _, m := range ago {
+			times = append(times, now.Add(-time.Duration(m)*time.Minute))
+		}
+		return times
+	}
+
+	// just opened
+	e := Wait(minutes(1), 3, now)
+	assert.False(t, e.Known)
+	assert.Equal(t, 1, e.Samples)
+
+	// a call every two minutes, older than window ignored
+	e = Wait(minutes(120, 10, 8, 6, 4, 2, 0), 2, now)
+	assert.True(t, e.Known)
+	assert.Equal(t, 6, e.Samples)
+	assert.Equal(t, 6*time.Minute, e.Wait)
+	assert.True(t, e.Low < e.Wait && e.Wait < e.High)
+
+	// fewer samples give wider bounds
+	few := Wait(minutes(2, 0), 2, now)
+	assert.Equal(t, 6*time.Minute, few.Wait)
+	assert.True(t, few.High-few.Low > e.High-e.Low)
+
+	// idle since the last call
+	e = Wait(minutes(20, 18, 16), 0, now)
+	assert.Equal(t, 20*time.Minute/3, e.Wait)
+}
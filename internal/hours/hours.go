@@ -0,0 +1,204 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Business hours package
+//
+// A queue schedule is weekly opening windows in a time zone, date exceptions
+// replace the weekly windows of the day. joining stops LastEntry minutes before closing.
+package hours
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// days searched ahead for the next opening
+const lookAhead = 366
+
+// Opening window in local time, "15:04" format. close may be "24:00"
+type Window struct {
+	Open  string `json:"Open"`
+	Close string `json:"Close"`
+}
+
+// Date exception, no windows is closed all day
+type Exception struct {
+	// "2006-01-02" format
+	Date    string   `json:"Date"`
+	Windows []Window `json:"Windows"`
+}
+
+// Weekly schedule of a queue
+type Schedule struct {
+	// iana time zone name, empty is UTC
+	TimeZone string `json:"TimeZone"`
+	// windows by time.Weekday, sunday first
+	Weekly     [7][]Window `json:"Weekly"`
+	Exceptions []Exception `json:"Exceptions"`
+	// minutes before closing when joining stops
+	LastEntry int `json:"LastEntry"`
+}
+
+// Parse stored schedule, empty is nil schedule which is always open
+func Parse(value string) (*Schedule, error) {
+	if value == "" {
+		return nil, nil
+	}
+	s := &Schedule{}
+	if err := json.Unmarshal([]byte(value), s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stored form of schedule, nil is empty
+func (s *Schedule) String() string {
+	if s == nil {
+		return ""
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (s *Schedule) Validate() error {
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return err
+	}
+	if s.LastEntry < 0 {
+		return errors.New("failed, last entry is negative.")
+	}
+	for _, windows := range s.Weekly {
+		if err := validateWindows(windows); err != nil {
+			return err
+		}
+	}
+	for _, exception := range s.Exceptions {
+		if _, err := time.Parse("2006-01-02", exception.Date); err != nil {
+			return err
+		}
+		if err := validateWindows(exception.Windows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateWindows(windows []Window) error {
+	for _, w := range windows {
+		open, err := minutes(w.Open)
+		if err != nil {
+			return err
+		}
+		close, err := minutes(w.Close)
+		if err != nil {
+			return err
+		}
+		if open >= close {
+			return errors.New("failed, window closes before open. " + w.Open + "-" + w.Close)
+		}
+	}
+	return nil
+}
+
+// minutes of the day from "15:04", up to "24:00"
+func minutes(value string) (int, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 2 || len(fields[0]) != 2 || len(fields[1]) != 2 {
+		return 0, errors.New("failed, invalid time. " + value)
+	}
+	h, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, errors.New("failed, invalid time. " + value)
+	}
+	return h*60 + m, nil
+}
+
+// Joining is accepted at t, nil schedule always accepts
+func (s *Schedule) Accepts(t time.Time) bool {
+	next, ok := s.Next(t)
+	return ok && next.Equal(t)
+}
+
+// Next time at or after t when joining is accepted, t itself while open.
+// false if no opening within a year.
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	if s == nil {
+		return t, true
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	local := t.In(loc)
+	for i := 0; i <= lookAhead; i++ {
+		y, m, d := local.AddDate(0, 0, i).Date()
+		var found time.Time
+		for _, w := range s.windows(time.Date(y, m, d, 0, 0, 0, 0, loc)) {
+			// validated on parse
+			open, _ := minutes(w.Open)
+			close, _ := minutes(w.Close)
+			from := time.Date(y, m, d, 0, open, 0, 0, loc)
+			until := time.Date(y, m, d, 0, close-s.LastEntry, 0, 0, loc)
+			if !from.Before(until) || !t.Before(until) {
+				continue
+			}
+			if from.Before(t) {
+				from = t
+			}
+			if found.IsZero() || from.Before(found) {
+				found = from
+			}
+		}
+		if !found.IsZero() {
+			return found, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// windows of the local date, exception first
+func (s *Schedule) windows(date time.Time) []Window {
+	key := date.Format("2006-01-02")
+	for _, exception := range s.Exceptions {
+		if exception.Date == key {
+			return exception.Windows
+		}
+	}
+	return s.Weekly[date.Weekday()]
+}
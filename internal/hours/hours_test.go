@@ -0,0 +1,60 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package hours
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	s, err := Parse(`{"TimeZone":"Asia/Tokyo","Weekly":[[],[{"Open":"09:00","Close":"12:00"},{"Open":"13:00","Close":"18:00"}],[],[],[],[],[]],"Exceptions":[{"Date":"2020-06-29","Windows":[]}],"LastEntry":30}`)
+	assert.NoError(t, err)
+	jst := time.FixedZone("JST", 9*60*60)
+
+	// monday 2020-06-22
+	at := time.Date(2020, 6, 22, 10, 0, 0, 0, jst)
+	assert.True(t, s.Accepts(at))
+	next, ok := s.Next(time.Date(2020, 6, 22, 11, 30, 0, 0, jst))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2020, 6, 22, 13, 0, 0, 0, jst).Unix(), next.Unix())
+	assert.False(t, s.Accepts(time.Date(2020, 6, 22, 17, 45, 0, 0, jst)))
+
+	// next monday is a holiday
+	next, ok = s.Next(time.Date(2020, 6, 22, 17, 45, 0, 0, jst))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2020, 7, 6, 9, 0, 0, 0, jst).Unix(), next.Unix())
+
+	var always *Schedule
+	assert.True(t, always.Accepts(at))
+	s, err = Parse("")
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+
+	_, err = Parse(`{"Weekly":[[{"Open":"18:00","Close":"09:00"}],[],[],[],[],[],[]]}`)
+	assert.Error(t, err)
+	_, err = Parse(`{"TimeZone":"Nowhere/Town"}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Queue change fan-out package
+//
+// Handlers publish after mutating a queue, subscribers are woken up and re-read their position.
+// Notifications are coalesced, a slow subscriber sees one pending wake up however many changes happened.
+package hub
+
+import (
+	"sync"
+)
+
+// queue identity
+type key struct {
+	vendorId  uint64
+	queueCode string
+}
+
+// In-process fan-out keyed by vendor id and queue code
+type Hub struct {
+	mu        sync.Mutex
+	subs      map[key]map[*Subscription]struct{}
+	observers map[int]Observer
+	lastId    int
+}
+
+// Called on every publish with empty queue code for vendor wide changes, must not block
+type Observer func(vendorId uint64, queueCode string)
+
+// Subscriber wake up channel, close when done
+type Subscription struct {
+	C   <-chan struct{}
+	c   chan struct{}
+	hub *Hub
+	key key
+}
+
+// process wide hub
+var Default = New()
+
+func New() *Hub {
+	return &Hub{subs: map[key]map[*Subscription]struct{}{}, observers: map[int]Observer{}}
+}
+
+func Subscribe(vendorId uint64, queueCode string) *Subscription {
+	return Default.Subscribe(vendorId, queueCode)
+}
+
+func Publish(vendorId uint64, queueCode string) {
+	Default.Publish(vendorId, queueCode)
+}
+
+func PublishVendor(vendorId uint64) {
+	Default.PublishVendor(vendorId)
+}
+
+func Observe(fn Observer) func() {
+	return Default.Observe(fn)
+}
+
+func (h *Hub) Subscribe(vendorId uint64, queueCode string) *Subscription {
+	c := make(chan struct{}, 1)
+	s := &Subscription{C: c, c: c, hub: h, key: key{vendorId, queueCode}}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.subs[s.key]
+	if !ok {
+		subs = map[*Subscription]struct{}{}
+		h.subs[s.key] = subs
+	}
+	subs[s] = struct{}{}
+	return s
+}
+
+// Wake up subscribers of the queue, never blocks
+func (h *Hub) Publish(vendorId uint64, queueCode string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[key{vendorId, queueCode}] {
+		s.notify()
+	}
+	for _, fn := range h.observers {
+		fn(vendorId, queueCode)
+	}
+}
+
+// Wake up subscribers of every queue of the vendor
+func (h *Hub) PublishVendor(vendorId uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, subs := range h.subs {
+		if k.vendorId != vendorId {
+			continue
+		}
+		for s := range subs {
+			s.notify()
+		}
+	}
+	for _, fn := range h.observers {
+		fn(vendorId, "")
+	}
+}
+
+// Add observer of all publishes, returns function removing it
+func (h *Hub) Observe(fn Observer) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastId++
+	id := h.lastId
+	h.observers[id] = fn
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.observers, id)
+	}
+}
+
+// Subscribers of the queue
+func (h *Hub) Len(vendorId uint64, queueCode string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[key{vendorId, queueCode}])
+}
+
+func (s *Subscription) notify() {
+	select {
+	case s.c <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	subs := s.hub.subs[s.key]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(s.hub.subs, s.key)
+	}
+}
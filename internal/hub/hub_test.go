@@ -0,0 +1,99 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package hub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHub(t *testing.T) {
+	h := New()
+	a := h.Subscribe(1, "q")
+	b := h.Subscribe(1, "r")
+	c := h.Subscribe(2, "q")
+	assert.Equal(t, 1, h.Len(1, "q"))
+
+	// coalesced, never blocks
+	h.Publish(1, "q")
+	h.Publish(1, "q")
+	assert.Len(t, a.C, 1)
+	assert.Len(t, b.C, 0)
+	assert.Len(t, c.C, 0)
+	<-a.C
+
+	h.PublishVendor(1)
+	assert.Len(t, a.C, 1)
+	assert.Len(t, b.C, 1)
+	assert.Len(t, c.C, 0)
+
+	a.Close()
+	b.Close()
+	c.Close()
+	assert.Equal(t, 0, h.Len(1, "q"))
+	assert.Len(t, h.subs, 0)
+}
+
+func TestObserve(t *testing.T) {
+	h := New()
+	seen := []string{}
+	remove := h.Observe(func(vendorId uint64, queueCode string) {
+		seen = append(seen, queueCode)
+	})
+	h.Publish(1, "q")
+	h.PublishVendor(1)
+	assert.Equal(t, []string{"q", ""}, seen)
+
+	remove()
+	h.Publish(1, "q")
+	assert.Len(t, seen, 2)
+}
+
+func TestWorker(t *testing.T) {
+	scanned := make(chan uint64, 4)
+	errs := make(chan error, 4)
+	w := NewWorker(func(vendorId uint64) error {
+		scanned <- vendorId
+		if vendorId == 2 {
+			return errors.New("scan failed")
+		}
+		return nil
+	}, func(err error) { errs <- err })
+
+	w.Notify(1, "q")
+	assert.Equal(t, uint64(1), <-scanned)
+	w.Notify(2, "")
+	assert.Equal(t, uint64(2), <-scanned)
+	assert.EqualError(t, <-errs, "scan failed")
+
+	w.Close()
+	w.Notify(1, "q")
+	select {
+	case <-scanned:
+		t.Fatal("scanned after close")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,92 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package hub
+
+import (
+	"sync"
+)
+
+// Background scan of vendors changed by publishes. notifications are coalesced,
+// a vendor notified many times during a round is scanned once in the next round.
+type Worker struct {
+	scan    func(vendorId uint64) error
+	onError func(error)
+	mu      sync.Mutex
+	// vendors to scan
+	dirty map[uint64]struct{}
+	wake  chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Worker calling scan from one goroutine, errors are passed to onError if not nil
+func NewWorker(scan func(vendorId uint64) error, onError func(error)) *Worker {
+	w := &Worker{
+		scan:    scan,
+		onError: onError,
+		dirty:   map[uint64]struct{}{},
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// Schedule a scan of the vendor, never blocks. fits Observer
+func (w *Worker) Notify(vendorId uint64, queueCode string) {
+	w.mu.Lock()
+	w.dirty[vendorId] = struct{}{}
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop scanning, a scan in progress is finished
+func (w *Worker) Close() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+func (w *Worker) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.wake:
+		}
+		w.mu.Lock()
+		dirty := w.dirty
+		w.dirty = map[uint64]struct{}{}
+		w.mu.Unlock()
+		for vendorId := range dirty {
+			if err := w.scan(vendorId); err != nil && w.onError != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
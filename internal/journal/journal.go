@@ -0,0 +1,125 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Request journal record and replay package
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// recorded request headers
+var Headers = []string{"Session", "Nonce", "Hash", "IV", "Platform"}
+
+// Journal entry, one json line per request
+type Entry struct {
+	Seq      uint64            `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Method   string            `json:"method"`
+	Uri      string            `json:"uri"`
+	Header   map[string]string `json:"header"`
+	Body     string            `json:"body"`
+	Status   int               `json:"status"`
+	Response string            `json:"response"`
+}
+
+// Journal file writer
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	seq  uint64
+}
+
+// Open journal file for append, it holds session secrets so it is private to the owner
+func Open(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Append entry, Seq is assigned here
+func (r *Recorder) Record(entry *Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	entry.Seq = r.seq
+	return r.enc.Encode(entry)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Middleware records every request with its response
+func Middleware(r *Recorder) echo.MiddlewareFunc {
+	return middleware.BodyDump(func(c echo.Context, reqBody []byte, resBody []byte) {
+		req := c.Request()
+		entry := &Entry{
+			Time:     time.Now().UTC(),
+			Method:   req.Method,
+			Uri:      req.RequestURI,
+			Header:   map[string]string{},
+			Body:     string(reqBody),
+			Status:   c.Response().Status,
+			Response: string(resBody),
+		}
+		for _, name := range Headers {
+			if value := req.Header.Get(name); value != "" {
+				entry.Header[name] = value
+			}
+		}
+		if err := r.Record(entry); err != nil {
+			c.Logger().Errorf("journal record failed: %s", err)
+		}
+	})
+}
+
+// Read all entries from journal
+func Read(reader io.Reader) ([]Entry, error) {
+	entries := []Entry{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,140 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vql/internal/defs"
+)
+
+type resSession struct {
+	defs.ResponseBodyBase
+	SessionId      string
+	SessionPrivate string
+}
+
+type reqVendor struct {
+	defs.RequestBodyBase
+	VendorCode string
+}
+
+type resVendor struct {
+	defs.ResponseBodyBase
+	VendorCode string
+	Name       string
+}
+
+// fake service, generated values differ per instance
+func newService(instance string, name string) *echo.Echo {
+	e := echo.New()
+	privates := map[string]string{}
+	e.POST("/new", func(c echo.Context) error {
+		res := resSession{SessionId: instance + "+sid/=", SessionPrivate: instance + "+private/="}
+		res.Ticks = int64(len(privates))
+		privates[res.SessionId] = res.SessionPrivate
+		return c.String(http.StatusOK, defs.Encode(res, res.Ticks))
+	})
+	e.POST("/on/vendor/:vendor_code", func(c echo.Context) error {
+		res := resVendor{}
+		private := privates[c.Request().Header.Get("Session")]
+		if defs.ToHmacSha256(private+c.Request().Header.Get("Nonce"), defs.MagicKey) != c.Request().Header.Get("Hash") {
+			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &res, defs.ResponseNgUserAuthFailed, false, errors.New("hash")))
+		}
+		body, _ := ioutil.ReadAll(c.Request().Body)
+		req := reqVendor{}
+		vendorCode := strings.NewReplacer("-", "=", "_", "/", ".", "+").Replace(c.Param("vendor_code"))
+		if err := defs.Decode(body, &req, 0); err != nil || req.VendorCode != vendorCode {
+			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &res, defs.ResponseNgEncodeInvalid, false, fmt.Errorf("body %v", err)))
+		}
+		res.VendorCode = req.VendorCode
+		res.Name = name
+		return c.String(http.StatusOK, defs.Encode(res, res.Ticks))
+	})
+	return e
+}
+
+func call(t *testing.T, e *echo.Echo, target string, header map[string]string, body interface{}) string {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(url.QueryEscape(defs.Encode(body, 0))))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+// Record against one instance, replay against others
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+
+	recorder, err := Open(path)
+	assert.NoError(t, err)
+	e := newService("a", "vendor")
+	e.Use(Middleware(recorder))
+	session := resSession{}
+	assert.NoError(t, defs.Decode([]byte(call(t, e, "/new", nil, defs.RequestBodyBase{})), &session, 0))
+	nonce := "637295289927929882"
+	header := map[string]string{
+		"Session": session.SessionId,
+		"Nonce":   nonce,
+		"Hash":    defs.ToHmacSha256(session.SessionPrivate+nonce, defs.MagicKey),
+	}
+	// use generated value as vendor code in path and body
+	vendor := reqVendor{VendorCode: session.SessionId}
+	call(t, e, "/on/vendor/"+strings.NewReplacer("=", "-", "/", "_", "+", ".").Replace(vendor.VendorCode), header, vendor)
+	assert.NoError(t, recorder.Close())
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	entries, err := Read(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint64(2), entries[1].Seq)
+	assert.Equal(t, nonce, entries[1].Header["Nonce"])
+
+	result := Replay(newService("b", "vendor"), entries)
+	assert.Equal(t, 2, result.Entries)
+	assert.Empty(t, result.Diffs)
+
+	result = Replay(newService("c", "other"), entries)
+	assert.Equal(t, 1, len(result.Diffs))
+	assert.Equal(t, "Name", result.Diffs[0].Field)
+	assert.Equal(t, "other", result.Diffs[0].Replayed)
+}
@@ -0,0 +1,297 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package journal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"vql/internal/defs"
+)
+
+// response fields generated by the server, recorded values are substituted by replayed ones
+var Generated = map[string]bool{
+	"PrivateCode":    true,
+	"SessionId":      true,
+	"SessionPrivate": true,
+	"VendorCode":     true,
+	"QueueCode":      true,
+	"KeyCodePrefix":  true,
+	"KeyCodeSuffix":  true,
+}
+
+// response fields not compared
+var Ignored = map[string]bool{
+	"Ticks": true,
+}
+
+// field holding the session private used for the Hash header
+const sessionPrivateField = "SessionPrivate"
+
+// Response difference between recorded and replayed
+type Diff struct {
+	Seq      uint64
+	Method   string
+	Uri      string
+	Field    string
+	Recorded interface{}
+	Replayed interface{}
+}
+
+func (d Diff) String() string {
+	return fmt.Sprintf("#%d %s %s %s: recorded %v, replayed %v", d.Seq, d.Method, d.Uri, d.Field, d.Recorded, d.Replayed)
+}
+
+// Replay result
+type Result struct {
+	Entries int
+	Diffs   []Diff
+}
+
+type replayer struct {
+	handler http.Handler
+	// recorded value -> replayed value
+	values map[string]string
+	// recorded session privates
+	privates []string
+	result   *Result
+}
+
+// Feed entries through handler in order and diff the responses
+func Replay(handler http.Handler, entries []Entry) *Result {
+	r := &replayer{handler: handler, values: map[string]string{}, result: &Result{}}
+	for i := range entries {
+		r.replay(&entries[i])
+	}
+	return r.result
+}
+
+func (r *replayer) replay(entry *Entry) {
+	req := httptest.NewRequest(entry.Method, r.substituteUri(entry.Uri), strings.NewReader(r.substituteBody(entry.Body)))
+	for name, value := range entry.Header {
+		req.Header.Set(name, r.substitute(value))
+	}
+	if hash, ok := entry.Header["Hash"]; ok {
+		req.Header.Set("Hash", r.rehash(hash, entry.Header["Nonce"]))
+	}
+	rec := httptest.NewRecorder()
+	r.handler.ServeHTTP(rec, req)
+	r.result.Entries++
+
+	diff := func(field string, recorded interface{}, replayed interface{}) {
+		r.result.Diffs = append(r.result.Diffs, Diff{entry.Seq, entry.Method, entry.Uri, field, recorded, replayed})
+	}
+	if rec.Code != entry.Status {
+		diff("status", entry.Status, rec.Code)
+	}
+	recorded, recOk := decodeResponse(entry.Response)
+	replayed, repOk := decodeResponse(rec.Body.String())
+	if !recOk || !repOk {
+		if r.substitute(entry.Response) != rec.Body.String() {
+			diff("body", entry.Response, rec.Body.String())
+		}
+		return
+	}
+	r.learn(recorded, replayed)
+	r.compare("", recorded, replayed, diff)
+}
+
+// recompute Hash with the replayed session private
+func (r *replayer) rehash(hash string, nonce string) string {
+	for _, private := range r.privates {
+		if defs.ToHmacSha256(private+nonce, defs.MagicKey) == hash {
+			return defs.ToHmacSha256(r.values[private]+nonce, defs.MagicKey)
+		}
+	}
+	return hash
+}
+
+// collect generated values
+func (r *replayer) learn(recorded interface{}, replayed interface{}) {
+	switch rec := recorded.(type) {
+	case map[string]interface{}:
+		rep, ok := replayed.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, value := range rec {
+			recValue, recOk := value.(string)
+			repValue, repOk := rep[key].(string)
+			if Generated[key] && recOk && repOk {
+				if recValue != "" {
+					if _, known := r.values[recValue]; !known && key == sessionPrivateField {
+						r.privates = append(r.privates, recValue)
+					}
+					r.values[recValue] = repValue
+				}
+				continue
+			}
+			r.learn(value, rep[key])
+		}
+	case []interface{}:
+		rep, ok := replayed.([]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < len(rec) && i < len(rep); i++ {
+			r.learn(rec[i], rep[i])
+		}
+	}
+}
+
+func (r *replayer) compare(path string, recorded interface{}, replayed interface{}, diff func(string, interface{}, interface{})) {
+	switch rec := recorded.(type) {
+	case map[string]interface{}:
+		rep, ok := replayed.(map[string]interface{})
+		if !ok {
+			diff(path, recorded, replayed)
+			return
+		}
+		keys := map[string]bool{}
+		for key := range rec {
+			keys[key] = true
+		}
+		for key := range rep {
+			keys[key] = true
+		}
+		for _, key := range sortedKeys(keys) {
+			if Ignored[key] {
+				continue
+			}
+			recValue, recOk := rec[key]
+			repValue, repOk := rep[key]
+			if recOk != repOk {
+				diff(join(path, key), recValue, repValue)
+				continue
+			}
+			if Generated[key] {
+				continue
+			}
+			r.compare(join(path, key), recValue, repValue, diff)
+		}
+	case []interface{}:
+		rep, ok := replayed.([]interface{})
+		if !ok {
+			diff(path, recorded, replayed)
+			return
+		}
+		if len(rec) != len(rep) {
+			diff(path+".length", len(rec), len(rep))
+		}
+		for i := 0; i < len(rec) && i < len(rep); i++ {
+			r.compare(fmt.Sprintf("%s[%d]", path, i), rec[i], rep[i], diff)
+		}
+	case string:
+		if r.substitute(rec) != replayed {
+			diff(path, recorded, replayed)
+		}
+	default:
+		if !reflect.DeepEqual(recorded, replayed) {
+			diff(path, recorded, replayed)
+		}
+	}
+}
+
+// replace recorded values, longest first
+func (r *replayer) substitute(s string) string {
+	for _, recorded := range r.recordedValues() {
+		s = strings.Replace(s, recorded, r.values[recorded], -1)
+	}
+	return s
+}
+
+// path parameters are url safed base64, see queue.ShowQueue
+var urlSafe = strings.NewReplacer("=", "-", "/", "_", "+", ".")
+
+func (r *replayer) substituteUri(uri string) string {
+	for _, recorded := range r.recordedValues() {
+		replayed := r.values[recorded]
+		uri = strings.Replace(uri, urlSafe.Replace(recorded), urlSafe.Replace(replayed), -1)
+		uri = strings.Replace(uri, url.QueryEscape(recorded), url.QueryEscape(replayed), -1)
+		uri = strings.Replace(uri, recorded, replayed, -1)
+	}
+	return uri
+}
+
+// body is url escaped base64 json, see defs.Decode
+func (r *replayer) substituteBody(body string) string {
+	unescaped, err := url.QueryUnescape(body)
+	if err != nil {
+		return r.substitute(body)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(unescaped)
+	if err != nil {
+		return r.substitute(body)
+	}
+	return url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(r.substitute(string(decoded)))))
+}
+
+func (r *replayer) recordedValues() []string {
+	recorded := make([]string, 0, len(r.values))
+	for value := range r.values {
+		recorded = append(recorded, value)
+	}
+	sort.Slice(recorded, func(i, j int) bool {
+		if len(recorded[i]) != len(recorded[j]) {
+			return len(recorded[i]) > len(recorded[j])
+		}
+		return recorded[i] < recorded[j]
+	})
+	return recorded
+}
+
+// response is base64 json, see defs.Encode
+func decodeResponse(body string) (interface{}, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, false
+	}
+	var v interface{}
+	if err = json.Unmarshal(decoded, &v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,123 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"os"
+	"path/filepath"
+	"time"
+	"vql/internal/db"
+	"vql/internal/defs"
+)
+
+const (
+	// access log file name under log directory
+	AccessFile = "access.log"
+	// application log file name under log directory
+	AppFile = "service.log"
+)
+
+// access log, json line per request
+var Access = NewWriter(os.Stdout)
+
+// application log, echo logger output
+var App = NewWriter(os.Stdout)
+
+// Open access and application logs under o.Dir, reopens when already open
+func Open(o Options) error {
+	if err := Access.Open(filepath.Join(o.Dir, AccessFile), o); err != nil {
+		return err
+	}
+	return App.Open(filepath.Join(o.Dir, AppFile), o)
+}
+
+func Close() {
+	Access.Close()
+	App.Close()
+}
+
+// Access log line
+type accessLine struct {
+	Time             string `json:"time"`
+	RemoteIp         string `json:"remote_ip"`
+	Method           string `json:"method"`
+	Uri              string `json:"uri"`
+	Status           int    `json:"status"`
+	LatencyMs        int64  `json:"latency_ms"`
+	BytesIn          int64  `json:"bytes_in"`
+	BytesOut         int64  `json:"bytes_out"`
+	ResponseCode     *int16 `json:"response_code,omitempty"`
+	ResponseCodeText string `json:"response_code_text,omitempty"`
+	Uid              uint64 `json:"uid,omitempty"`
+	ShardSide        string `json:"shard_side,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// Access log middleware, response code is set by defs.ErrorDispose and uid by route.AuthMiddleware
+func AccessLog() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			req := c.Request()
+			res := c.Response()
+			line := accessLine{
+				Time:      start.UTC().Format(time.RFC3339Nano),
+				RemoteIp:  c.RealIP(),
+				Method:    req.Method,
+				Uri:       req.RequestURI,
+				Status:    res.Status,
+				LatencyMs: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+				BytesOut:  res.Size,
+				ShardSide: res.Header().Get(db.HeaderShardSide),
+			}
+			if req.ContentLength > 0 {
+				line.BytesIn = req.ContentLength
+			}
+			code, ok := c.Get(defs.ContextKeyResponseCode).(defs.ResponseCode)
+			if !ok && res.Status < 400 {
+				code, ok = defs.ResponseOk, true
+			}
+			if ok {
+				value := int16(code)
+				line.ResponseCode = &value
+				line.ResponseCodeText = defs.ResponseCodeText(code)
+			}
+			if uid, ok := c.Get(defs.ContextKeyUid).(uint64); ok {
+				line.Uid = uid
+			}
+			if err != nil {
+				line.Error = err.Error()
+			}
+			b, _ := json.Marshal(line)
+			Access.Write(append(b, '\n'))
+			return nil
+		}
+	}
+}
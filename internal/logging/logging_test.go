@@ -0,0 +1,104 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vql/internal/defs"
+)
+
+// Size rotation keeps MaxBackups, time rotation on day boundary
+func TestWriterRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+
+	now := time.Date(2020, 6, 20, 23, 0, 0, 0, time.UTC)
+	w := NewWriter(ioutil.Discard)
+	w.now = func() time.Time { return now }
+	assert.NoError(t, w.Open(path, Options{MaxSize: 10, Rotate: RotateDaily, MaxBackups: 2}))
+	defer w.Close()
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Second)
+		_, err = w.Write([]byte("12345678\n"))
+		assert.NoError(t, err)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	assert.Equal(t, 2, len(backups))
+
+	w.opts.MaxSize = 0
+	_, err = w.Write([]byte("same day\n"))
+	assert.NoError(t, err)
+	backups, _ = filepath.Glob(path + ".*")
+	assert.Equal(t, 2, len(backups))
+
+	now = now.Add(time.Hour)
+	_, err = w.Write([]byte("next day\n"))
+	assert.NoError(t, err)
+	content, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "next day\n", string(content))
+}
+
+// Access line carries response code name and uid
+func TestAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	Access = NewWriter(buf)
+	e := echo.New()
+	e.Use(AccessLog())
+	e.GET("/ok", func(c echo.Context) error {
+		c.Set(defs.ContextKeyUid, uint64(42))
+		return c.String(http.StatusOK, "ok")
+	})
+	e.GET("/ng", func(c echo.Context) error {
+		res := defs.ResponseBodyBase{}
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &res, defs.ResponseNgUserAuthFailed, true, errors.New("ng")))
+	})
+
+	line := accessLine{}
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, http.StatusOK, line.Status)
+	assert.Equal(t, uint64(42), line.Uid)
+	assert.Equal(t, "ResponseOk", line.ResponseCodeText)
+
+	buf.Reset()
+	line = accessLine{}
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ng", nil))
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, int16(defs.ResponseNgUserAuthFailed), *line.ResponseCode)
+	assert.Equal(t, "ResponseNgUserAuthFailed", line.ResponseCodeText)
+	assert.Equal(t, uint64(0), line.Uid)
+}
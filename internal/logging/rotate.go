@@ -0,0 +1,225 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Log file management package
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"vql/internal/config"
+)
+
+// time based rotation
+const (
+	RotateNone   = "none"
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+)
+
+// backup suffix, appended to the log path with the time the file was opened
+const backupLayout = "20060102-150405"
+
+// Rotation settings
+type Options struct {
+	Dir string
+	// rotate when file grows over this size, 0 means no size rotation
+	MaxSize int64
+	// RotateNone, RotateHourly or RotateDaily
+	Rotate string
+	// rotated files kept, 0 means keep all
+	MaxBackups int
+}
+
+// env file keys, log directory is config.KeyLogDirectory shared with its flag
+const (
+	KeyMaxSize    = "LOG_MAX_SIZE"
+	KeyRotate     = "LOG_ROTATE"
+	KeyMaxBackups = "LOG_MAX_BACKUPS"
+)
+
+const (
+	// default rotation size in MB
+	DefaultMaxSize = 100
+	// default backups kept
+	DefaultMaxBackups = 7
+)
+
+// Log file options, max size is given in MB. problems are recorded to values
+func LoadOptions(values *config.Values) Options {
+	maxSize := values.Int(KeyMaxSize, DefaultMaxSize)
+	o := Options{
+		Dir:        values.String(config.KeyLogDirectory, config.DefaultLogDir),
+		MaxSize:    int64(maxSize) * 1024 * 1024,
+		Rotate:     values.String(KeyRotate, RotateDaily),
+		MaxBackups: values.Int(KeyMaxBackups, DefaultMaxBackups),
+	}
+	if maxSize < 0 {
+		values.Problem(KeyMaxSize, "must be 0 or more, got %d", maxSize)
+	}
+	switch o.Rotate {
+	case RotateNone, RotateHourly, RotateDaily:
+	default:
+		values.Problem(KeyRotate, "must be none, hourly or daily, got %q", o.Rotate)
+	}
+	if o.MaxBackups < 0 {
+		values.Problem(KeyMaxBackups, "must be 0 or more, got %d", o.MaxBackups)
+	}
+	return o
+}
+
+// Rotating log file writer, writes to fallback until opened
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	opts     Options
+	file     *os.File
+	size     int64
+	opened   time.Time
+	fallback io.Writer
+	now      func() time.Time
+}
+
+func NewWriter(fallback io.Writer) *Writer {
+	return &Writer{fallback: fallback, now: time.Now}
+}
+
+// Open log file at path, current file is closed. also used to reopen after external rotation.
+func (w *Writer) Open(path string, o Options) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	w.closeLocked()
+	w.path, w.opts = path, o
+	return w.openLocked()
+}
+
+func (w *Writer) openLocked() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	w.opened = w.now()
+	if w.size > 0 {
+		w.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return w.fallback.Write(p)
+	}
+	if w.due(int64(len(p))) {
+		if err := w.rotateLocked(); err != nil {
+			// keep writing to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "log rotate %s: %s\n", w.path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotation is due by size or by crossing the hour/day boundary
+func (w *Writer) due(next int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.opts.MaxSize > 0 && w.size+next > w.opts.MaxSize {
+		return true
+	}
+	now := w.now()
+	switch w.opts.Rotate {
+	case RotateHourly:
+		return !now.Truncate(time.Hour).Equal(w.opened.Truncate(time.Hour))
+	case RotateDaily:
+		y1, m1, d1 := now.Date()
+		y2, m2, d2 := w.opened.Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+func (w *Writer) rotateLocked() error {
+	w.closeLocked()
+	backup := w.path + "." + w.opened.Format(backupLayout)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s.%d", w.path, w.opened.Format(backupLayout), i)
+	}
+	if err := os.Rename(w.path, backup); err != nil {
+		w.openLocked()
+		return err
+	}
+	w.prune()
+	return w.openLocked()
+}
+
+// remove oldest backups over MaxBackups
+func (w *Writer) prune() {
+	if w.opts.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(w.path + ".*")
+	if err != nil || len(backups) <= w.opts.MaxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-w.opts.MaxBackups] {
+		os.Remove(backup)
+	}
+}
+
+func (w *Writer) closeLocked() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Close file, later writes go to fallback
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeLocked()
+}
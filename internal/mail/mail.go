@@ -0,0 +1,171 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Email notification package
+//
+// Users opt in per ticket with a mail address, the notifier scans waiting entries of a vendor
+// after queue changes and posts "you are near" and "you are called" mails to the outbox,
+// which delivers them through the sender with retries.
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+	"vql/internal/config"
+)
+
+// defaults of the options
+const (
+	DefaultNotifyAhead  = 2
+	DefaultMaxPerTicket = 3
+	DefaultAttempts     = 3
+)
+
+// Mail options, empty smtp address disables mail
+type Options struct {
+	SmtpAddr string
+	From     string
+	Username string
+	Password string
+	// near notice when this many or fewer persons are waiting before the entry
+	NotifyAhead int
+	// mails sent per ticket at most
+	MaxPerTicket int
+	// delivery attempts per message
+	Attempts int
+}
+
+// env file keys
+const (
+	KeySmtpAddr     = "MAIL_SMTP_ADDR"
+	KeyFrom         = "MAIL_FROM"
+	KeyUser         = "MAIL_USER"
+	KeyPass         = "MAIL_PASS"
+	KeyNotifyAhead  = "MAIL_NOTIFY_AHEAD"
+	KeyMaxPerTicket = "MAIL_MAX_PER_TICKET"
+	KeyAttempts     = "MAIL_ATTEMPTS"
+)
+
+// Mail options, problems are recorded to values
+func LoadOptions(values *config.Values) Options {
+	o := Options{
+		SmtpAddr:     values.String(KeySmtpAddr, ""),
+		From:         values.String(KeyFrom, ""),
+		Username:     values.String(KeyUser, ""),
+		Password:     values.String(KeyPass, ""),
+		NotifyAhead:  values.Int(KeyNotifyAhead, DefaultNotifyAhead),
+		MaxPerTicket: values.Int(KeyMaxPerTicket, DefaultMaxPerTicket),
+		Attempts:     values.Int(KeyAttempts, DefaultAttempts),
+	}
+	if o.SmtpAddr != "" {
+		if err := config.ValidateAddr(o.SmtpAddr); err != nil {
+			values.Problem(KeySmtpAddr, "%s", err)
+		}
+		if err := ValidAddress(o.From); err != nil {
+			values.Problem(KeyFrom, "must be mail address with %s, got %q", KeySmtpAddr, o.From)
+		}
+	}
+	if o.NotifyAhead < 0 {
+		values.Problem(KeyNotifyAhead, "must be 0 or more, got %d", o.NotifyAhead)
+	}
+	if o.MaxPerTicket < 1 {
+		values.Problem(KeyMaxPerTicket, "must be 1 or more, got %d", o.MaxPerTicket)
+	}
+	if o.Attempts < 1 {
+		values.Problem(KeyAttempts, "must be 1 or more, got %d", o.Attempts)
+	}
+	return o
+}
+
+// Outgoing message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Delivers a message, failures are retried by the outbox
+type Sender interface {
+	Send(m Message) error
+}
+
+// SMTP relay sender, plain auth when username is set
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(m Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	data, err := m.bytes(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, data)
+}
+
+// Check a single mail address, as accepted by SetMail
+func ValidAddress(addr string) error {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return err
+	}
+	if parsed.Address != addr {
+		return errors.New("error: mail address must be bare address " + addr)
+	}
+	return nil
+}
+
+// rfc 5322 message, subject is q encoded so it can not break headers
+func (m Message) bytes(from string, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(m.To+from, "\r\n") {
+		return nil, errors.New("error: line break in mail address")
+	}
+	b := &bytes.Buffer{}
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	b.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	// line ends are normalized by the smtp data writer
+	b.WriteString(m.Body)
+	return b.Bytes(), nil
+}
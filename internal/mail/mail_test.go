@@ -0,0 +1,206 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"vql/internal/store"
+)
+
+// records messages, fails the first fails sends
+type fakeSender struct {
+	fails int
+	sent  chan Message
+}
+
+func (f *fakeSender) Send(m Message) error {
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("temporary failure")
+	}
+	f.sent <- m
+	return nil
+}
+
+func received(t *testing.T, c chan Message) Message {
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no mail sent")
+	}
+	return Message{}
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := ParseTemplates("")
+	assert.NoError(t, err)
+	assert.Nil(t, templates)
+	m, err := templates.Render(store.MailNear, "user@example.com", Data{VendorName: "shop", Place: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "shop: you are 3rd in line", m.Subject)
+
+	templates, err = ParseTemplates(`{"Called":{"Subject":"{{.KeyCodePrefix}} please"}}`)
+	assert.NoError(t, err)
+	m, err = templates.Render(store.MailCalled, "user@example.com", Data{VendorName: "shop", KeyCodePrefix: "12"})
+	assert.NoError(t, err)
+	assert.Equal(t, "12 please", m.Subject)
+	assert.Contains(t, m.Body, "is called")
+
+	_, err = ParseTemplates(`{"Near":{"Body":"{{.Unknown}}"}}`)
+	assert.Error(t, err)
+
+	for n, s := range map[int]string{1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th", 22: "22nd", 113: "113th"} {
+		assert.Equal(t, s, ordinal(n))
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	sender := &fakeSender{fails: 2, sent: make(chan Message, 1)}
+	errs := make(chan error, 1)
+	o := NewOutbox(sender, 3, time.Millisecond, func(err error) { errs <- err })
+	defer o.Close()
+	assert.True(t, o.Post(Message{To: "user@example.com"}))
+	assert.Equal(t, "user@example.com", received(t, sender.sent).To)
+
+	// given up after the last attempt
+	sender.fails = 3
+	assert.True(t, o.Post(Message{To: "user@example.com"}))
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "3 attempts")
+	case <-time.After(time.Second):
+		t.Fatal("no error reported")
+	}
+}
+
+// minimal smtp server accepting one message
+func serveSMTP(l net.Listener, data chan string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			b := &strings.Builder{}
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			data <- b.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	data := make(chan string, 1)
+	go serveSMTP(l, data)
+
+	s := &SMTP{Addr: l.Addr().String(), From: "queue@example.com"}
+	assert.NoError(t, s.Send(Message{To: "user@example.com", Subject: "you are 3rd\r\nBcc: x@example.com", Body: "come back\n"}))
+	sent := <-data
+	assert.Contains(t, sent, "To: user@example.com\r\n")
+	assert.NotContains(t, sent, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(sent, "\r\n\r\ncome back\r\n"))
+
+	assert.Error(t, s.Send(Message{To: "user@example.com\r\nBcc: x@example.com"}))
+	assert.Error(t, ValidAddress("User <user@example.com>"))
+	assert.NoError(t, ValidAddress("user@example.com"))
+}
+
+func TestNotifier(t *testing.T) {
+	store.UseMemory()
+	vendorId, err := store.Auths.Create(&store.Account{PrivateCode: []byte("private"), SessionId: []byte("session"), SessionPrivate: []byte("secret")})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Vendors.Assign(vendorId, []byte("vendor")))
+	assert.NoError(t, store.Vendors.Provision(vendorId, "shop", "caption", []byte("queue"), true, store.Capacity{}))
+	queueCode := base64.StdEncoding.EncodeToString([]byte("queue"))
+	prefixes := []string{}
+	for uid := uint64(10); uid < 14; uid++ {
+		ticket, err := store.Queues.Enqueue(vendorId, &store.Entry{QueueCode: queueCode, Uid: uid, KeyCodeSuffix: "suffix"})
+		assert.NoError(t, err)
+		prefixes = append(prefixes, ticket.KeyCodePrefix)
+	}
+	assert.NoError(t, store.Queues.SetMail(vendorId, queueCode, 12, prefixes[2], "third@example.com"))
+	assert.NoError(t, store.Queues.SetMail(vendorId, queueCode, 13, prefixes[3], "fourth@example.com"))
+
+	sender := &fakeSender{sent: make(chan Message, 4)}
+	n := NewWith(sender, Options{NotifyAhead: 2, MaxPerTicket: 3, Attempts: 1}, nil)
+	defer n.Close()
+	assert.NoError(t, n.Scan(vendorId))
+	m := received(t, sender.sent)
+	assert.Equal(t, "third@example.com", m.To)
+	assert.Equal(t, "shop: you are 3rd in line", m.Subject)
+
+	// sent once
+	assert.NoError(t, n.Scan(vendorId))
+	assert.Len(t, sender.sent, 0)
+
+	// called by vendor
+	_, err = store.Queues.Admit(vendorId, store.AdmitVendor, 0, prefixes[2], "suffix")
+	assert.NoError(t, err)
+	_, err = store.Queues.Dequeue(vendorId, prefixes[0])
+	assert.NoError(t, err)
+	assert.NoError(t, n.Scan(vendorId))
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		m = received(t, sender.sent)
+		got[m.To] = m.Subject
+	}
+	assert.Equal(t, map[string]string{"third@example.com": "shop: you are called", "fourth@example.com": "shop: you are 3rd in line"}, got)
+}
@@ -0,0 +1,126 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package mail
+
+import (
+	"errors"
+	"strconv"
+	"vql/internal/hub"
+	"vql/internal/store"
+)
+
+// Sends notices of waiting entries through the outbox
+type Notifier struct {
+	options Options
+	outbox  *Outbox
+	worker  *hub.Worker
+}
+
+// Notifier sending through smtp, nil if disabled
+func New(o Options, onError func(error)) *Notifier {
+	if o.SmtpAddr == "" {
+		return nil
+	}
+	return NewWith(&SMTP{Addr: o.SmtpAddr, From: o.From, Username: o.Username, Password: o.Password}, o, onError)
+}
+
+// Notifier sending through sender
+func NewWith(sender Sender, o Options, onError func(error)) *Notifier {
+	n := &Notifier{
+		options: o,
+		outbox:  NewOutbox(sender, o.Attempts, DefaultBackoff, onError),
+	}
+	n.worker = hub.NewWorker(n.Scan, onError)
+	return n
+}
+
+// Schedule a scan of the vendor entries, never blocks. fits hub.Observer
+func (n *Notifier) Notify(vendorId uint64, queueCode string) {
+	n.worker.Notify(vendorId, queueCode)
+}
+
+// Stop scanning and delivery
+func (n *Notifier) Close() {
+	n.worker.Close()
+	n.outbox.Close()
+}
+
+// Post due notices of the vendor entries, each notice is sent once per ticket
+func (n *Notifier) Scan(vendorId uint64) error {
+	targets, err := store.Queues.MailTargets(vendorId)
+	if err != nil || len(targets) == 0 {
+		return err
+	}
+	summary, err := store.Vendors.Summary(vendorId)
+	if err != nil {
+		return err
+	}
+	templates, err := ParseTemplates(summary.MailTemplate)
+	if err != nil {
+		return err
+	}
+	names, err := store.QueueNames(vendorId)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		notice := n.due(&t)
+		if notice == 0 {
+			continue
+		}
+		m, err := templates.Render(notice, t.MailAddr, Data{
+			VendorName:    summary.Name,
+			QueueName:     names[t.QueueCode],
+			KeyCodePrefix: t.KeyCodePrefix,
+			Before:        t.Before,
+			Place:         t.Before + 1,
+		})
+		if err != nil {
+			return err
+		}
+		// claimed before posting, a concurrent scan of another instance does not send it again
+		ok, err := store.Queues.MarkMail(vendorId, t.Id, notice, n.options.MaxPerTicket)
+		if err != nil {
+			return err
+		}
+		if ok && !n.outbox.Post(m) {
+			return errors.New("error: mail outbox full, vendor " + strconv.FormatUint(vendorId, 10))
+		}
+	}
+	return nil
+}
+
+// notice to send to target now, 0 if none
+func (n *Notifier) due(t *store.MailTarget) store.MailNotice {
+	if int(t.MailCount) >= n.options.MaxPerTicket || t.Notice&store.MailCalled != 0 {
+		return 0
+	}
+	if t.VendorAuthAt > 0 {
+		return store.MailCalled
+	}
+	if t.Before <= n.options.NotifyAhead && t.Notice&store.MailNear == 0 {
+		return store.MailNear
+	}
+	return 0
+}
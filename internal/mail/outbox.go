@@ -0,0 +1,120 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package mail
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// messages held by the outbox at most
+const OutboxSize = 1024
+
+// first retry delay, doubled on each attempt
+const DefaultBackoff = 2 * time.Second
+
+// Outbound mail queue delivering in background with retries
+type Outbox struct {
+	sender   Sender
+	attempts int
+	backoff  time.Duration
+	onError  func(error)
+	queue    chan Message
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Start outbox worker, onError receives messages dropped after the last attempt
+func NewOutbox(sender Sender, attempts int, backoff time.Duration, onError func(error)) *Outbox {
+	if attempts < 1 {
+		attempts = 1
+	}
+	o := &Outbox{
+		sender:   sender,
+		attempts: attempts,
+		backoff:  backoff,
+		onError:  onError,
+		queue:    make(chan Message, OutboxSize),
+		done:     make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.run()
+	return o
+}
+
+// Queue message for delivery, false if the outbox is full or closed
+func (o *Outbox) Post(m Message) bool {
+	select {
+	case <-o.done:
+		return false
+	default:
+	}
+	select {
+	case o.queue <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop the worker, queued messages are dropped
+func (o *Outbox) Close() {
+	close(o.done)
+	o.wg.Wait()
+}
+
+func (o *Outbox) run() {
+	defer o.wg.Done()
+	for {
+		select {
+		case <-o.done:
+			return
+		case m := <-o.queue:
+			o.deliver(m)
+		}
+	}
+}
+
+func (o *Outbox) deliver(m Message) {
+	delay := o.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = o.sender.Send(m); err == nil {
+			return
+		}
+		if attempt >= o.attempts {
+			break
+		}
+		select {
+		case <-o.done:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if o.onError != nil {
+		o.onError(fmt.Errorf("error: mail to %s dropped after %d attempts, %v", m.To, o.attempts, err))
+	}
+}
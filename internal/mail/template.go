@@ -0,0 +1,168 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"text/template"
+	"vql/internal/store"
+)
+
+// Template fields
+type Data struct {
+	VendorName    string
+	QueueName     string
+	KeyCodePrefix string
+	// persons waiting before the ticket
+	Before int
+	// place in line, 1 is next
+	Place int
+}
+
+// Subject and body as text/template
+type Template struct {
+	Subject string `json:"Subject"`
+	Body    string `json:"Body"`
+}
+
+// Vendor mail templates, empty fields use the defaults
+type Templates struct {
+	Near   Template `json:"Near"`
+	Called Template `json:"Called"`
+}
+
+// built-in templates
+var Defaults = Templates{
+	Near: Template{
+		Subject: "{{.VendorName}}: you are {{ordinal .Place}} in line",
+		Body:    "Ticket {{.KeyCodePrefix}} of {{.QueueName}} is {{ordinal .Place}} in line, please head back soon.\n",
+	},
+	Called: Template{
+		Subject: "{{.VendorName}}: you are called",
+		Body:    "Ticket {{.KeyCodePrefix}} of {{.QueueName}} is called, please confirm your ticket at the counter.\n",
+	},
+}
+
+var funcs = template.FuncMap{"ordinal": ordinal}
+
+// Parse templates json, empty is nil which renders the defaults
+func ParseTemplates(s string) (*Templates, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t := &Templates{}
+	if err := json.Unmarshal([]byte(s), t); err != nil {
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Templates json, empty for nil
+func (t *Templates) String() string {
+	if t == nil {
+		return ""
+	}
+	b, _ := json.Marshal(t)
+	return string(b)
+}
+
+// Check templates parse and render sample data
+func (t *Templates) Validate() error {
+	sample := Data{VendorName: "vendor", QueueName: "queue", KeyCodePrefix: "1", Before: 2, Place: 3}
+	for _, notice := range []store.MailNotice{store.MailNear, store.MailCalled} {
+		if _, err := t.Render(notice, "user@example.com", sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render the notice mail to address, nil templates render the defaults
+func (t *Templates) Render(notice store.MailNotice, to string, data Data) (Message, error) {
+	var chosen, fallback Template
+	switch notice {
+	case store.MailNear:
+		fallback = Defaults.Near
+		if t != nil {
+			chosen = t.Near
+		}
+	case store.MailCalled:
+		fallback = Defaults.Called
+		if t != nil {
+			chosen = t.Called
+		}
+	default:
+		return Message{}, errors.New("error: unknown mail notice " + strconv.Itoa(int(notice)))
+	}
+	if chosen.Subject == "" {
+		chosen.Subject = fallback.Subject
+	}
+	if chosen.Body == "" {
+		chosen.Body = fallback.Body
+	}
+	subject, err := execute(chosen.Subject, data)
+	if err != nil {
+		return Message{}, err
+	}
+	body, err := execute(chosen.Body, data)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject, Body: body}, nil
+}
+
+func execute(text string, data Data) (string, error) {
+	t, err := template.New("mail").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err = t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// 1st, 2nd, 3rd, 4th, 11th, 21st ...
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return strconv.Itoa(n) + suffix
+}
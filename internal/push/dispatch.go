@@ -0,0 +1,238 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package push
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"vql/internal/config"
+	"vql/internal/defs"
+	"vql/internal/hub"
+	"vql/internal/store"
+)
+
+// defaults of the options
+const DefaultMaxPerTicket = 5
+
+// Push options, no provider configured disables push
+type Options struct {
+	// base64url p-256 private key, empty disables web push
+	VapidKey string
+	// contact of the vapid claims, mailto: or https: url
+	VapidSubject string
+	// seconds the push service keeps an undelivered message
+	TTL int
+	// http gateway url, empty disables gateway push
+	GatewayUrl   string
+	GatewayToken string
+	// pushes sent per ticket at most
+	MaxPerTicket int
+}
+
+// env file keys
+const (
+	KeyVapidKey     = "PUSH_VAPID_PRIVATE_KEY"
+	KeyVapidSubject = "PUSH_VAPID_SUBJECT"
+	KeyTTL          = "PUSH_TTL"
+	KeyGatewayUrl   = "PUSH_GATEWAY_URL"
+	KeyGatewayToken = "PUSH_GATEWAY_TOKEN"
+	KeyMaxPerTicket = "PUSH_MAX_PER_TICKET"
+)
+
+// Push options, problems are recorded to values
+func LoadOptions(values *config.Values) Options {
+	o := Options{
+		VapidKey:     values.String(KeyVapidKey, ""),
+		VapidSubject: values.String(KeyVapidSubject, ""),
+		TTL:          values.Int(KeyTTL, DefaultTTL),
+		GatewayUrl:   values.String(KeyGatewayUrl, ""),
+		GatewayToken: values.String(KeyGatewayToken, ""),
+		MaxPerTicket: values.Int(KeyMaxPerTicket, DefaultMaxPerTicket),
+	}
+	if o.VapidKey != "" {
+		if _, err := NewWebPush(o.VapidKey, o.VapidSubject, o.TTL); err != nil {
+			values.Problem(KeyVapidKey, "%s", err)
+		}
+		if !strings.HasPrefix(o.VapidSubject, "mailto:") && !strings.HasPrefix(o.VapidSubject, "https:") {
+			values.Problem(KeyVapidSubject, "must be mailto: or https: url with %s, got %q", KeyVapidKey, o.VapidSubject)
+		}
+	}
+	if o.TTL < 1 {
+		values.Problem(KeyTTL, "must be 1 or more, got %d", o.TTL)
+	}
+	if o.GatewayUrl != "" {
+		if u, err := url.Parse(o.GatewayUrl); err != nil || !u.IsAbs() || u.Host == "" {
+			values.Problem(KeyGatewayUrl, "must be absolute url, got %q", o.GatewayUrl)
+		}
+	}
+	if o.MaxPerTicket < 1 {
+		values.Problem(KeyMaxPerTicket, "must be 1 or more, got %d", o.MaxPerTicket)
+	}
+	return o
+}
+
+// process wide dispatcher, nil while push is disabled
+var Default *Dispatcher
+
+// Pushes notifications to waiting entries crossing thresholds
+type Dispatcher struct {
+	options   Options
+	providers map[defs.PushType]Provider
+	publicKey string
+	worker    *hub.Worker
+}
+
+// Dispatcher with providers of the options, nil if none is configured
+func New(o Options, onError func(error)) (*Dispatcher, error) {
+	providers := map[defs.PushType]Provider{}
+	publicKey := ""
+	if o.VapidKey != "" {
+		w, err := NewWebPush(o.VapidKey, o.VapidSubject, o.TTL)
+		if err != nil {
+			return nil, err
+		}
+		providers[defs.PushTypeWebPush] = w
+		publicKey = w.PublicKey()
+	}
+	if o.GatewayUrl != "" {
+		providers[defs.PushTypeGateway] = NewGateway(o.GatewayUrl, o.GatewayToken)
+	}
+	if len(providers) == 0 {
+		return nil, nil
+	}
+	d := NewWith(providers, o, onError)
+	d.publicKey = publicKey
+	return d, nil
+}
+
+// Dispatcher sending through providers by push type
+func NewWith(providers map[defs.PushType]Provider, o Options, onError func(error)) *Dispatcher {
+	d := &Dispatcher{
+		options:   o,
+		providers: providers,
+	}
+	d.worker = hub.NewWorker(d.Scan, onError)
+	return d
+}
+
+// Push type has a provider
+func (d *Dispatcher) Supports(pushType defs.PushType) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.providers[pushType]
+	return ok
+}
+
+// Base64url vapid public key, empty without web push
+func (d *Dispatcher) PublicKey() string {
+	if d == nil {
+		return ""
+	}
+	return d.publicKey
+}
+
+// Schedule a scan of the vendor entries, never blocks. fits hub.Observer
+func (d *Dispatcher) Notify(vendorId uint64, queueCode string) {
+	d.worker.Notify(vendorId, queueCode)
+}
+
+// Stop scanning, a push in flight is finished
+func (d *Dispatcher) Close() {
+	d.worker.Close()
+}
+
+// Push to the vendor entries which crossed a threshold, each threshold once per ticket.
+// a gone subscription is removed, other failures are reported after the scan
+func (d *Dispatcher) Scan(vendorId uint64) error {
+	targets, err := store.Queues.PushTargets(vendorId)
+	if err != nil || len(targets) == 0 {
+		return err
+	}
+	summary, err := store.Vendors.Summary(vendorId)
+	if err != nil {
+		return err
+	}
+	thresholds, err := ParseThresholds(summary.PushThresholds)
+	if err != nil {
+		return err
+	}
+	if thresholds == nil {
+		thresholds = DefaultThresholds
+	}
+	names, err := store.QueueNames(vendorId)
+	if err != nil {
+		return err
+	}
+	var failed error
+	for _, t := range targets {
+		place := t.Before + 1
+		threshold := crossed(thresholds, place)
+		if threshold == 0 || (t.Notice != 0 && uint16(threshold) >= t.Notice) || int(t.PushCount) >= d.options.MaxPerTicket {
+			continue
+		}
+		provider, ok := d.providers[t.PushType]
+		if !ok {
+			continue
+		}
+		subscription, err := ParseSubscription(t.Subscription)
+		if err != nil {
+			failed = err
+			continue
+		}
+		// claimed before sending, a concurrent scan of another instance does not push it again
+		ok, err = store.Queues.MarkPush(vendorId, t.Id, uint16(threshold), d.options.MaxPerTicket)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		err = provider.Send(subscription, notification(summary.Name, names[t.QueueCode], &t))
+		if errors.Is(err, ErrGone) {
+			err = store.Queues.SetPush(vendorId, t.QueueCode, t.Uid, t.KeyCodePrefix, defs.PushTypeNone, "")
+		}
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// notification of target in queue
+func notification(vendorName string, queueName string, t *store.PushTarget) Notification {
+	body := "Ticket " + t.KeyCodePrefix + " of " + queueName + " is next in line"
+	if t.Before > 0 {
+		body = "Ticket " + t.KeyCodePrefix + " of " + queueName + " has " + strconv.Itoa(t.Before) + " ahead"
+	}
+	return Notification{
+		Title:         vendorName,
+		Body:          body,
+		QueueCode:     t.QueueCode,
+		KeyCodePrefix: t.KeyCodePrefix,
+		Place:         t.Before + 1,
+	}
+}
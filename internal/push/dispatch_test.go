@@ -0,0 +1,84 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package push_test
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"vql/internal/defs"
+	"vql/internal/push"
+	"vql/internal/push/pushtest"
+	"vql/internal/store"
+)
+
+func TestDispatcher(t *testing.T) {
+	store.UseMemory()
+	vendorId, err := store.Auths.Create(&store.Account{PrivateCode: []byte("private"), SessionId: []byte("session"), SessionPrivate: []byte("secret")})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Vendors.Assign(vendorId, []byte("vendor")))
+	assert.NoError(t, store.Vendors.Provision(vendorId, "shop", "caption", []byte("queue"), false, store.Capacity{}))
+	assert.NoError(t, store.Vendors.SetPushThresholds(vendorId, "4,2"))
+	queueCode := base64.StdEncoding.EncodeToString([]byte("queue"))
+	prefixes := []string{}
+	for uid := uint64(10); uid < 16; uid++ {
+		ticket, err := store.Queues.Enqueue(vendorId, &store.Entry{QueueCode: queueCode, Uid: uid})
+		assert.NoError(t, err)
+		prefixes = append(prefixes, ticket.KeyCodePrefix)
+	}
+	// 6th in line
+	subscription := (&push.Subscription{Token: "device"}).String()
+	assert.NoError(t, store.Queues.SetPush(vendorId, queueCode, 15, prefixes[5], defs.PushTypeGateway, subscription))
+
+	provider := &pushtest.Provider{}
+	d := push.NewWith(map[defs.PushType]push.Provider{defs.PushTypeGateway: provider}, push.Options{MaxPerTicket: 5}, nil)
+	defer d.Close()
+	assert.NoError(t, d.Scan(vendorId))
+	assert.Len(t, provider.Take(), 0)
+
+	// crossing 4, then staying between thresholds
+	for _, prefix := range prefixes[:2] {
+		_, err = store.Queues.Dequeue(vendorId, prefix)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, d.Scan(vendorId))
+	sent := provider.Take()
+	assert.Len(t, sent, 1)
+	assert.Equal(t, "device", sent[0].Subscription.Token)
+	assert.Equal(t, 4, sent[0].Notification.Place)
+	assert.Equal(t, "shop", sent[0].Notification.Title)
+	_, err = store.Queues.Dequeue(vendorId, prefixes[2])
+	assert.NoError(t, err)
+	assert.NoError(t, d.Scan(vendorId))
+	assert.Len(t, provider.Take(), 0)
+
+	// gone subscription is removed
+	provider.Err = push.ErrGone
+	_, err = store.Queues.Dequeue(vendorId, prefixes[3])
+	assert.NoError(t, err)
+	assert.NoError(t, d.Scan(vendorId))
+	position, err := store.Queues.Position(vendorId, queueCode, 15)
+	assert.NoError(t, err)
+	assert.Equal(t, defs.PushType(defs.PushTypeNone), position.PushType)
+}
@@ -0,0 +1,65 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Generic http gateway relaying to fcm, apns and the like.
+// posts {"token", "notification"} json with bearer token, 404 or 410 is a gone token
+type Gateway struct {
+	URL    string
+	Token  string
+	client *http.Client
+}
+
+// gateway request body
+type gatewayMessage struct {
+	Token        string       `json:"token"`
+	Notification Notification `json:"notification"`
+}
+
+func NewGateway(gatewayUrl string, token string) *Gateway {
+	return &Gateway{URL: gatewayUrl, Token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (g *Gateway) Send(s *Subscription, n Notification) error {
+	body, err := json.Marshal(gatewayMessage{Token: s.Token, Notification: n})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, g.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	return send(g.client, req)
+}
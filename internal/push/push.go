@@ -0,0 +1,174 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Push notification package
+//
+// Users register a subscription per ticket, the dispatcher scans waiting entries of a vendor
+// after queue changes and pushes when the place in line crosses one of the vendor thresholds.
+// Web push is sent directly with vapid, other platforms through an http gateway.
+package push
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"vql/internal/defs"
+)
+
+// thresholds a vendor may set at most
+const MaxThresholds = 10
+
+// places notified when the vendor set none
+var DefaultThresholds = []int{5, 3, 1}
+
+// subscription no longer valid at the push service, it is removed
+var ErrGone = errors.New("error: push subscription gone")
+
+// Subscription of a ticket, web push fields or gateway token by push type
+type Subscription struct {
+	// web push endpoint and keys, base64url as given by PushManager.subscribe
+	Endpoint string `json:"Endpoint,omitempty"`
+	P256dh   string `json:"P256dh,omitempty"`
+	Auth     string `json:"Auth,omitempty"`
+	// device token relayed by the gateway
+	Token string `json:"Token,omitempty"`
+}
+
+// Notification payload, json sent to the client
+type Notification struct {
+	Title         string `json:"title"`
+	Body          string `json:"body"`
+	QueueCode     string `json:"queueCode"`
+	KeyCodePrefix string `json:"keyCodePrefix"`
+	// place in line, 1 is next
+	Place int `json:"place"`
+}
+
+// Delivers a notification, ErrGone when the subscription is no longer valid
+type Provider interface {
+	Send(s *Subscription, n Notification) error
+}
+
+// Parse subscription json
+func ParseSubscription(s string) (*Subscription, error) {
+	sub := &Subscription{}
+	if err := json.Unmarshal([]byte(s), sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Subscription json
+func (s *Subscription) String() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Check fields required by push type
+func (s *Subscription) Validate(pushType defs.PushType) error {
+	switch pushType {
+	case defs.PushTypeWebPush:
+		u, err := url.Parse(s.Endpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("error: web push endpoint must be https url")
+		}
+		if key, err := decodeKey(s.P256dh); err != nil || len(key) != 65 {
+			return errors.New("error: web push p256dh must be uncompressed p-256 point")
+		}
+		if auth, err := decodeKey(s.Auth); err != nil || len(auth) != 16 {
+			return errors.New("error: web push auth must be 16 bytes")
+		}
+	case defs.PushTypeGateway:
+		if s.Token == "" {
+			return errors.New("error: gateway token required")
+		}
+	default:
+		return errors.New("error: unknown push type " + strconv.Itoa(int(pushType)))
+	}
+	return nil
+}
+
+// base64url with or without padding, as browsers differ
+func decodeKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Parse comma separated thresholds, empty is nil
+func ParseThresholds(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	thresholds := []int{}
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, n)
+	}
+	return thresholds, ValidateThresholds(thresholds)
+}
+
+// Comma separated thresholds, largest first
+func FormatThresholds(thresholds []int) string {
+	sorted := append([]int{}, thresholds...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	fields := make([]string, len(sorted))
+	for i, n := range sorted {
+		fields[i] = strconv.Itoa(n)
+	}
+	return strings.Join(fields, ",")
+}
+
+// Check thresholds are distinct places within uint16
+func ValidateThresholds(thresholds []int) error {
+	if len(thresholds) > MaxThresholds {
+		return errors.New("error: too many thresholds, at most " + strconv.Itoa(MaxThresholds))
+	}
+	seen := map[int]bool{}
+	for _, n := range thresholds {
+		if n < 1 || n > 65535 {
+			return errors.New("error: threshold out of range " + strconv.Itoa(n))
+		}
+		if seen[n] {
+			return errors.New("error: duplicate threshold " + strconv.Itoa(n))
+		}
+		seen[n] = true
+	}
+	return nil
+}
+
+// smallest threshold at or after place, 0 if place is past all
+func crossed(thresholds []int, place int) int {
+	best := 0
+	for _, n := range thresholds {
+		if place <= n && (best == 0 || n < best) {
+			best = n
+		}
+	}
+	return best
+}
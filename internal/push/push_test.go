@@ -0,0 +1,177 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"vql/internal/defs"
+)
+
+func TestThresholds(t *testing.T) {
+	thresholds, err := ParseThresholds("")
+	assert.NoError(t, err)
+	assert.Nil(t, thresholds)
+	thresholds, err = ParseThresholds("1, 10,3")
+	assert.NoError(t, err)
+	assert.Equal(t, "10,3,1", FormatThresholds(thresholds))
+	_, err = ParseThresholds("3,3")
+	assert.Error(t, err)
+	_, err = ParseThresholds("0")
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, crossed(thresholds, 11))
+	assert.Equal(t, 10, crossed(thresholds, 10))
+	assert.Equal(t, 3, crossed(thresholds, 2))
+	assert.Equal(t, 1, crossed(thresholds, 1))
+}
+
+// browser side of a web push subscription
+type userAgent struct {
+	private []byte
+	public  []byte
+	auth    []byte
+}
+
+func newUserAgent(t *testing.T) *userAgent {
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &userAgent{private, elliptic.Marshal(elliptic.P256(), x, y), auth}
+}
+
+func (ua *userAgent) subscription(endpoint string) *Subscription {
+	return &Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(ua.public),
+		Auth:     base64.URLEncoding.EncodeToString(ua.auth),
+	}
+}
+
+// decrypt aes128gcm body as the browser does
+func (ua *userAgent) decrypt(t *testing.T, body []byte) []byte {
+	salt := body[:16]
+	assert.Equal(t, uint32(recordSize), binary.BigEndian.Uint32(body[16:20]))
+	asPublic := body[21 : 21+int(body[20])]
+	x, y := elliptic.Unmarshal(elliptic.P256(), asPublic)
+	sx, _ := elliptic.P256().ScalarMult(x, y, ua.private)
+	cek, nonce, err := contentKeys(pad32(sx), ua.auth, salt, ua.public, asPublic)
+	assert.NoError(t, err)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+len(asPublic):], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(2), plain[len(plain)-1])
+	return plain[:len(plain)-1]
+}
+
+func TestWebPush(t *testing.T) {
+	key, err := GenerateKey()
+	assert.NoError(t, err)
+	w, err := NewWebPush(key, "mailto:ops@example.com", 0)
+	assert.NoError(t, err)
+	ua := newUserAgent(t)
+
+	var received []byte
+	var authorization string
+	status := http.StatusCreated
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "3600", r.Header.Get("TTL"))
+		authorization = r.Header.Get("Authorization")
+		received, _ = ioutil.ReadAll(r.Body)
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sub := ua.subscription(server.URL + "/push/abc")
+	assert.NoError(t, w.Send(sub, Notification{Title: "shop", Place: 3}))
+	n := Notification{}
+	assert.NoError(t, json.Unmarshal(ua.decrypt(t, received), &n))
+	assert.Equal(t, "shop", n.Title)
+	assert.Equal(t, 3, n.Place)
+
+	// vapid token signed by the key given as k
+	assert.True(t, strings.HasPrefix(authorization, "vapid t="))
+	parts := strings.SplitN(strings.TrimPrefix(authorization, "vapid t="), ", k=", 2)
+	assert.Equal(t, w.PublicKey(), parts[1])
+	segments := strings.Split(parts[0], ".")
+	claims := map[string]interface{}{}
+	b, _ := base64.RawURLEncoding.DecodeString(segments[1])
+	assert.NoError(t, json.Unmarshal(b, &claims))
+	assert.Equal(t, server.URL, claims["aud"])
+	assert.Equal(t, "mailto:ops@example.com", claims["sub"])
+	public, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	x, y := elliptic.Unmarshal(elliptic.P256(), public)
+	signature, _ := base64.RawURLEncoding.DecodeString(segments[2])
+	digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	assert.True(t, ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:],
+		new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+
+	status = http.StatusGone
+	assert.Equal(t, ErrGone, w.Send(sub, Notification{}))
+	status = http.StatusTooManyRequests
+	assert.Error(t, w.Send(sub, Notification{}))
+
+	assert.Error(t, sub.Validate(defs.PushTypeWebPush), "http endpoint")
+	sub.Endpoint = "https://push.example.com/abc"
+	assert.NoError(t, sub.Validate(defs.PushTypeWebPush))
+	sub.Auth = ""
+	assert.Error(t, sub.Validate(defs.PushTypeWebPush))
+}
+
+func TestGateway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		m := gatewayMessage{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		if m.Token == "stale" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "device", m.Token)
+		assert.Equal(t, 1, m.Notification.Place)
+	}))
+	defer server.Close()
+
+	g := NewGateway(server.URL, "secret")
+	assert.NoError(t, g.Send(&Subscription{Token: "device"}, Notification{Place: 1}))
+	assert.Equal(t, ErrGone, g.Send(&Subscription{Token: "stale"}, Notification{Place: 1}))
+	assert.Error(t, (&Subscription{}).Validate(defs.PushTypeGateway))
+}
@@ -0,0 +1,237 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// seconds the push service keeps an undelivered message
+	DefaultTTL = 3600
+	// vapid token lifetime, at most 24 hours by rfc 8292
+	vapidExpire = 12 * time.Hour
+	// aes128gcm record size, a single record is sent
+	recordSize = 4096
+)
+
+// Web push sender with vapid, rfc 8030, 8291 and 8292
+type WebPush struct {
+	key     *ecdsa.PrivateKey
+	subject string
+	ttl     int
+	client  *http.Client
+}
+
+// Web push sender with base64url p-256 private key and contact subject (mailto: or https:)
+func NewWebPush(privateKey string, subject string, ttl int) (*WebPush, error) {
+	d, err := decodeKey(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("error: vapid private key must be 32 bytes base64url")
+	}
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d)
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &WebPush{key: key, subject: subject, ttl: ttl, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// New base64url vapid private key
+func GenerateKey() (string, error) {
+	d, _, _, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(d), nil
+}
+
+// Base64url application server key given to PushManager.subscribe
+func (w *WebPush) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(w.key.Curve, w.key.X, w.key.Y))
+}
+
+func (w *WebPush) Send(s *Subscription, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	body, err := encrypt(s, payload, rand.Reader)
+	if err != nil {
+		return err
+	}
+	token, err := w.vapid(s.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(w.ttl))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+w.PublicKey())
+	return send(w.client, req)
+}
+
+// post and map the push service status, 404 and 410 are a gone subscription
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("error: push service %s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
+
+// es256 jwt for the origin of endpoint
+func (w *WebPush) vapid(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidExpire).Unix(),
+		"sub": w.subject,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, sig, err := ecdsa.Sign(rand.Reader, w.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := append(pad32(r), pad32(sig)...)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// aes128gcm content coding of payload for the subscription keys, rfc 8291
+func encrypt(s *Subscription, payload []byte, random io.Reader) ([]byte, error) {
+	uaPublic, err := decodeKey(s.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := decodeKey(s.Auth)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, uaPublic)
+	if x == nil {
+		return nil, errors.New("error: invalid p256dh key")
+	}
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, random)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+	sx, _ := curve.ScalarMult(x, y, asPrivate)
+	shared := pad32(sx)
+
+	salt := make([]byte, 16)
+	if _, err = io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+	cek, nonce, err := contentKeys(shared, auth, salt, uaPublic, asPublic)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload)+1+gcm.Overhead() > recordSize {
+		return nil, errors.New("error: push payload too large")
+	}
+	// 0x02 delimits the last record, no padding
+	plain := append(append([]byte{}, payload...), 2)
+
+	header := make([]byte, 16+4+1, 16+4+1+len(asPublic))
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[16:], recordSize)
+	header[20] = byte(len(asPublic))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, plain, nil), nil
+}
+
+// content encryption key and nonce from the ecdh secret, both sides derive the same
+func contentKeys(shared []byte, auth []byte, salt []byte, uaPublic []byte, asPublic []byte) ([]byte, []byte, error) {
+	info := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, auth, info), ikm); err != nil {
+		return nil, nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, nil, err
+	}
+	return cek, nonce, nil
+}
+
+// big endian 32 bytes, p-256 coordinates and scalars
+func pad32(n *big.Int) []byte {
+	b := make([]byte, 32)
+	v := n.Bytes()
+	copy(b[32-len(v):], v)
+	return b
+}
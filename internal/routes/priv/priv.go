@@ -25,59 +25,20 @@
 package priv
 
 import (
-	_ "github.com/go-sql-driver/mysql"
-        //"github.com/jmoiron/sqlx"
-	//"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 	"net/http"
-	"vql/internal/db"
 	"vql/internal/defs"
+	"vql/internal/hub"
+	"vql/internal/store"
 )
 
 // Drop(physics remove) vendor
 func DropVendor(c echo.Context) error {
 	authCtx := c.(*defs.AuthContext)
-	master := db.OpConns.Master()
-	stmt, err := master.Preparex(`select * from domain where id = ?`)
-	domain := db.Domain{}
-	paramId := authCtx.Uid
-	stmt.Exec(&domain, paramId)
-	if err != nil {
+	if err := store.Vendors.Drop(authCtx.Uid); err != nil {
 		return err
 	}
-	defer stmt.Close()
-	shard, err := db.OpConns.Shard(domain.Id)
-	if err != nil {
-		return err
-	}
-	tx, err := shard.Beginx()
-	if err != nil {
-		return err
-	}
-	stmt, err = tx.Preparex(db.DropSummaryQuery(domain.Id))
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	stmt.Exec()
-	stmt, err = tx.Preparex(db.DropQueueQuery(domain.Id))
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	stmt.Exec()
-	// generate keycodes
-	stmt, err = tx.Preparex(db.DropKeyCodeQuery(domain.Id))
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	stmt.Exec()
-	// commit
-	tx.Commit()
+	hub.PublishVendor(authCtx.Uid)
 	c.Echo().Logger.Debug("removed")
 	return c.String(http.StatusOK, "return master key here.")
-
-	c.Logger().Debug("removed")
-	return c.String(http.StatusOK, "")
 }
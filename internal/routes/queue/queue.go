@@ -25,11 +25,9 @@
 package queue
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/base64"
 	"errors"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 	"io/ioutil"
 	//"log"
@@ -37,8 +35,20 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"vql/internal/db"
 	"vql/internal/defs"
+	"vql/internal/estimate"
+	"vql/internal/hours"
+	"vql/internal/hub"
+	"vql/internal/mail"
+	"vql/internal/push"
+	"vql/internal/store"
+)
+
+const (
+	// comment line interval keeping idle streams open through proxies
+	StreamKeepAlive = 30 * time.Second
+	// streams end after this, clients reconnect
+	StreamMaxDuration = 30 * time.Minute
 )
 
 // Create user request body struct
@@ -74,6 +84,16 @@ type ResBodyLogon struct {
 	defs.ResponseBodyBase
 }
 
+// Recover user request body struct
+type ReqBodyRecover struct {
+	IdentifierType byte   `json:"IdentifierType"`
+	Identifier     string `json:"Identifier"`
+	Seed           string `json:"Seed"`
+	// lost or current private code, may be empty
+	PrivateCode string `json:"PrivateCode"`
+	defs.RequestBodyBase
+}
+
 // Enqueue request body struct
 type ReqBodyEnqueue struct {
 	VendorCode string `json:VendorCode`
@@ -89,6 +109,8 @@ type ResBodyEnqueue struct {
 	KeyCodeSuffix        string `json:KeyCodeSuffix`
 	PersonsWaitingBefore int    `json:PersonsWaitingBefore`
 	TotalWaiting         int    `json:TotalWaiting`
+	QueueName            string `json:"QueueName"`
+	WaitEstimate
 	defs.ResponseBodyBase
 }
 
@@ -103,6 +125,23 @@ type ResBodyQueue struct {
 	PersonsWaitingBefore int `json:PersonsWaitingBefore`
 	TotalWaiting         int `json:TotalWaiting`
 	Status               int `json:Status`
+	QueueName            string `json:"QueueName"`
+	// queue in maintenance, the entry is kept
+	Paused       bool   `json:"Paused"`
+	PauseMessage string `json:"PauseMessage"`
+	// unix time maintenance ends, 0 is until turned off
+	PauseUntil int64 `json:"PauseUntil"`
+	// unix time the vendor called on require admit queue, confirm by dequeue until ConfirmUntil
+	CalledAt     int64 `json:"CalledAt"`
+	ConfirmUntil int64 `json:"ConfirmUntil"`
+	// set when Status is canceled by vendor
+	CancelReason int    `json:"CancelReason"`
+	CancelNote   string `json:"CancelNote"`
+	// mail notices address, empty is off
+	MailAddr string `json:"MailAddr"`
+	// push subscription type, 0 is off
+	PushType int `json:"PushType"`
+	WaitEstimate
 	defs.ResponseBodyBase
 }
 
@@ -133,14 +172,9 @@ func Create(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgNonceInvalid, true, err))
 	}
 
-	baseSeed := defs.ToHmacSha256(request.Identifier+platformType+strconv.FormatInt(request.Ticks, 10), defs.MagicKey)
-	verifySeed := defs.ToHmacSha256(baseSeed+nonce, defs.MagicKey)
-	c.Echo().Logger.Debug("seed : verifySeed -> %s : %s", request.Seed, verifySeed)
-	if verifySeed != request.Seed {
-		err = errors.New("failed verify seed")
+	if err = verifySeed(c, request.Identifier, platformType, request.Ticks, nonce, request.Seed); err != nil {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgSeedInvalid, true, err))
 	}
-	c.Echo().Logger.Debug("success verify seed")
 
 	privateCode, err := defs.NewPrivateCode()
 	if err != nil {
@@ -163,53 +197,103 @@ func Create(c echo.Context) error {
 	// check phone num
 
 	// save create vendor master tables
-	master := db.Conns.Master()
-	var tx *sqlx.Tx
-	var result sql.Result
-	if tx, err = master.Beginx(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTransactBeginFailed, true, db.RollbackResolve(err, tx)))
-	}
 	// already exists? -> logon or recover response
 	// TODO seed exists check.
 	// TODO auth exists check.
-	if result, err = db.TxPreparexExec(tx, `insert into domain (
-		service_code, vendor_code, shard, delete_flag, create_at, update_at
-	) values (
-		?, '', ?, 0, utc_timestamp(), utc_timestamp()
-	)`, defs.ServiceCode, -1); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	if _, err = store.Auths.Create(&store.Account{
+		IdentifierType:   request.IdentifierType,
+		PlatformType:     platformType,
+		Identifier:       request.Identifier,
+		Seed:             request.Seed,
+		Ticks:            request.Ticks,
+		PrivateCode:      privateCode,
+		AgreementVersion: request.AgreementVersion,
+		Agreed:           request.CheckedAgreement,
+		ActivateType:     defs.PhoneAuth,
+		ActivateKeyword:  request.ActivateKeyword,
+		SessionId:        sessionId,
+		SessionPrivate:   sessionPrivate,
+	}); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+
+	c.Echo().Logger.Debug("created")
+	response.PrivateCode = base64.StdEncoding.EncodeToString(privateCode)
+	response.SessionId = base64.StdEncoding.EncodeToString(sessionId)
+	response.SessionPrivate = base64.StdEncoding.EncodeToString(sessionPrivate)
+	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+}
+
+// seed is hmac of identifier, platform, ticks and nonce
+func verifySeed(c echo.Context, identifier string, platformType string, ticks int64, nonce string, seed string) error {
+	baseSeed := defs.ToHmacSha256(identifier+platformType+strconv.FormatInt(ticks, 10), defs.MagicKey)
+	verifySeed := defs.ToHmacSha256(baseSeed+nonce, defs.MagicKey)
+	c.Echo().Logger.Debugf("seed : verifySeed -> %s : %s", seed, verifySeed)
+	if verifySeed != seed {
+		return errors.New("failed verify seed")
 	}
+	c.Echo().Logger.Debug("success verify seed")
+	return nil
+}
 
-	signedId, err := result.LastInsertId()
-	vendorId := uint64(signedId)
+// Recover account from seed, rotates private code and session
+func Recover(c echo.Context) error {
+	var err error
+	bodyBytes, err := ioutil.ReadAll(c.Request().Body)
+	request := ReqBodyRecover{}
+	response := ResBodyCreate{}
+	response.ResponseCode = defs.ResponseOk
+	response.SessionId = ""
+	response.Ticks = time.Now().Unix()
+	ticks, err := strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
+	}
+	if err = defs.Decode(bodyBytes, &request, ticks); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
+	}
 
-	if result, err = db.TxPreparexExec(tx, `insert into auth (
-		id, identifier_type, platform_type, identifier, seed, secret,
-		ticks, private_code, account_type, agreement_ver, agreement_time, activate,
-		activate_type, activate_keyword, activate_time, session_id, session_private, session_footprint, 
-		delete_flag, create_at, update_at
-	) values (
-		?, ?, ?, ?, ?, ?,
-		?, ?, ?, ?, utc_timestamp(), ?,
-		?, ?, utc_timestamp(), ?, ?, utc_timestamp(),
-		0, utc_timestamp(), utc_timestamp()
-	)`, vendorId, request.IdentifierType, platformType, request.Identifier, request.Seed, "", request.Ticks, privateCode, defs.NormalUser, request.AgreementVersion, request.CheckedAgreement, defs.PhoneAuth, request.ActivateKeyword, sessionId, sessionPrivate); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	// validate param
+	platformType := c.Request().Header.Get("Platform")
+	nonce := c.Request().Header.Get("Nonce")
+	_, err = strconv.ParseInt(nonce, 10, 64)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgNonceInvalid, true, err))
+	}
+	if err = verifySeed(c, request.Identifier, platformType, request.Ticks, nonce, request.Seed); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgSeedInvalid, true, err))
+	}
+	presented, err := base64.StdEncoding.DecodeString(request.PrivateCode)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
 
-	if result, err = db.TxPreparexExec(tx, `insert into subscription (
-		id, subscription_type, subscription_expire, delete_flag, create_at, update_at
-	) values (
-		?, ?, utc_timestamp(), 0, utc_timestamp(), utc_timestamp()
-	)`, vendorId, defs.FreePlan); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	privateCode, err := defs.NewPrivateCode()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
+	}
+	sessionId, err := defs.NewSession(string(privateCode[:]))
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
+	}
+	sessionPrivate, err := defs.NewSessionPrivate()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
 	}
 
-	if err = tx.Commit(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgCommitFailed, true, db.RollbackResolve(err, tx)))
+	id, previous, err := store.Auths.Recover(request.Identifier, request.Seed, privateCode, sessionId, sessionPrivate)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
 
-	c.Echo().Logger.Debug("created")
+	if len(presented) == 0 {
+		response.ResponseCode = defs.ResponseOkVendorAccountNoPrivkeyExistsSeed
+	} else if !bytes.Equal(presented, previous) {
+		response.ResponseCode = defs.ResponseOkVendorAccountBadPrivkeyExistsSeed
+	} else {
+		response.ResponseCode = defs.ResponseOkVendorAccountRecoveredFromSeed
+	}
+	c.Echo().Logger.Debugf("recovered: %d", id)
 	response.PrivateCode = base64.StdEncoding.EncodeToString(privateCode)
 	response.SessionId = base64.StdEncoding.EncodeToString(sessionId)
 	response.SessionPrivate = base64.StdEncoding.EncodeToString(sessionPrivate)
@@ -237,41 +321,17 @@ func Logon(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
 	}
 
-	master := db.Conns.Master()
-	var tx *sqlx.Tx
-	if tx, err = master.Beginx(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTransactBeginFailed, true, db.RollbackResolve(err, tx)))
-	}
-	var count int
-	if err = db.TxPreparexGet(tx, "select count(1) from auth where to_base64(private_code) = ? ", &count, request.PrivateCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
-	}
-
-	if count == 0 {
-		err = errors.New("failed, private code not found. " + request.PrivateCode)
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthNotFound, true, db.RollbackResolve(err, tx)))
-	} else if count > 1 {
-		err = errors.New("failed, invalid private code. " + request.PrivateCode)
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, db.RollbackResolve(err, tx)))
-	}
-
 	sessionId, err := defs.NewSession(string(decodedPrivateCode[:]))
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, db.RollbackResolve(err, tx)))
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
 	}
 	sessionPrivate, err := defs.NewSessionPrivate()
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, db.RollbackResolve(err, tx)))
-	}
-
-	if _, err = db.TxPreparexExec(tx, `update auth set session_id = ?, session_private = ?, session_footprint = utc_timestamp(), update_at = utc_timestamp()
-	where to_base64(private_code) = ?`,
-		sessionId, sessionPrivate, request.PrivateCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
 	}
 
-	if err = tx.Commit(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgCommitFailed, true, db.RollbackResolve(err, tx)))
+	if _, err = store.Auths.RenewSession(request.PrivateCode, sessionId, sessionPrivate); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
 
 	c.Echo().Logger.Debug("created")
@@ -280,6 +340,38 @@ func Logon(c echo.Context) error {
 	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
 }
 
+// Estimated wait from recent dequeues of the queue, seconds
+type WaitEstimate struct {
+	// false until the queue has enough dequeues in the window
+	WaitEstimated bool  `json:"WaitEstimated"`
+	EstimatedWait int64 `json:"EstimatedWait"`
+	// 90% bounds
+	EstimatedWaitLow  int64 `json:"EstimatedWaitLow"`
+	EstimatedWaitHigh int64 `json:"EstimatedWaitHigh"`
+	// unix time expected to be called
+	ExpectedCallAt int64 `json:"ExpectedCallAt"`
+}
+
+// estimate wait of an entry with before entries ahead
+func estimateWait(vendorId uint64, queueCode string, before int) (WaitEstimate, error) {
+	now := time.Now()
+	dequeued, err := store.Queues.Dequeued(vendorId, queueCode, now.Add(-estimate.Window))
+	if err != nil {
+		return WaitEstimate{}, err
+	}
+	e := estimate.Wait(dequeued, before, now)
+	if !e.Known {
+		return WaitEstimate{}, nil
+	}
+	return WaitEstimate{
+		WaitEstimated:     true,
+		EstimatedWait:     int64(e.Wait / time.Second),
+		EstimatedWaitLow:  int64(e.Low / time.Second),
+		EstimatedWaitHigh: int64(e.High / time.Second),
+		ExpectedCallAt:    now.Add(e.Wait).Unix(),
+	}, nil
+}
+
 // Add keycode in queue
 func Enqueue(c echo.Context) error {
 	var err error
@@ -297,116 +389,83 @@ func Enqueue(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
 
-	master := db.Conns.Master()
-	var vendorId uint64
 	c.Echo().Logger.Debugf("vendor code: %s", request.VendorCode)
 	c.Echo().Logger.Debugf("queue code: %s", request.QueueCode)
-	if err = db.PreparexGet(master, "select id from domain where to_base64(vendor_code) = ?", &vendorId, request.VendorCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
-	}
-
-	shard, err := db.Conns.Shard(vendorId)
+	vendorId, err := store.Vendors.IdByCode(request.VendorCode)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgShardConnectFailed, true, err))
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
-
-	keyCodeSuffix, err := defs.NewKeyCodeSuffix()
+	store.ShardSide(c.Response().Header(), vendorId)
+	summary, err := store.Vendors.QueueSummary(vendorId, request.QueueCode)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
-	}
-
-	var tx *sqlx.Tx
-	if tx, err = shard.Beginx(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTransactBeginFailed, true, db.RollbackResolve(err, tx)))
-	}
-	summaryResult := struct {
-		VendorName    string `db:"name"`
-		VendorCaption string `db:"caption"`
-	}{"", ""}
-	queueResult := struct {
-		Id            uint64
-		KeyCodePrefix string `db:"keycode_prefix"`
-		KeyCodeSuffix string `db:"keycode_suffix"`
-	}{0, "", ""}
-	var beforePerson int
-	var total int
-	var count int
-	if err = db.TxPreparexGet(tx, `select count(1) from summary_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and delete_flag = 0`,
-		&count, request.QueueCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
-
-	if count == 0 {
-		err = errors.New("failed, queue code not found. " + request.QueueCode)
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueueCodeNotfound, true, db.RollbackResolve(err, tx)))
+	if store.InMaintenance(summary, time.Now()) {
+		err = errors.New("failed, queue in maintenance. " + request.QueueCode)
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserMaintenance, true, err))
 	}
-
-	if err = db.TxPreparexGet(tx, `select count(1) from queue_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and status = ? and delete_flag = 0`,
-		&count, request.QueueCode, defs.StatusEnqueue); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	schedule, err := hours.Parse(summary.Schedule)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
-
-	if count > 0 {
-		err = errors.New("already enqueue. queue code:" + request.QueueCode + " uid:" + strconv.FormatUint(authCtx.Uid, 10))
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAlreadyEnqueue, true, db.RollbackResolve(err, tx)))
+	if !schedule.Accepts(time.Now()) {
+		err = errors.New("failed, out of business hours. " + request.QueueCode)
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserOutoftime, true, err))
 	}
 
-	if err = db.TxPreparexGet(tx, `select name, caption from summary_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and delete_flag = 0`,
-		&summaryResult, request.QueueCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	keyCodeSuffix, err := defs.NewKeyCodeSuffix()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgHashGenerateFailed, true, err))
 	}
 
-	if _, err = db.TxPreparexExec(tx, `insert into queue_`+db.ToSuffix(vendorId)+` (
-		queue_code, uid, keycode_prefix, keycode_suffix, mail_addr, mail_count,
-		push_type, push_count, status, delete_flag, create_at, update_at
-	) values (
-		from_base64(?), ?, cast(nextseq_`+db.ToSuffix(vendorId)+`("NUM") as char), ?, "", 0, 0, 0, ?, 0, utc_timestamp(), utc_timestamp()
-	)`, request.QueueCode, authCtx.Uid, keyCodeSuffix, defs.StatusEnqueue); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	ticket, err := store.Queues.Enqueue(vendorId, &store.Entry{QueueCode: request.QueueCode, Uid: authCtx.Uid, KeyCodeSuffix: keyCodeSuffix})
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
 
-	if err = db.TxPreparexGet(tx, `select id, keycode_prefix, keycode_suffix from queue_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and uid = ? and status = ? and delete_flag = 0  limit 1`,
-		&queueResult, request.QueueCode, authCtx.Uid, defs.StatusEnqueue); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	hub.Publish(vendorId, request.QueueCode)
+	// already enqueued, the estimate is left unknown on failure
+	if response.WaitEstimate, err = estimateWait(vendorId, request.QueueCode, ticket.Before); err != nil {
+		c.Echo().Logger.Debugf("estimate failed, %v", err)
 	}
+	c.Echo().Logger.Debug("enqueued")
+	response.VendorName = ticket.VendorName
+	response.VendorCaption = ticket.VendorCaption
+	response.KeyCodePrefix = ticket.KeyCodePrefix
+	response.KeyCodeSuffix = ticket.KeyCodeSuffix
+	response.PersonsWaitingBefore = ticket.Before
+	response.QueueName = ticket.QueueName
+	response.TotalWaiting = ticket.Total
+	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+}
 
-	if err = db.TxPreparexGet(tx, `select count(1) from queue_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and id < ? and status = ? and delete_flag = 0`,
-		&beforePerson, request.QueueCode, queueResult.Id, defs.StatusEnqueue); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+// ShowQueue keycode list in queue
+func ShowQueue(c echo.Context) error {
+	var err error
+	authCtx := c.(*defs.AuthContext)
+	response := ResBodyQueue{}
+	response.ResponseCode = defs.ResponseOk
+	response.Ticks = time.Now().Unix()
+	_, err = strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
 	}
 
-	if err = db.TxPreparexGet(tx, `select count(1) from queue_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and status = ? and delete_flag = 0`,
-		&total, request.QueueCode, defs.StatusEnqueue); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	vendorId, queueCode, code, err := queueParams(c)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, code, true, err))
 	}
-
-	if err := tx.Commit(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgCommitFailed, true, db.RollbackResolve(err, tx)))
+	if err = showPosition(&response, vendorId, queueCode, authCtx.Uid); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
 
-	c.Echo().Logger.Debug("enqueued")
-	response.VendorName = summaryResult.VendorName
-	response.VendorCaption = summaryResult.VendorCaption
-	response.KeyCodePrefix = queueResult.KeyCodePrefix
-	response.KeyCodeSuffix = queueResult.KeyCodeSuffix
-	response.PersonsWaitingBefore = beforePerson
-	response.TotalWaiting = total
+	c.Echo().Logger.Debug("show queue")
 	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
 }
 
-type ShowQueueResult struct {
-	Id     uint64
-	Status int
-}
-
-// ShowQueue keycode list in queue
-func ShowQueue(c echo.Context) error {
+// Stream queue position as server-sent events until dequeued or canceled.
+// each event data is the ShowQueue response, ResponseOkContinue while waiting or shelved.
+func Stream(c echo.Context) error {
 	var err error
 	authCtx := c.(*defs.AuthContext)
 	response := ResBodyQueue{}
@@ -416,7 +475,76 @@ func ShowQueue(c echo.Context) error {
 	if err != nil {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
 	}
+	vendorId, queueCode, code, err := queueParams(c)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, code, true, err))
+	}
+	if err = showPosition(&response, vendorId, queueCode, authCtx.Uid); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+
+	// subscribe before the first event, changes in between wake up the loop
+	subscription := hub.Subscribe(vendorId, queueCode)
+	defer subscription.Close()
+	keepAlive := time.NewTicker(StreamKeepAlive)
+	defer keepAlive.Stop()
+	deadline := time.NewTimer(StreamMaxDuration)
+	defer deadline.Stop()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	sent := false
+	last := ResBodyQueue{}
+	for {
+		if response.Status != int(defs.StatusEnqueue) && response.Status != int(defs.StatusShelved) {
+			response.ResponseCode = defs.ResponseOk
+		} else {
+			response.ResponseCode = defs.ResponseOkContinue
+		}
+		// only changes are sent
+		response.Ticks = last.Ticks
+		if !sent || response != last {
+			response.Ticks = time.Now().Unix()
+			if _, err = res.Write([]byte("data: " + defs.Encode(response, response.Ticks) + "\n\n")); err != nil {
+				return nil
+			}
+			res.Flush()
+			sent = true
+			last = response
+		}
+		if response.ResponseCode != defs.ResponseOkContinue {
+			c.Echo().Logger.Debug("stream closed")
+			return nil
+		}
+
+		select {
+		case <-subscription.C:
+		case <-keepAlive.C:
+			if _, err = res.Write([]byte(": keepalive\n\n")); err != nil {
+				return nil
+			}
+			res.Flush()
+			continue
+		case <-deadline.C:
+			return nil
+		case <-c.Request().Context().Done():
+			return nil
+		}
+		response = ResBodyQueue{}
+		response.Ticks = time.Now().Unix()
+		if err = showPosition(&response, vendorId, queueCode, authCtx.Uid); err != nil {
+			res.Write([]byte("data: " + defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err) + "\n\n"))
+			res.Flush()
+			return nil
+		}
+	}
+}
 
+// vendor id and queue code from url safe path params
+func queueParams(c echo.Context) (uint64, string, defs.ResponseCode, error) {
 	vendorCodeUrlSafed := c.Param("vendor_code")
 	queueCodeUrlSafed := c.Param("queue_code")
 	r := strings.NewReplacer("-", "=", "_", "/", ".", "+")
@@ -424,66 +552,60 @@ func ShowQueue(c echo.Context) error {
 	queueCode := r.Replace(queueCodeUrlSafed)
 
 	if len(vendorCode) == 0 {
-		err = errors.New("failed, vendor_code not found.")
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthNotFound, true, err))
+		return 0, "", defs.ResponseNgUserAuthNotFound, errors.New("failed, vendor_code not found.")
 	}
 	if len(queueCode) == 0 {
-		err = errors.New("failed, queue_code not found.")
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueueCodeNotfound, true, err))
+		return 0, "", defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue_code not found.")
 	}
 
-	master := db.Conns.Master()
-	var vendorId uint64
-	if err = db.PreparexGet(master, "select id from domain where to_base64(vendor_code) = ?",
-		&vendorId, vendorCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
+	vendorId, err := store.Vendors.IdByCode(vendorCode)
+	if err != nil {
+		return 0, "", store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), err
 	}
+	store.ShardSide(c.Response().Header(), vendorId)
+	return vendorId, queueCode, defs.ResponseOk, nil
+}
 
-	// create vendor shard tables.
-	shard, err := db.Conns.Shard(vendorId)
+// fill position of the user in the queue
+func showPosition(response *ResBodyQueue, vendorId uint64, queueCode string, uid uint64) error {
+	position, err := store.Queues.Position(vendorId, queueCode, uid)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgShardConnectFailed, true, err))
+		return err
 	}
-	results := []ShowQueueResult{}
-	var beforePerson int
-	var total int
-	var name string
-
-	if err = db.PreparexSelect(shard, `select id, status from queue_`+db.ToSuffix(vendorId)+
-		` where to_base64(queue_code) = ? and uid = ? and delete_flag = 0 limit 1`,
-		&results, queueCode, authCtx.Uid); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
+	response.Status = int(position.Status)
+	if position.Status == defs.StatusVendorCancel {
+		response.CancelReason = int(position.CancelReason)
+		response.CancelNote = position.CancelNote
 	}
-
-	if len(results) == 0 {
-		err = errors.New("failed, keycode not found.")
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgKeyCodeCodeNotfound, true, err))
+	if position.Status != defs.StatusEnqueue && position.Status != defs.StatusShelved {
+		return nil
 	}
-
-	if err = db.PreparexGet(shard, "select name from summary_" + db.ToSuffix(vendorId) + " where id = 1",
-                &name); err != nil {
-                return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
-        }
-
-	response.Status = results[0].Status
-	if results[0].Status == 1 {
-		if err = db.PreparexGet(shard, `select count(1) from queue_`+db.ToSuffix(vendorId)+
-			` where to_base64(queue_code) = ? and id < ? and status = ? and delete_flag = 0`,
-			&beforePerson, queueCode, results[0].Id, defs.StatusEnqueue); err != nil {
-			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
-		}
-		if err = db.PreparexGet(shard, `select count(1) from queue_`+db.ToSuffix(vendorId)+
-			` where to_base64(queue_code) = ? and status = ? and delete_flag = 0`,
-			&total, queueCode, defs.StatusEnqueue); err != nil {
-			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
-		}
-		response.Name = name
-		response.PersonsWaitingBefore = beforePerson
-		response.TotalWaiting = total
+	// names only while waiting, the queue may be deleted after
+	summary, err := store.Vendors.Summary(vendorId)
+	if err != nil {
+		return err
 	}
-
-	c.Echo().Logger.Debug("show queue")
-	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+	queue, err := store.Vendors.QueueSummary(vendorId, queueCode)
+	if err != nil {
+		return err
+	}
+	response.Name = summary.Name
+	response.QueueName = queue.Name
+	if queue.RequireAdmit && position.VendorAuthAt > 0 {
+		response.CalledAt = position.VendorAuthAt
+		response.ConfirmUntil = position.VendorAuthAt + int64(store.AdmitWindow(queue)/time.Second)
+	}
+	if store.InMaintenance(queue, time.Now()) {
+		response.Paused = true
+		response.PauseMessage = queue.MaintenanceMessage
+		response.PauseUntil = queue.MaintenanceUntil
+	}
+	response.MailAddr = position.MailAddr
+	response.PushType = int(position.PushType)
+	response.PersonsWaitingBefore = position.Before
+	response.TotalWaiting = position.Total
+	response.WaitEstimate, err = estimateWait(vendorId, queueCode, position.Before)
+	return err
 }
 
 // Dequeue vendor user request body struct
@@ -517,55 +639,159 @@ func Dequeue(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
 
-	master := db.Conns.Master()
-	var vendorId uint64
 	c.Echo().Logger.Debugf("vendor code: %s", request.VendorCode)
 	c.Echo().Logger.Debugf("queue code: %s", request.QueueCode)
 	c.Echo().Logger.Debugf("keycodeprefix: %s", request.KeyCodePrefix)
-	if err = db.PreparexGet(master, "select id from domain where to_base64(vendor_code) = ?", &vendorId, request.VendorCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
+	vendorId, err := store.Vendors.IdByCode(request.VendorCode)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
+	store.ShardSide(c.Response().Header(), vendorId)
 
-	shard, err := db.Conns.Shard(vendorId)
+	// confirm the call, dequeued when the vendor confirmed in window
+	result, err := store.Queues.Admit(vendorId, store.AdmitUser, authCtx.Uid, request.KeyCodePrefix, "")
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgShardConnectFailed, true, err))
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgUserDequeueFailed), true, err))
 	}
-	var tx *sqlx.Tx
-	var result sql.Result
-	var updated int64
-	if tx, err = shard.Beginx(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTransactBeginFailed, true, db.RollbackResolve(err, tx)))
+	hub.PublishVendor(vendorId)
+	switch result {
+	case store.AdmitExpired:
+		err = errors.New("failed, vendor confirmation expired. " + request.KeyCodePrefix)
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserCannotAuthDequeue, true, err))
+	case store.AdmitWaiting:
+		response.ResponseCode = defs.ResponseOkContinue
 	}
+	c.Echo().Logger.Debug("dequeue")
+	response.Updated = result == store.AdmitDequeued
+	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+}
 
-	if result, err = db.TxPreparexExec(tx, `update queue_`+db.ToSuffix(vendorId)+
-		` set status = ?, update_at = utc_timestamp() where uid = ? and status = ? and keycode_prefix = ?`,
-		defs.StatusDequeue, authCtx.Uid, defs.StatusEnqueue, request.KeyCodePrefix); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+// Cancel by user
+func Cancel(c echo.Context) error {
+	var err error
+	authCtx := c.(*defs.AuthContext)
+	bodyBytes, err := ioutil.ReadAll(c.Request().Body)
+	request := ReqBodyDequeue{}
+	response := ResBodyDequeue{}
+	response.ResponseCode = defs.ResponseOk
+	response.Ticks = time.Now().Unix()
+	ticks, err := strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
 	}
-	if updated, err = result.RowsAffected(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	if err = defs.Decode(bodyBytes, &request, ticks); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
-	if updated != 1 {
-	        c.Echo().Logger.Debug("update " + strconv.FormatInt(updated, 10))
-	        response.Updated = updated == 1
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserDequeueFailed, true, db.RollbackResolve(err, tx)))
+
+	c.Echo().Logger.Debugf("vendor code: %s", request.VendorCode)
+	c.Echo().Logger.Debugf("queue code: %s", request.QueueCode)
+	c.Echo().Logger.Debugf("keycodeprefix: %s", request.KeyCodePrefix)
+	vendorId, err := store.Vendors.IdByCode(request.VendorCode)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
-	if err = tx.Commit(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgCommitFailed, true, db.RollbackResolve(err, tx)))
+	store.ShardSide(c.Response().Header(), vendorId)
+
+	// shelved ticket is still the user's to cancel
+	updated, err := store.Queues.UpdateByUser(vendorId, authCtx.Uid, request.KeyCodePrefix, []defs.QueueStatus{defs.StatusEnqueue, defs.StatusShelved}, defs.StatusCancel)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+	if updated != 1 {
+		c.Echo().Logger.Debug("update " + strconv.FormatInt(updated, 10))
+		response.Updated = updated == 1
+		err = errors.New("failed, keycode not updated. " + request.KeyCodePrefix)
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserDequeueFailed, true, err))
 	}
 
-	c.Echo().Logger.Debug("dequeue")
+	hub.PublishVendor(vendorId)
+	c.Echo().Logger.Debug("cancel")
 	response.Updated = updated == 1
 	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
 }
 
-// Cancel by user
-func Cancel(c echo.Context) error {
+// Pending request body struct
+type ReqBodyPending struct {
+	VendorCode    string `json:"VendorCode"`
+	QueueCode     string `json:"QueueCode"`
+	KeyCodePrefix string `json:"KeyCodePrefix"`
+	// positions to move back, 0 is to the end
+	Steps int `json:"Steps"`
+	defs.RequestBodyBase
+}
+
+// Pending response body struct
+type ResBodyPending struct {
+	PersonsWaitingBefore int `json:"PersonsWaitingBefore"`
+	TotalWaiting         int `json:"TotalWaiting"`
+	defs.ResponseBodyBase
+}
+
+// Defer turn by user, keycode is kept
+func Pending(c echo.Context) error {
 	var err error
 	authCtx := c.(*defs.AuthContext)
 	bodyBytes, err := ioutil.ReadAll(c.Request().Body)
-	request := ReqBodyDequeue{}
-	response := ResBodyDequeue{}
+	request := ReqBodyPending{}
+	response := ResBodyPending{}
+	response.ResponseCode = defs.ResponseOk
+	response.Ticks = time.Now().Unix()
+	ticks, err := strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
+	}
+	if err = defs.Decode(bodyBytes, &request, ticks); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
+	}
+	if request.Steps < 0 {
+		err = errors.New("failed, steps is negative.")
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
+	}
+
+	c.Echo().Logger.Debugf("vendor code: %s", request.VendorCode)
+	c.Echo().Logger.Debugf("queue code: %s", request.QueueCode)
+	c.Echo().Logger.Debugf("keycodeprefix: %s", request.KeyCodePrefix)
+	vendorId, err := store.Vendors.IdByCode(request.VendorCode)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+	store.ShardSide(c.Response().Header(), vendorId)
+
+	position, err := store.Queues.Pending(vendorId, request.QueueCode, authCtx.Uid, request.KeyCodePrefix, request.Steps)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+
+	hub.Publish(vendorId, request.QueueCode)
+	c.Echo().Logger.Debugf("pending %d", request.Steps)
+	response.PersonsWaitingBefore = position.Before
+	response.TotalWaiting = position.Total
+	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+}
+
+// Mail request body struct
+type ReqBodyMail struct {
+	VendorCode    string `json:"VendorCode"`
+	QueueCode     string `json:"QueueCode"`
+	KeyCodePrefix string `json:"KeyCodePrefix"`
+	// empty turns mail off
+	MailAddr string `json:"MailAddr"`
+	defs.RequestBodyBase
+}
+
+// Mail response body struct
+type ResBodyMail struct {
+	MailOn bool `json:"MailOn"`
+	defs.ResponseBodyBase
+}
+
+// Turn mail notices of the keycode on or off by user
+func Mail(c echo.Context) error {
+	var err error
+	authCtx := c.(*defs.AuthContext)
+	bodyBytes, err := ioutil.ReadAll(c.Request().Body)
+	request := ReqBodyMail{}
+	response := ResBodyMail{}
 	response.ResponseCode = defs.ResponseOk
 	response.Ticks = time.Now().Unix()
 	ticks, err := strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
@@ -575,45 +801,125 @@ func Cancel(c echo.Context) error {
 	if err = defs.Decode(bodyBytes, &request, ticks); err != nil {
 		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
+	if request.MailAddr != "" {
+		if err = mail.ValidAddress(request.MailAddr); err != nil {
+			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
+		}
+	}
 
-	master := db.Conns.Master()
-	var vendorId uint64
 	c.Echo().Logger.Debugf("vendor code: %s", request.VendorCode)
 	c.Echo().Logger.Debugf("queue code: %s", request.QueueCode)
 	c.Echo().Logger.Debugf("keycodeprefix: %s", request.KeyCodePrefix)
-	if err = db.PreparexGet(master, "select id from domain where to_base64(vendor_code) = ?", &vendorId, request.VendorCode); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, err))
+	vendorId, err := store.Vendors.IdByCode(request.VendorCode)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+	store.ShardSide(c.Response().Header(), vendorId)
+
+	if err = store.Queues.SetMail(vendorId, request.QueueCode, authCtx.Uid, request.KeyCodePrefix, request.MailAddr); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
 
-	shard, err := db.Conns.Shard(vendorId)
+	// a notice already due is sent right away
+	hub.Publish(vendorId, request.QueueCode)
+	c.Echo().Logger.Debugf("mail on %t", request.MailAddr != "")
+	response.MailOn = request.MailAddr != ""
+	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+}
+
+// Push setup response body struct
+type ResBodyPushSetup struct {
+	// application server key for PushManager.subscribe, empty without web push
+	VapidPublicKey string `json:"VapidPublicKey"`
+	// push types accepted by Push
+	PushTypes []int `json:"PushTypes"`
+	defs.ResponseBodyBase
+}
+
+// Get push types and vapid key to subscribe with
+func ShowPush(c echo.Context) error {
+	var err error
+	response := ResBodyPushSetup{}
+	response.ResponseCode = defs.ResponseOk
+	response.Ticks = time.Now().Unix()
+	_, err = strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgShardConnectFailed, true, err))
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
 	}
-	var tx *sqlx.Tx
-	var result sql.Result
-	var updated int64
-	if tx, err = shard.Beginx(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTransactBeginFailed, true, db.RollbackResolve(err, tx)))
+
+	c.Echo().Logger.Debug("show push")
+	response.VapidPublicKey = push.Default.PublicKey()
+	response.PushTypes = []int{}
+	for _, pushType := range []defs.PushType{defs.PushTypeWebPush, defs.PushTypeGateway} {
+		if push.Default.Supports(pushType) {
+			response.PushTypes = append(response.PushTypes, int(pushType))
+		}
 	}
+	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
+}
+
+// Push request body struct
+type ReqBodyPush struct {
+	VendorCode    string `json:"VendorCode"`
+	QueueCode     string `json:"QueueCode"`
+	KeyCodePrefix string `json:"KeyCodePrefix"`
+	// 0 turns push off
+	PushType     int               `json:"PushType"`
+	Subscription push.Subscription `json:"Subscription"`
+	defs.RequestBodyBase
+}
+
+// Push response body struct
+type ResBodyPush struct {
+	PushType int `json:"PushType"`
+	defs.ResponseBodyBase
+}
 
-	if result, err = db.TxPreparexExec(tx, `update queue_`+db.ToSuffix(vendorId)+
-		` set status = ?, update_at = utc_timestamp() where uid = ? and status = ? and keycode_prefix = ?`,
-		defs.StatusCancel, authCtx.Uid, defs.StatusEnqueue, request.KeyCodePrefix); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+// Register or remove push subscription of the keycode by user
+func Push(c echo.Context) error {
+	var err error
+	authCtx := c.(*defs.AuthContext)
+	bodyBytes, err := ioutil.ReadAll(c.Request().Body)
+	request := ReqBodyPush{}
+	response := ResBodyPush{}
+	response.ResponseCode = defs.ResponseOk
+	response.Ticks = time.Now().Unix()
+	ticks, err := strconv.ParseInt(c.Request().Header.Get("IV"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgTicksInvalid, true, err))
 	}
-	if updated, err = result.RowsAffected(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgQueryExecuteFailed, true, db.RollbackResolve(err, tx)))
+	if err = defs.Decode(bodyBytes, &request, ticks); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
 	}
-	if updated != 1 {
-	        c.Echo().Logger.Debug("update " + strconv.FormatInt(updated, 10))
-	        response.Updated = updated == 1
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserDequeueFailed, true, db.RollbackResolve(err, tx)))
+	pushType := defs.PushType(request.PushType)
+	subscription := ""
+	if pushType != defs.PushTypeNone {
+		if !push.Default.Supports(pushType) {
+			err = errors.New("failed, push type not supported. " + strconv.Itoa(request.PushType))
+			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
+		}
+		if err = request.Subscription.Validate(pushType); err != nil {
+			return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgEncodeInvalid, true, err))
+		}
+		subscription = request.Subscription.String()
 	}
-	if err = tx.Commit(); err != nil {
-		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgCommitFailed, true, db.RollbackResolve(err, tx)))
+
+	c.Echo().Logger.Debugf("vendor code: %s", request.VendorCode)
+	c.Echo().Logger.Debugf("queue code: %s", request.QueueCode)
+	c.Echo().Logger.Debugf("keycodeprefix: %s", request.KeyCodePrefix)
+	vendorId, err := store.Vendors.IdByCode(request.VendorCode)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
 	}
+	store.ShardSide(c.Response().Header(), vendorId)
 
-	c.Echo().Logger.Debug("cancel")
-	response.Updated = updated == 1
+	if err = store.Queues.SetPush(vendorId, request.QueueCode, authCtx.Uid, request.KeyCodePrefix, pushType, subscription); err != nil {
+		return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgQueryExecuteFailed), true, err))
+	}
+
+	// a threshold already crossed is pushed right away
+	hub.Publish(vendorId, request.QueueCode)
+	c.Echo().Logger.Debugf("push type %d", request.PushType)
+	response.PushType = request.PushType
 	return c.String(http.StatusOK, defs.Encode(response, response.Ticks))
 }
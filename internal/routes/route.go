@@ -25,90 +25,92 @@ package route
 
 import (
 	"errors"
-	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"net/http"
-	"vql/internal/db"
 	"vql/internal/defs"
+	"vql/internal/logging"
 	"vql/internal/routes/priv"
 	"vql/internal/routes/queue"
 	"vql/internal/routes/vendor"
+	"vql/internal/store"
 )
 
 func Init(e *echo.Echo) {
-	e.Use(middleware.Logger())
+	e.Use(logging.AccessLog())
 	e.Use(middleware.Recover())
 
 	e.POST("/new", queue.Create)
 	e.POST("/logon", queue.Logon)
+	e.POST("/recover", queue.Recover)
+	e.POST("/sso", vendor.SsoLogon)
+	e.GET("/sso/callback", vendor.SsoCallback)
 	g := e.Group("/on")
 	g.Use(AuthMiddleware())
 	g.POST("/queue", queue.Enqueue)
 	g.GET("/queue/:vendor_code/:queue_code", queue.ShowQueue)
+	g.GET("/queue/:vendor_code/:queue_code/stream", queue.Stream)
 	g.POST("/dequeue", queue.Dequeue)
 	g.POST("/cancel", queue.Cancel)
+	g.POST("/pending", queue.Pending)
+	g.POST("/mail", queue.Mail)
+	g.GET("/push", queue.ShowPush)
+	g.POST("/push", queue.Push)
 	g.GET("/vendor", vendor.Detail)
 	g.POST("/vendor/upgrade", vendor.Upgrade)
 	g.POST("/vendor/update", vendor.Update)
+	g.POST("/vendor/sso", vendor.SsoLink)
 	g.POST("/vendor/queue/dummy", vendor.EnqueueDummy)
+	g.GET("/vendor/queues", vendor.ListQueues)
+	g.POST("/vendor/queues", vendor.CreateQueue)
+	g.PUT("/vendor/queues/:queue_code", vendor.UpdateQueue)
+	g.DELETE("/vendor/queues/:queue_code", vendor.DeleteQueue)
+	g.GET("/vendor/queues/:queue_code/hours", vendor.ShowHours)
+	g.PUT("/vendor/queues/:queue_code/hours", vendor.UpdateHours)
+	g.PUT("/vendor/queues/:queue_code/maintenance", vendor.UpdateMaintenance)
+	g.GET("/vendor/mail", vendor.ShowMail)
+	g.PUT("/vendor/mail", vendor.UpdateMail)
+	g.GET("/vendor/push", vendor.ShowPush)
+	g.PUT("/vendor/push", vendor.UpdatePush)
 	g.GET("/vendor/manage/", vendor.Manage)
 	g.GET("/vendor/manage/:queue_code/:page", vendor.Manage)
 	g.GET("/vendor/queue/:queue_code/:page", vendor.ShowQueue)
 	g.POST("/vendor/dequeue", vendor.Dequeue)
+	g.POST("/vendor/shelve", vendor.Shelve)
+	g.POST("/vendor/unshelve", vendor.Unshelve)
+	g.POST("/vendor/move", vendor.Move)
+	g.POST("/vendor/cancel", vendor.Cancel)
+	g.POST("/vendor/verify", vendor.Verify)
 	g.DELETE("/priv/vendor", priv.DropVendor)
 }
 
-type AuthResult struct {
-        Id     uint64
-	SessionPrivate string `db:"session_private"`
-}
 // middleware function just to output message
 func AuthMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			master := db.Conns.Master()
 			sessionId := c.Request().Header.Get("Session")
 			nonce := c.Request().Header.Get("Nonce")
 			hash := c.Request().Header.Get("Hash")
 			response := defs.ResponseBodyBase{}
 
-			var tx *sqlx.Tx
-			var err error
-			results := []AuthResult{}
 			ac := &defs.AuthContext{c, 0}
-			if tx, err = master.Beginx(); err != nil {
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, db.RollbackResolve(err, tx)))
-			}
-			if err = db.TxPreparexSelect(tx, `select id, to_base64(session_private) as session_private from auth where to_base64(session_id) = ? and date_add(session_footprint, interval `+
-				defs.SessionTimeout+` minute) > utc_timestamp()`, &results, sessionId); err != nil {
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthNotFound, true, db.RollbackResolve(err, tx)))
-			}
-
-			count := len(results)
-			if count == 0 {
-				err = errors.New("failed, session not found. " + sessionId)
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthNotFound, true, db.RollbackResolve(err, tx)))
-			} else if count > 1 {
-				err = errors.New("failed, invalid session. " + sessionId)
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, db.RollbackResolve(err, tx)))
+			session, err := store.Auths.FindSession(sessionId)
+			if err != nil {
+				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgUserAuthNotFound), true, err))
 			}
 
 			// check validate hash
-			verifyHash := defs.ToHmacSha256(results[0].SessionPrivate+nonce, defs.MagicKey)
+			verifyHash := defs.ToHmacSha256(session.SessionPrivate+nonce, defs.MagicKey)
 			if hash != verifyHash {
 				err = errors.New("failed, verify session. " + sessionId)
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, db.RollbackResolve(err, tx)))
+				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, err))
 			}
 
-			ac.Uid = results[0].Id
-
-			if _, err = db.TxPreparexExec(tx, "update auth set session_footprint = utc_timestamp() where to_base64(session_id) = ?", sessionId); err != nil {
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, db.RollbackResolve(err, tx)))
-			}
+			ac.Uid = session.Id
+			c.Set(defs.ContextKeyUid, ac.Uid)
 
-			if err = tx.Commit(); err != nil {
-				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, defs.ResponseNgUserAuthFailed, true, db.RollbackResolve(err, tx)))
+			if err = store.Auths.TouchSession(sessionId); err != nil {
+				return c.String(http.StatusInternalServerError, defs.ErrorDispose(c, &response, store.CodeOf(err, defs.ResponseNgUserAuthFailed), true, err))
 			}
 			return next(ac)
 		}
@@ -0,0 +1,209 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"vql/internal/cache"
+	"vql/internal/db"
+)
+
+// cache keys
+const (
+	// base64 vendor code -> vendor id
+	keyVendor = "vql:vendor:"
+	// vendor id -> summary json
+	keySummary = "vql:summary:"
+	// base64 session id -> uid and base64 session private
+	keySession = "vql:session:"
+	// uid -> current base64 session id, a cached session is valid only while this matches
+	keyUser = "vql:user:"
+	// base64 session id -> footprint recently updated
+	keyTouch = "vql:touch:"
+)
+
+// Put cache in front of current auth and vendor stores, nil cache does nothing.
+// sessions are trusted from cache for the cache ttl, so footprints are written once per ttl.
+func UseCache(c *cache.Cache) {
+	if c == nil {
+		return
+	}
+	Auths = &cachedAuths{Auths, c}
+	Vendors = &cachedVendors{Vendors, c}
+}
+
+type cachedAuths struct {
+	AuthStore
+	cache *cache.Cache
+}
+
+func (s *cachedAuths) RenewSession(privateCode string, sessionId []byte, sessionPrivate []byte) (uint64, error) {
+	id, err := s.AuthStore.RenewSession(privateCode, sessionId, sessionPrivate)
+	if err == nil {
+		s.cache.Delete(keyUser + strconv.FormatUint(id, 10))
+	}
+	return id, err
+}
+
+func (s *cachedAuths) Recover(identifier string, seed string, privateCode []byte, sessionId []byte, sessionPrivate []byte) (uint64, []byte, error) {
+	id, previous, err := s.AuthStore.Recover(identifier, seed, privateCode, sessionId, sessionPrivate)
+	if err == nil {
+		s.cache.Delete(keyUser + strconv.FormatUint(id, 10))
+	}
+	return id, previous, err
+}
+
+func (s *cachedAuths) FindSession(sessionId string) (*Session, error) {
+	if value, ok := s.cache.Get(keySession + sessionId); ok {
+		if session := parseSession(value); session != nil {
+			if current, ok := s.cache.Get(keyUser + strconv.FormatUint(session.Id, 10)); ok && current == sessionId {
+				return session, nil
+			}
+		}
+	}
+	session, err := s.AuthStore.FindSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.Set(keyUser+strconv.FormatUint(session.Id, 10), sessionId)
+	s.cache.Set(keySession+sessionId, strconv.FormatUint(session.Id, 10)+" "+session.SessionPrivate)
+	return session, nil
+}
+
+func parseSession(value string) *Session {
+	fields := strings.SplitN(value, " ", 2)
+	if len(fields) != 2 {
+		return nil
+	}
+	id, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &Session{id, fields[1]}
+}
+
+func (s *cachedAuths) TouchSession(sessionId string) error {
+	if _, ok := s.cache.Get(keyTouch + sessionId); ok {
+		return nil
+	}
+	if err := s.AuthStore.TouchSession(sessionId); err != nil {
+		return err
+	}
+	s.cache.Set(keyTouch+sessionId, "1")
+	return nil
+}
+
+type cachedVendors struct {
+	VendorStore
+	cache *cache.Cache
+}
+
+func (s *cachedVendors) IdByCode(vendorCode string) (uint64, error) {
+	if value, ok := s.cache.Get(keyVendor + vendorCode); ok {
+		if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return id, nil
+		}
+	}
+	id, err := s.VendorStore.IdByCode(vendorCode)
+	if err != nil {
+		return 0, err
+	}
+	s.cache.Set(keyVendor+vendorCode, strconv.FormatUint(id, 10))
+	return id, nil
+}
+
+func (s *cachedVendors) Summary(vendorId uint64) (*db.Summary, error) {
+	key := keySummary + strconv.FormatUint(vendorId, 10)
+	if value, ok := s.cache.Get(key); ok {
+		summary := db.Summary{}
+		if json.Unmarshal([]byte(value), &summary) == nil {
+			return &summary, nil
+		}
+	}
+	summary, err := s.VendorStore.Summary(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := json.Marshal(summary); err == nil {
+		s.cache.Set(key, string(b))
+	}
+	return summary, nil
+}
+
+// forget vendor code and summary of the vendor
+func (s *cachedVendors) invalidate(vendorId uint64) {
+	keys := []string{keySummary + strconv.FormatUint(vendorId, 10)}
+	if domain, err := s.VendorStore.Domain(vendorId); err == nil && len(domain.VendorCode) > 0 {
+		keys = append(keys, keyVendor+encode(domain.VendorCode))
+	}
+	s.cache.Delete(keys...)
+}
+
+func (s *cachedVendors) Assign(vendorId uint64, vendorCode []byte) error {
+	s.invalidate(vendorId)
+	return s.VendorStore.Assign(vendorId, vendorCode)
+}
+
+func (s *cachedVendors) Provision(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.Provision(vendorId, name, caption, queueCode, requireAdmit, capacity)
+}
+
+func (s *cachedVendors) Update(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity CapacityChange) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.Update(vendorId, name, caption, queueCode, requireAdmit, capacity)
+}
+
+// the primary queue row is the cached summary
+func (s *cachedVendors) UpdateQueue(vendorId uint64, queueCode string, name string, caption string, requireAdmit bool, capacity CapacityChange) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.UpdateQueue(vendorId, queueCode, name, caption, requireAdmit, capacity)
+}
+
+func (s *cachedVendors) SetSchedule(vendorId uint64, queueCode string, schedule string) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.SetSchedule(vendorId, queueCode, schedule)
+}
+
+func (s *cachedVendors) SetMaintenance(vendorId uint64, queueCode string, maintenance Maintenance) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.SetMaintenance(vendorId, queueCode, maintenance)
+}
+
+func (s *cachedVendors) SetMailTemplate(vendorId uint64, template string) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.SetMailTemplate(vendorId, template)
+}
+
+func (s *cachedVendors) SetPushThresholds(vendorId uint64, thresholds string) error {
+	defer s.invalidate(vendorId)
+	return s.VendorStore.SetPushThresholds(vendorId, thresholds)
+}
+
+func (s *cachedVendors) Drop(vendorId uint64) error {
+	s.invalidate(vendorId)
+	return s.VendorStore.Drop(vendorId)
+}
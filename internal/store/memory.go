@@ -0,0 +1,1227 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"vql/internal/db"
+	"vql/internal/defs"
+)
+
+// in-memory tables, a single lock stands for the transactions
+type memory struct {
+	sync.Mutex
+	lastId        uint64
+	domains       map[uint64]*db.Domain
+	auths         map[uint64]*db.Auth
+	subscriptions map[uint64]*db.Subscription
+	vendors       map[uint64]*memoryVendor
+	ssos          map[memoryIdentity]uint64
+	now           func() time.Time
+}
+
+// vendor shard tables
+type memoryVendor struct {
+	// summary rows in id order, id 1 is the primary queue holding vendor name
+	summaries []*db.Summary
+	// NUM sequence current value
+	seq    uint64
+	lastId uint64
+	queue  []*memoryEntry
+	backup []*memoryEntry
+	// issued keycodes, kept across resets
+	keycodes []*db.KeyCode
+}
+
+type memoryIdentity struct {
+	issuer  string
+	subject string
+}
+
+type memoryEntry struct {
+	Id            uint64
+	QueueCode     string
+	Uid           uint64
+	KeyCodePrefix string
+	KeyCodeSuffix string
+	MailAddr      string
+	MailCount     uint16
+	MailNotice    MailNotice
+	PushType      defs.PushType
+	Subscription  string
+	PushCount     uint16
+	PushNotice    uint16
+	Position      uint64
+	PendingCount  uint16
+	CancelReason  defs.CancelReason
+	CancelNote    string
+	VendorAuthAt  int64
+	UserAuthAt    int64
+	Status        defs.QueueStatus
+	CreateAt      time.Time
+	UpdateAt      time.Time
+}
+
+// Use fresh in-memory stores, data is lost on next call
+func UseMemory() {
+	m := &memory{
+		domains:       map[uint64]*db.Domain{},
+		auths:         map[uint64]*db.Auth{},
+		subscriptions: map[uint64]*db.Subscription{},
+		vendors:       map[uint64]*memoryVendor{},
+		ssos:          map[memoryIdentity]uint64{},
+		now:           func() time.Time { return time.Now().UTC() },
+	}
+	Auths = &memoryAuths{m}
+	Subscriptions = &memorySubscriptions{m}
+	Vendors = &memoryVendors{m}
+	Queues = &memoryQueues{m}
+}
+
+var errNoRows = fail(defs.ResponseNgQueryExecuteFailed, sql.ErrNoRows)
+
+func encode(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// vendor shard tables, same failure as an unassigned shard if not provisioned
+func (m *memory) vendor(vendorId uint64) (*memoryVendor, error) {
+	v, ok := m.vendors[vendorId]
+	if !ok {
+		return nil, fail(defs.ResponseNgShardConnectFailed, db.ErrShardUnassigned)
+	}
+	return v, nil
+}
+
+func (v *memoryVendor) nextseq() uint64 {
+	v.seq++
+	return v.seq
+}
+
+type memoryAuths struct {
+	*memory
+}
+
+func (s *memoryAuths) Create(a *Account) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	s.lastId++
+	id := s.lastId
+	s.domains[id] = &db.Domain{Id: id, ServiceCode: uint8(defs.ServiceCode), Shard: db.ShardUnassigned, CreateAt: now, UpdateAt: now}
+	s.auths[id] = &db.Auth{
+		Id:               id,
+		PlatformType:     a.PlatformType,
+		IdentifierType:   a.IdentifierType,
+		Identifier:       a.Identifier,
+		Seed:             a.Seed,
+		PrivateCode:      a.PrivateCode,
+		AccountType:      uint8(defs.NormalUser),
+		AgreementVer:     a.AgreementVersion,
+		AgreementTime:    now,
+		Activate:         a.Agreed,
+		ActivateType:     uint8(a.ActivateType),
+		ActivateKeyword:  a.ActivateKeyword,
+		ActivateTime:     now,
+		SessionId:        string(a.SessionId),
+		SessionPrivate:   string(a.SessionPrivate),
+		SessionFootprint: now,
+		CreateAt:         now,
+		UpdateAt:         now,
+	}
+	s.subscriptions[id] = &db.Subscription{Id: id, SubscriptionType: uint8(defs.FreePlan), SubscriptionExpire: now, CreateAt: now, UpdateAt: now}
+	return id, nil
+}
+
+func (s *memoryAuths) Get(id uint64) (*db.Auth, error) {
+	s.Lock()
+	defer s.Unlock()
+	auth, ok := s.auths[id]
+	if !ok {
+		return nil, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, account not found."))
+	}
+	copied := *auth
+	return &copied, nil
+}
+
+func (s *memoryAuths) RenewSession(privateCode string, sessionId []byte, sessionPrivate []byte) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	found := []*db.Auth{}
+	for _, auth := range s.auths {
+		if encode(auth.PrivateCode) == privateCode {
+			found = append(found, auth)
+		}
+	}
+	if len(found) == 0 {
+		return 0, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, private code not found. "+privateCode))
+	} else if len(found) > 1 {
+		return 0, fail(defs.ResponseNgUserAuthFailed, errors.New("failed, invalid private code. "+privateCode))
+	}
+	now := s.now()
+	found[0].SessionId = string(sessionId)
+	found[0].SessionPrivate = string(sessionPrivate)
+	found[0].SessionFootprint = now
+	found[0].UpdateAt = now
+	return found[0].Id, nil
+}
+
+func (s *memoryAuths) Recover(identifier string, seed string, privateCode []byte, sessionId []byte, sessionPrivate []byte) (uint64, []byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, auth := range s.auths {
+		if auth.Identifier != identifier || auth.Seed != seed {
+			continue
+		}
+		now := s.now()
+		previous := auth.PrivateCode
+		auth.PrivateCode = privateCode
+		auth.SessionId = string(sessionId)
+		auth.SessionPrivate = string(sessionPrivate)
+		auth.SessionFootprint = now
+		auth.UpdateAt = now
+		return auth.Id, previous, nil
+	}
+	return 0, nil, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, seed not found. "+identifier))
+}
+
+// auths with the live session
+func (s *memoryAuths) sessions(sessionId string) []*db.Auth {
+	minutes, _ := strconv.Atoi(defs.SessionTimeout)
+	deadline := s.now().Add(-time.Duration(minutes) * time.Minute)
+	found := []*db.Auth{}
+	for _, auth := range s.auths {
+		if encode([]byte(auth.SessionId)) == sessionId && auth.SessionFootprint.After(deadline) {
+			found = append(found, auth)
+		}
+	}
+	return found
+}
+
+func (s *memoryAuths) FindSession(sessionId string) (*Session, error) {
+	s.Lock()
+	defer s.Unlock()
+	results := []Session{}
+	for _, auth := range s.sessions(sessionId) {
+		results = append(results, Session{auth.Id, encode([]byte(auth.SessionPrivate))})
+	}
+	return oneSession(results, sessionId)
+}
+
+func (s *memoryAuths) TouchSession(sessionId string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, auth := range s.sessions(sessionId) {
+		auth.SessionFootprint = s.now()
+	}
+	return nil
+}
+
+func (s *memoryAuths) SetAccountType(id uint64, accountType defs.AccountType) error {
+	s.Lock()
+	defer s.Unlock()
+	if auth, ok := s.auths[id]; ok {
+		auth.AccountType = uint8(accountType)
+		auth.UpdateAt = s.now()
+	}
+	return nil
+}
+
+func (s *memoryAuths) LinkIdentity(id uint64, issuer string, subject string) error {
+	s.Lock()
+	defer s.Unlock()
+	identity := memoryIdentity{issuer, subject}
+	if linked, ok := s.ssos[identity]; ok && linked != id {
+		return fail(defs.ResponseNgVendorAuthFailed, errors.New("failed, identity linked to another account. "+subject))
+	}
+	for other, linked := range s.ssos {
+		if linked == id {
+			delete(s.ssos, other)
+		}
+	}
+	s.ssos[identity] = id
+	return nil
+}
+
+func (s *memoryAuths) FindIdentity(issuer string, subject string) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	id, ok := s.ssos[memoryIdentity{issuer, subject}]
+	if !ok {
+		return 0, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, identity not linked. "+subject))
+	}
+	return id, nil
+}
+
+type memorySubscriptions struct {
+	*memory
+}
+
+func (s *memorySubscriptions) Get(id uint64) (*db.Subscription, error) {
+	s.Lock()
+	defer s.Unlock()
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return nil, errNoRows
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (s *memorySubscriptions) Update(id uint64, plan defs.SubscriptionType, expire time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	if subscription, ok := s.subscriptions[id]; ok {
+		subscription.SubscriptionType = uint8(plan)
+		subscription.SubscriptionExpire = expire.UTC()
+		subscription.UpdateAt = s.now()
+	}
+	return nil
+}
+
+type memoryVendors struct {
+	*memory
+}
+
+func (s *memoryVendors) IdByCode(vendorCode string) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	for id, domain := range s.domains {
+		if len(domain.VendorCode) > 0 && encode(domain.VendorCode) == vendorCode {
+			return id, nil
+		}
+	}
+	return 0, errNoRows
+}
+
+func (s *memoryVendors) Domain(vendorId uint64) (*db.Domain, error) {
+	s.Lock()
+	defer s.Unlock()
+	domain, ok := s.domains[vendorId]
+	if !ok {
+		return nil, errNoRows
+	}
+	copied := *domain
+	return &copied, nil
+}
+
+func (s *memoryVendors) Assign(vendorId uint64, vendorCode []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	domain, ok := s.domains[vendorId]
+	if !ok {
+		return errNoRows
+	}
+	domain.VendorCode = append([]byte{}, vendorCode...)
+	if domain.Shard < 0 {
+		domain.Shard = 0
+	}
+	domain.UpdateAt = s.now()
+	return nil
+}
+
+func (s *memoryVendors) Provision(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) error {
+	s.Lock()
+	defer s.Unlock()
+	if domain, ok := s.domains[vendorId]; !ok || domain.Shard < 0 {
+		return fail(defs.ResponseNgShardConnectFailed, db.ErrShardUnassigned)
+	}
+	if _, ok := s.vendors[vendorId]; ok {
+		return fail(defs.ResponseNgQueryExecuteFailed, errors.New("failed, vendor tables already exist. "+strconv.FormatUint(vendorId, 10)))
+	}
+	now := s.now()
+	v := &memoryVendor{summaries: []*db.Summary{{Id: PrimaryQueueId, Name: name, Caption: caption,
+		MaxWaiting: capacity.MaxWaiting, DailyCap: capacity.DailyCap, MaxPending: capacity.MaxPending, AdmitWindow: capacity.AdmitWindow, CreateAt: now, UpdateAt: now}}}
+	v.reset(queueCode, requireAdmit, now)
+	s.vendors[vendorId] = v
+	return nil
+}
+
+// backup and empty primary queue, then set new queue code
+func (v *memoryVendor) reset(queueCode []byte, requireAdmit bool, now time.Time) {
+	primary := v.summaries[0]
+	old := encode(primary.QueueCode)
+	v.backup = nil
+	kept := []*memoryEntry{}
+	for _, e := range v.queue {
+		if e.QueueCode == old {
+			v.backup = append(v.backup, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	v.queue = kept
+	primary.QueueCode = append([]byte{}, queueCode...)
+	primary.ResetCount = uint16(v.nextseq())
+	primary.RequireAdmit = requireAdmit
+	primary.UpdateAt = now
+}
+
+func (s *memoryVendors) Summary(vendorId uint64) (*db.Summary, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summary := *v.summaries[0]
+	return &summary, nil
+}
+
+func (s *memoryVendors) Update(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity CapacityChange) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	v.summaries[0].Name = name
+	v.summaries[0].Caption = caption
+	changeCapacity(v.summaries[0], capacity)
+	v.summaries[0].UpdateAt = now
+	if queueCode != nil {
+		v.reset(queueCode, requireAdmit, now)
+	}
+	return nil
+}
+
+func (s *memoryVendors) ListQueues(vendorId uint64) ([]db.Summary, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []db.Summary{}
+	for _, summary := range v.summaries {
+		if summary.DeleteFlag == 0 {
+			summaries = append(summaries, *summary)
+		}
+	}
+	return summaries, nil
+}
+
+func (s *memoryVendors) QueueSummary(vendorId uint64, queueCode string) (*db.Summary, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summary := v.find(queueCode)
+	if summary == nil {
+		return nil, fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	copied := *summary
+	return &copied, nil
+}
+
+func (s *memoryVendors) AddQueue(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return 0, err
+	}
+	for _, summary := range v.summaries {
+		if bytes.Equal(summary.QueueCode, queueCode) {
+			return 0, fail(defs.ResponseNgQueryExecuteFailed, errors.New("failed, duplicate queue code. "+encode(queueCode)))
+		}
+	}
+	now := s.now()
+	id := v.summaries[len(v.summaries)-1].Id + 1
+	v.summaries = append(v.summaries, &db.Summary{
+		Id:           id,
+		QueueCode:    append([]byte{}, queueCode...),
+		Name:         name,
+		Caption:      caption,
+		RequireAdmit: requireAdmit,
+		MaxWaiting:   capacity.MaxWaiting,
+		DailyCap:     capacity.DailyCap,
+		MaxPending:   capacity.MaxPending,
+		AdmitWindow:  capacity.AdmitWindow,
+		CreateAt:     now,
+		UpdateAt:     now,
+	})
+	return id, nil
+}
+
+func (s *memoryVendors) UpdateQueue(vendorId uint64, queueCode string, name string, caption string, requireAdmit bool, capacity CapacityChange) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	summary := v.find(queueCode)
+	if summary == nil {
+		return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	summary.Name = name
+	summary.Caption = caption
+	summary.RequireAdmit = requireAdmit
+	changeCapacity(summary, capacity)
+	summary.UpdateAt = s.now()
+	return nil
+}
+
+// set the changed capacity columns of summary
+func changeCapacity(summary *db.Summary, change CapacityChange) {
+	capacity := change.Apply(Capacity{MaxWaiting: summary.MaxWaiting, DailyCap: summary.DailyCap, MaxPending: summary.MaxPending, AdmitWindow: summary.AdmitWindow})
+	summary.MaxWaiting = capacity.MaxWaiting
+	summary.DailyCap = capacity.DailyCap
+	summary.MaxPending = capacity.MaxPending
+	summary.AdmitWindow = capacity.AdmitWindow
+}
+
+func (s *memoryVendors) SetSchedule(vendorId uint64, queueCode string, schedule string) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	summary := v.find(queueCode)
+	if summary == nil {
+		return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	summary.Schedule = schedule
+	summary.UpdateAt = s.now()
+	return nil
+}
+
+func (s *memoryVendors) SetMaintenance(vendorId uint64, queueCode string, maintenance Maintenance) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	summary := v.find(queueCode)
+	if summary == nil {
+		return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	summary.Maintenance = maintenance.Maintenance
+	summary.MaintenanceMessage = maintenance.Message
+	summary.MaintenanceUntil = maintenance.Until
+	summary.UpdateAt = s.now()
+	return nil
+}
+
+func (s *memoryVendors) SetMailTemplate(vendorId uint64, template string) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	v.summaries[0].MailTemplate = template
+	v.summaries[0].UpdateAt = s.now()
+	return nil
+}
+
+func (s *memoryVendors) SetPushThresholds(vendorId uint64, thresholds string) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	v.summaries[0].PushThresholds = thresholds
+	v.summaries[0].UpdateAt = s.now()
+	return nil
+}
+
+func (s *memoryVendors) DeleteQueue(vendorId uint64, queueCode string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return 0, err
+	}
+	summary := v.find(queueCode)
+	if summary == nil {
+		return 0, fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	if summary.Id == PrimaryQueueId {
+		return 0, fail(defs.ResponseNgVendorCannotDeleteQueue, errors.New("failed, primary queue cannot be deleted. "+queueCode))
+	}
+	now := s.now()
+	summary.DeleteFlag = 1
+	summary.UpdateAt = now
+	var canceled int64
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode && (e.Status == defs.StatusEnqueue || e.Status == defs.StatusShelved) {
+			e.Status = defs.StatusVendorCancel
+			e.CancelReason = defs.CancelReasonQueueDeleted
+			e.CancelNote = ""
+			canceled++
+		}
+	}
+	return canceled, nil
+}
+
+func (s *memoryVendors) Drop(vendorId uint64) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.domains[vendorId]; !ok {
+		return errNoRows
+	}
+	delete(s.vendors, vendorId)
+	return nil
+}
+
+func (s *memoryVendors) Side(vendorId uint64) string {
+	return ""
+}
+
+type memoryQueues struct {
+	*memory
+}
+
+// live summary row owning the base64 queue code, nil if none
+func (v *memoryVendor) find(queueCode string) *db.Summary {
+	for _, summary := range v.summaries {
+		if summary.DeleteFlag == 0 && encode(summary.QueueCode) == queueCode {
+			return summary
+		}
+	}
+	return nil
+}
+
+// count waiting entries in queue, before position if position > 0
+func (v *memoryVendor) waiting(queueCode string, position uint64) int {
+	count := 0
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode && e.Status == defs.StatusEnqueue && (position == 0 || e.Position < position) {
+			count++
+		}
+	}
+	return count
+}
+
+// check capacity of the queue
+func (v *memoryVendor) admit(summary *db.Summary, now time.Time) error {
+	queueCode := encode(summary.QueueCode)
+	if summary.MaxWaiting > 0 && v.waiting(queueCode, 0) >= int(summary.MaxWaiting) {
+		return fail(defs.ResponseNgUserMaxover, errors.New("failed, queue is full. "+queueCode))
+	}
+	if summary.DailyCap == 0 {
+		return nil
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	issued := 0
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode && !e.CreateAt.Before(today) {
+			issued++
+		}
+	}
+	if issued >= int(summary.DailyCap) {
+		return fail(defs.ResponseNgUserMaxover, errors.New("failed, daily cap reached. "+queueCode))
+	}
+	return nil
+}
+
+func (s *memoryQueues) Enqueue(vendorId uint64, entry *Entry) (*Ticket, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summary := v.find(entry.QueueCode)
+	if summary == nil {
+		return nil, fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+entry.QueueCode))
+	}
+	if err = v.admit(summary, s.now()); err != nil {
+		return nil, err
+	}
+	if !entry.AllowDuplicate {
+		for _, e := range v.queue {
+			if e.QueueCode == entry.QueueCode && e.Uid == entry.Uid && e.Status == defs.StatusEnqueue {
+				return nil, fail(defs.ResponseNgUserAlreadyEnqueue, errors.New("already enqueue. queue code:"+entry.QueueCode+" uid:"+strconv.FormatUint(entry.Uid, 10)))
+			}
+		}
+	}
+	var position uint64
+	for _, e := range v.queue {
+		if e.QueueCode == entry.QueueCode && e.Position > position {
+			position = e.Position
+		}
+	}
+	v.lastId++
+	added := &memoryEntry{
+		Id:            v.lastId,
+		QueueCode:     entry.QueueCode,
+		Uid:           entry.Uid,
+		KeyCodePrefix: strconv.FormatUint(v.nextseq(), 10),
+		KeyCodeSuffix: entry.KeyCodeSuffix,
+		Position:      position + 1,
+		Status:        defs.StatusEnqueue,
+		CreateAt:      s.now(),
+		UpdateAt:      s.now(),
+	}
+	v.queue = append(v.queue, added)
+	v.keycodes = append(v.keycodes, &db.KeyCode{
+		Id:            uint64(len(v.keycodes) + 1),
+		QueueId:       added.Id,
+		QueueCode:     summary.QueueCode,
+		ResetCount:    summary.ResetCount,
+		KeyCodePrefix: added.KeyCodePrefix,
+		KeyCodeSuffix: added.KeyCodeSuffix,
+		CreateAt:      added.CreateAt,
+		UpdateAt:      added.CreateAt,
+	})
+	return &Ticket{
+		Id:            added.Id,
+		KeyCodePrefix: added.KeyCodePrefix,
+		KeyCodeSuffix: added.KeyCodeSuffix,
+		VendorName:    v.summaries[0].Name,
+		VendorCaption: v.summaries[0].Caption,
+		QueueName:     summary.Name,
+		Before:        v.waiting(entry.QueueCode, added.Position),
+		Total:         v.waiting(entry.QueueCode, 0),
+	}, nil
+}
+
+func (s *memoryQueues) Position(vendorId uint64, queueCode string, uid uint64) (*Position, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	// first live entry of the uid, the latest one if none is waiting or shelved
+	var found *memoryEntry
+	for _, e := range v.queue {
+		if e.QueueCode != queueCode || e.Uid != uid {
+			continue
+		}
+		found = e
+		if e.Status == defs.StatusEnqueue || e.Status == defs.StatusShelved {
+			break
+		}
+	}
+	if found == nil {
+		return nil, fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+	}
+	e := found
+	position := &Position{Id: e.Id, Order: e.Position, Status: e.Status, CancelReason: e.CancelReason, CancelNote: e.CancelNote, VendorAuthAt: e.VendorAuthAt, MailAddr: e.MailAddr, PushType: e.PushType}
+	if e.Status == defs.StatusEnqueue || e.Status == defs.StatusShelved {
+		position.Before = v.waiting(queueCode, e.Position)
+		position.Total = v.waiting(queueCode, 0)
+	}
+	return position, nil
+}
+
+func (s *memoryQueues) Pending(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, steps int) (*Position, error) {
+	s.Lock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		s.Unlock()
+		return nil, err
+	}
+	err = v.pending(queueCode, uid, keyCodePrefix, steps, s.now())
+	s.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return s.Position(vendorId, queueCode, uid)
+}
+
+func (v *memoryVendor) pending(queueCode string, uid uint64, keyCodePrefix string, steps int, now time.Time) error {
+	summary := v.find(queueCode)
+	if summary == nil {
+		return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	var mine *memoryEntry
+	after := []*memoryEntry{}
+	for _, e := range v.queue {
+		if e.QueueCode != queueCode || e.Status != defs.StatusEnqueue {
+			continue
+		}
+		if e.Uid == uid && e.KeyCodePrefix == keyCodePrefix {
+			mine = e
+		}
+	}
+	if mine == nil {
+		return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+	}
+	if mine.PendingCount >= summary.MaxPending {
+		return fail(defs.ResponseNgUserCannotPending, errors.New("failed, pending limit reached. "+keyCodePrefix))
+	}
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode && e.Status == defs.StatusEnqueue && e.Position > mine.Position {
+			after = append(after, e)
+		}
+	}
+	if len(after) == 0 {
+		return fail(defs.ResponseNgUserCannotPending, errors.New("failed, already at the end. "+keyCodePrefix))
+	}
+	sort.Slice(after, func(i, j int) bool { return after[i].Position < after[j].Position })
+	if steps > 0 && steps < len(after) {
+		after = after[:steps]
+	}
+
+	position := after[len(after)-1].Position
+	if steps <= 0 {
+		position++
+	} else {
+		previous := mine.Position
+		for _, a := range after {
+			a.Position, previous = previous, a.Position
+		}
+	}
+	mine.Position = position
+	mine.PendingCount++
+	mine.UpdateAt = now
+	return nil
+}
+
+// set status of matched entries, nothing changes when more than one matches
+func (s *memoryQueues) update(vendorId uint64, to defs.QueueStatus, match func(e *memoryEntry) bool) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return 0, err
+	}
+	matched := []*memoryEntry{}
+	for _, e := range v.queue {
+		if match(e) {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) > 1 {
+		return int64(len(matched)), fail(defs.ResponseNgQueryExecuteFailed, errors.New("failed, multiple rows updated. "+strconv.Itoa(len(matched))))
+	}
+	for _, e := range matched {
+		e.Status = to
+		e.UpdateAt = s.now()
+	}
+	return int64(len(matched)), nil
+}
+
+func (s *memoryQueues) UpdateByUser(vendorId uint64, uid uint64, keyCodePrefix string, from []defs.QueueStatus, to defs.QueueStatus) (int64, error) {
+	return s.update(vendorId, to, func(e *memoryEntry) bool {
+		if e.Uid != uid || e.KeyCodePrefix != keyCodePrefix {
+			return false
+		}
+		for _, status := range from {
+			if e.Status == status {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (s *memoryQueues) Shelve(vendorId uint64, keyCodePrefix string, shelve bool) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.queue {
+		if e.KeyCodePrefix != keyCodePrefix {
+			continue
+		}
+		to, err := shelveStatus(e.Status, shelve, keyCodePrefix)
+		if err != nil {
+			return err
+		}
+		e.Status = to
+		e.UpdateAt = s.now()
+		return nil
+	}
+	return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+}
+
+func (s *memoryQueues) Move(vendorId uint64, keyCodePrefix string, index int, relative bool) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return 0, err
+	}
+	queueCode := ""
+	for _, e := range v.queue {
+		if e.KeyCodePrefix == keyCodePrefix && e.Status == defs.StatusEnqueue {
+			queueCode = e.QueueCode
+		}
+	}
+	if queueCode == "" {
+		return 0, fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+	}
+	waiting := []*memoryEntry{}
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode && e.Status == defs.StatusEnqueue {
+			waiting = append(waiting, e)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].Position < waiting[j].Position })
+	current := 0
+	ids := []uint64{}
+	positions := map[uint64]uint64{}
+	entries := map[uint64]*memoryEntry{}
+	for i, e := range waiting {
+		if e.KeyCodePrefix == keyCodePrefix {
+			current = i
+		}
+		ids = append(ids, e.Id)
+		positions[e.Id] = e.Position
+		entries[e.Id] = e
+	}
+	target, err := moveTarget(current, len(waiting), index, relative, keyCodePrefix)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range reorder(ids, current, target) {
+		if id != ids[i] {
+			entries[id].Position = positions[ids[i]]
+			entries[id].UpdateAt = s.now()
+		}
+	}
+	return target, nil
+}
+
+func (s *memoryQueues) CancelByVendor(vendorId uint64, keyCodePrefix string, reason defs.CancelReason, note string) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.queue {
+		if e.KeyCodePrefix != keyCodePrefix {
+			continue
+		}
+		if err = cancelable(e.Status, keyCodePrefix); err != nil {
+			return err
+		}
+		e.Status = defs.StatusVendorCancel
+		e.CancelReason = reason
+		e.CancelNote = note
+		e.UpdateAt = s.now()
+		return nil
+	}
+	return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+}
+
+func (s *memoryQueues) Admit(vendorId uint64, side AdmitSide, uid uint64, keyCodePrefix string, keyCodeSuffix string) (AdmitResult, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range v.queue {
+		if e.KeyCodePrefix != keyCodePrefix {
+			continue
+		}
+		summary := v.find(e.QueueCode)
+		if summary == nil {
+			break
+		}
+		if err = admittable(side, uid, keyCodeSuffix, e.Uid, e.KeyCodeSuffix, e.Status, keyCodePrefix); err != nil {
+			return 0, err
+		}
+		var result AdmitResult
+		result, e.VendorAuthAt, e.UserAuthAt = admitStep(summary, side, e.VendorAuthAt, e.UserAuthAt, s.now())
+		if result == AdmitDequeued {
+			e.Status = defs.StatusDequeue
+		}
+		e.UpdateAt = s.now()
+		return result, nil
+	}
+	return 0, fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+}
+
+// shelved entries are skipped
+func (s *memoryQueues) Dequeue(vendorId uint64, keyCodePrefix string) (int64, error) {
+	return s.update(vendorId, defs.StatusDequeue, func(e *memoryEntry) bool {
+		return e.KeyCodePrefix == keyCodePrefix && e.Status == defs.StatusEnqueue
+	})
+}
+
+func (s *memoryQueues) SetMail(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, mailAddr string) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.queue {
+		if e.QueueCode != queueCode || e.Uid != uid || e.KeyCodePrefix != keyCodePrefix {
+			continue
+		}
+		if err = mailable(e.Status, e.MailAddr, mailAddr, keyCodePrefix); err != nil {
+			return err
+		}
+		e.MailAddr = mailAddr
+		return nil
+	}
+	return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+}
+
+func (s *memoryQueues) MailTargets(vendorId uint64) ([]MailTarget, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	targets := []MailTarget{}
+	for _, e := range v.queue {
+		if e.MailAddr == "" || e.Status != defs.StatusEnqueue {
+			continue
+		}
+		targets = append(targets, MailTarget{
+			Id:            e.Id,
+			QueueCode:     e.QueueCode,
+			KeyCodePrefix: e.KeyCodePrefix,
+			MailAddr:      e.MailAddr,
+			MailCount:     e.MailCount,
+			Notice:        e.MailNotice,
+			VendorAuthAt:  e.VendorAuthAt,
+			Before:        v.waiting(e.QueueCode, e.Position),
+		})
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].QueueCode != targets[j].QueueCode {
+			return targets[i].QueueCode < targets[j].QueueCode
+		}
+		return targets[i].Before < targets[j].Before
+	})
+	return targets, nil
+}
+
+func (s *memoryQueues) MarkMail(vendorId uint64, id uint64, notice MailNotice, max int) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range v.queue {
+		if e.Id != id {
+			continue
+		}
+		if e.MailNotice&notice != 0 || int(e.MailCount) >= max {
+			return false, nil
+		}
+		e.MailNotice |= notice
+		e.MailCount++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryQueues) SetPush(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, pushType defs.PushType, subscription string) error {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.queue {
+		if e.QueueCode != queueCode || e.Uid != uid || e.KeyCodePrefix != keyCodePrefix {
+			continue
+		}
+		if err = pushable(e.Status, e.PushType, e.Subscription, pushType, subscription, keyCodePrefix); err != nil {
+			return err
+		}
+		e.PushType = pushType
+		e.Subscription = subscription
+		return nil
+	}
+	return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+}
+
+func (s *memoryQueues) PushTargets(vendorId uint64) ([]PushTarget, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	targets := []PushTarget{}
+	for _, e := range v.queue {
+		if e.PushType == defs.PushTypeNone || e.Status != defs.StatusEnqueue {
+			continue
+		}
+		targets = append(targets, PushTarget{
+			Id:            e.Id,
+			QueueCode:     e.QueueCode,
+			Uid:           e.Uid,
+			KeyCodePrefix: e.KeyCodePrefix,
+			PushType:      e.PushType,
+			Subscription:  e.Subscription,
+			PushCount:     e.PushCount,
+			Notice:        e.PushNotice,
+			Before:        v.waiting(e.QueueCode, e.Position),
+		})
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].QueueCode != targets[j].QueueCode {
+			return targets[i].QueueCode < targets[j].QueueCode
+		}
+		return targets[i].Before < targets[j].Before
+	})
+	return targets, nil
+}
+
+func (s *memoryQueues) MarkPush(vendorId uint64, id uint64, place uint16, max int) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range v.queue {
+		if e.Id != id {
+			continue
+		}
+		if (e.PushNotice != 0 && e.PushNotice <= place) || int(e.PushCount) >= max {
+			return false, nil
+		}
+		e.PushNotice = place
+		e.PushCount++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryQueues) CountByStatus(vendorId uint64, queueCode string) (map[defs.QueueStatus]int, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[defs.QueueStatus]int{}
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode {
+			counts[e.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *memoryQueues) Dequeued(vendorId uint64, queueCode string, since time.Time) ([]time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	times := []time.Time{}
+	for _, e := range v.queue {
+		if e.QueueCode == queueCode && e.Status == defs.StatusDequeue && !e.UpdateAt.Before(since) {
+			times = append(times, e.UpdateAt)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+func (s *memoryQueues) Verify(vendorId uint64, keyCodePrefix string, keyCodeSuffix string) (*Issued, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	prefixFound := false
+	for _, k := range v.keycodes {
+		if k.KeyCodePrefix != keyCodePrefix {
+			continue
+		}
+		prefixFound = true
+		if k.KeyCodeSuffix != keyCodeSuffix {
+			continue
+		}
+		queueCode := encode(k.QueueCode)
+		issued := &Issued{QueueCode: queueCode, ResetCount: k.ResetCount, IssueAt: k.CreateAt}
+		if summary := v.find(queueCode); summary != nil && summary.ResetCount == k.ResetCount {
+			issued.Current = true
+		}
+		for _, e := range v.queue {
+			if e.Id == k.QueueId && e.QueueCode == queueCode && e.KeyCodePrefix == k.KeyCodePrefix {
+				issued.Status = e.Status
+			}
+		}
+		return issued, nil
+	}
+	return nil, unverified(prefixFound, keyCodePrefix)
+}
+
+func (s *memoryQueues) List(vendorId uint64, queueCode string, statuses []defs.QueueStatus, limit int, offset int) ([]Row, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, err := s.vendor(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	rows := []Row{}
+	skipped := 0
+	ordered := make([]*memoryEntry, len(v.queue))
+	copy(ordered, v.queue)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Position < ordered[j].Position })
+	for _, e := range ordered {
+		if len(rows) >= limit {
+			break
+		}
+		if e.QueueCode != queueCode || !hasStatus(statuses, e.Status) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		rows = append(rows, Row{e.KeyCodePrefix, e.Status})
+	}
+	return rows, nil
+}
+
+func hasStatus(statuses []defs.QueueStatus, status defs.QueueStatus) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
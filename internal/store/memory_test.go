@@ -0,0 +1,573 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+	"vql/internal/cache"
+	"vql/internal/defs"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	UseMemory()
+	vendorId, err := Auths.Create(&Account{PrivateCode: []byte("private"), SessionId: []byte("session"), SessionPrivate: []byte("secret")})
+	assert.NoError(t, err)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: "x", Uid: vendorId})
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgShardConnectFailed), CodeOf(err, defs.ResponseOk))
+
+	assert.NoError(t, Vendors.Assign(vendorId, []byte("vendor")))
+	assert.NoError(t, Vendors.Provision(vendorId, "name", "caption", []byte("queue"), false, Capacity{}))
+	id, err := Vendors.IdByCode(base64.StdEncoding.EncodeToString([]byte("vendor")))
+	assert.NoError(t, err)
+	assert.Equal(t, vendorId, id)
+	queueCode := base64.StdEncoding.EncodeToString([]byte("queue"))
+
+	// already enqueued is checked per uid
+	first, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10, KeyCodeSuffix: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", first.KeyCodePrefix)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10})
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAlreadyEnqueue), CodeOf(err, defs.ResponseOk))
+	second, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 11, KeyCodeSuffix: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, second.Before)
+	assert.Equal(t, 2, second.Total)
+
+	updated, err := Queues.UpdateByUser(vendorId, 10, first.KeyCodePrefix, []defs.QueueStatus{defs.StatusEnqueue}, defs.StatusCancel)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), updated)
+	position, err := Queues.Position(vendorId, queueCode, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, position.Before)
+	// canceled entry is not dequeued
+	updated, err = Queues.Dequeue(vendorId, first.KeyCodePrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+
+	counts, err := Queues.CountByStatus(vendorId, queueCode)
+	assert.NoError(t, err)
+	assert.Equal(t, map[defs.QueueStatus]int{defs.StatusEnqueue: 1, defs.StatusCancel: 1}, counts)
+	rows, err := Queues.List(vendorId, queueCode, []defs.QueueStatus{defs.StatusEnqueue}, 20, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []Row{{second.KeyCodePrefix, defs.StatusEnqueue}}, rows)
+
+	// queue reset empties queue
+	assert.NoError(t, Vendors.Update(vendorId, "name2", "caption2", []byte("queue2"), true, CapacityChange{}))
+	summary, err := Vendors.Summary(vendorId)
+	assert.NoError(t, err)
+	assert.Equal(t, "name2", summary.Name)
+	assert.Equal(t, uint16(4), summary.ResetCount)
+	_, err = Queues.Position(vendorId, queueCode, 11)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgKeyCodeCodeNotfound), CodeOf(err, defs.ResponseOk))
+
+	session, err := Auths.FindSession(base64.StdEncoding.EncodeToString([]byte("session")))
+	assert.NoError(t, err)
+	assert.Equal(t, vendorId, session.Id)
+	assert.NoError(t, Vendors.Drop(vendorId))
+	_, err = Vendors.Summary(vendorId)
+	assert.Error(t, err)
+}
+
+func TestCached(t *testing.T) {
+	UseMemory()
+	lookup := cache.NewWith(100, time.Minute, nil)
+	UseCache(lookup)
+	vendorId, err := Auths.Create(&Account{PrivateCode: []byte("private"), SessionId: []byte("session"), SessionPrivate: []byte("secret")})
+	assert.NoError(t, err)
+	assert.NoError(t, Vendors.Assign(vendorId, []byte("vendor")))
+	assert.NoError(t, Vendors.Provision(vendorId, "name", "caption", []byte("queue"), false, Capacity{}))
+	vendorCode := base64.StdEncoding.EncodeToString([]byte("vendor"))
+	_, err = Vendors.IdByCode(vendorCode)
+	assert.NoError(t, err)
+	_, ok := lookup.Get(keyVendor + vendorCode)
+	assert.True(t, ok)
+
+	// update and re-upgrade invalidate summary and vendor code
+	_, err = Vendors.Summary(vendorId)
+	assert.NoError(t, err)
+	assert.NoError(t, Vendors.Update(vendorId, "name2", "caption2", nil, false, CapacityChange{}))
+	summary, err := Vendors.Summary(vendorId)
+	assert.NoError(t, err)
+	assert.Equal(t, "name2", summary.Name)
+	assert.NoError(t, Vendors.Assign(vendorId, []byte("vendor2")))
+	_, err = Vendors.IdByCode(vendorCode)
+	assert.Error(t, err)
+
+	// logon invalidates the previous session
+	sessionId := base64.StdEncoding.EncodeToString([]byte("session"))
+	_, err = Auths.FindSession(sessionId)
+	assert.NoError(t, err)
+	assert.NoError(t, Auths.TouchSession(sessionId))
+	_, err = Auths.RenewSession(base64.StdEncoding.EncodeToString([]byte("private")), []byte("session2"), []byte("secret2"))
+	assert.NoError(t, err)
+	_, err = Auths.FindSession(sessionId)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAuthNotFound), CodeOf(err, defs.ResponseOk))
+	session, err := Auths.FindSession(base64.StdEncoding.EncodeToString([]byte("session2")))
+	assert.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("secret2")), session.SessionPrivate)
+}
+
+func TestMemoryQueues(t *testing.T) {
+	UseMemory()
+	vendorId, err := Auths.Create(&Account{PrivateCode: []byte("private"), SessionId: []byte("session"), SessionPrivate: []byte("secret")})
+	assert.NoError(t, err)
+	assert.NoError(t, Vendors.Assign(vendorId, []byte("vendor")))
+	assert.NoError(t, Vendors.Provision(vendorId, "clinic", "caption", []byte("reception"), false, Capacity{}))
+	id, err := Vendors.AddQueue(vendorId, "pharmacy", "", []byte("pharmacy"), true, Capacity{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), id)
+	reception := base64.StdEncoding.EncodeToString([]byte("reception"))
+	pharmacy := base64.StdEncoding.EncodeToString([]byte("pharmacy"))
+
+	queues, err := Vendors.ListQueues(vendorId)
+	assert.NoError(t, err)
+	assert.Len(t, queues, 2)
+	assert.Equal(t, "clinic", queues[0].Name)
+	assert.Equal(t, "pharmacy", queues[1].Name)
+
+	// enqueue validates against the owning summary row
+	ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: pharmacy, Uid: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, "clinic", ticket.VendorName)
+	assert.Equal(t, "pharmacy", ticket.QueueName)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: reception, Uid: 10})
+	assert.NoError(t, err)
+
+	// primary reset keeps other queues
+	assert.NoError(t, Vendors.Update(vendorId, "clinic", "caption", []byte("reception2"), false, CapacityChange{}))
+	counts, err := Queues.CountByStatus(vendorId, pharmacy)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, counts[defs.StatusEnqueue])
+
+	assert.NoError(t, Vendors.UpdateQueue(vendorId, pharmacy, "drugs", "", false, CapacityChange{}))
+	summary, err := Vendors.QueueSummary(vendorId, pharmacy)
+	assert.NoError(t, err)
+	assert.Equal(t, "drugs", summary.Name)
+
+	// shelved entries are canceled with the waiting ones
+	shelved, err := Queues.Enqueue(vendorId, &Entry{QueueCode: pharmacy, Uid: 12})
+	assert.NoError(t, err)
+	assert.NoError(t, Queues.Shelve(vendorId, shelved.KeyCodePrefix, true))
+
+	_, err = Vendors.DeleteQueue(vendorId, base64.StdEncoding.EncodeToString([]byte("reception2")))
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgVendorCannotDeleteQueue), CodeOf(err, defs.ResponseOk))
+	canceled, err := Vendors.DeleteQueue(vendorId, pharmacy)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), canceled)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: pharmacy, Uid: 11})
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgQueueCodeNotfound), CodeOf(err, defs.ResponseOk))
+	for _, uid := range []uint64{10, 12} {
+		position, err := Queues.Position(vendorId, pharmacy, uid)
+		assert.NoError(t, err)
+		assert.Equal(t, defs.QueueStatus(defs.StatusVendorCancel), position.Status)
+		assert.Equal(t, defs.CancelReason(defs.CancelReasonQueueDeleted), position.CancelReason)
+	}
+}
+
+// fresh memory store with a provisioned vendor, returns vendor id and primary queue code
+func provisioned(t *testing.T, capacity Capacity) (uint64, string) {
+	UseMemory()
+	vendorId, err := Auths.Create(&Account{PrivateCode: []byte("private"), SessionId: []byte("session"), SessionPrivate: []byte("secret")})
+	assert.NoError(t, err)
+	assert.NoError(t, Vendors.Assign(vendorId, []byte("vendor")))
+	assert.NoError(t, Vendors.Provision(vendorId, "name", "caption", []byte("queue"), false, capacity))
+	return vendorId, base64.StdEncoding.EncodeToString([]byte("queue"))
+}
+
+func TestMemoryCapacity(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{MaxWaiting: 2})
+	now := time.Date(2020, 6, 20, 12, 0, 0, 0, time.UTC)
+	Queues.(*memoryQueues).now = func() time.Time { return now }
+	first, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10})
+	assert.NoError(t, err)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 11})
+	assert.NoError(t, err)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 12})
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserMaxover), CodeOf(err, defs.ResponseOk))
+
+	// a leaving entry frees its place
+	updated, err := Queues.UpdateByUser(vendorId, 10, first.KeyCodePrefix, []defs.QueueStatus{defs.StatusEnqueue}, defs.StatusCancel)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), updated)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 12})
+	assert.NoError(t, err)
+
+	// omitted capacity is kept
+	dailyCap, maxWaiting := uint32(3), uint32(0)
+	assert.NoError(t, Vendors.Update(vendorId, "name", "caption", nil, false, CapacityChange{DailyCap: &dailyCap}))
+	summary, err := Vendors.QueueSummary(vendorId, queueCode)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), summary.MaxWaiting)
+	assert.NoError(t, Vendors.UpdateQueue(vendorId, queueCode, "name", "caption", false, CapacityChange{MaxWaiting: &maxWaiting}))
+	summary, err = Vendors.QueueSummary(vendorId, queueCode)
+	assert.NoError(t, err)
+	assert.Equal(t, Capacity{DailyCap: 3}, Capacity{MaxWaiting: summary.MaxWaiting, DailyCap: summary.DailyCap, MaxPending: summary.MaxPending, AdmitWindow: summary.AdmitWindow})
+
+	// daily cap counts every ticket issued today, canceled ones too
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 13})
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserMaxover), CodeOf(err, defs.ResponseOk))
+	now = now.Add(12 * time.Hour)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 13})
+	assert.NoError(t, err)
+
+	// concurrent joins never exceed the limit
+	vendorId, queueCode = provisioned(t, Capacity{MaxWaiting: 5})
+	codes := make(chan defs.ResponseCode, 20)
+	var wg sync.WaitGroup
+	for uid := uint64(10); uid < 30; uid++ {
+		wg.Add(1)
+		go func(uid uint64) {
+			defer wg.Done()
+			_, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: uid})
+			codes <- CodeOf(err, defs.ResponseOk)
+		}(uid)
+	}
+	wg.Wait()
+	close(codes)
+	accepted := 0
+	for code := range codes {
+		if code == defs.ResponseOk {
+			accepted++
+		} else {
+			assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserMaxover), code)
+		}
+	}
+	assert.Equal(t, 5, accepted)
+	rows, err := Queues.List(vendorId, queueCode, []defs.QueueStatus{defs.StatusEnqueue}, 100, 0)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 5)
+}
+
+func TestMemoryMaintenance(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	now := time.Now()
+
+	until := now.Add(time.Hour).Unix()
+	assert.NoError(t, Vendors.SetMaintenance(vendorId, queueCode, Maintenance{Maintenance: true, Message: "back soon", Until: until}))
+	summary, err := Vendors.QueueSummary(vendorId, queueCode)
+	assert.NoError(t, err)
+	assert.Equal(t, "back soon", summary.MaintenanceMessage)
+	assert.True(t, InMaintenance(summary, now))
+	assert.False(t, InMaintenance(summary, time.Unix(until, 0)))
+
+	assert.NoError(t, Vendors.SetMaintenance(vendorId, queueCode, Maintenance{}))
+	summary, err = Vendors.QueueSummary(vendorId, queueCode)
+	assert.NoError(t, err)
+	assert.False(t, InMaintenance(summary, now))
+	assert.Error(t, Vendors.SetMaintenance(vendorId, "none", Maintenance{Maintenance: true}))
+}
+
+func TestMemoryPending(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	tickets := []*Ticket{}
+	for uid := uint64(10); uid < 14; uid++ {
+		ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: uid})
+		assert.NoError(t, err)
+		tickets = append(tickets, ticket)
+	}
+
+	// disabled by default
+	_, err := Queues.Pending(vendorId, queueCode, 10, tickets[0].KeyCodePrefix, 1)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserCannotPending), CodeOf(err, defs.ResponseOk))
+
+	maxPending := uint16(2)
+	assert.NoError(t, Vendors.Update(vendorId, "name", "caption", nil, false, CapacityChange{MaxPending: &maxPending}))
+	position, err := Queues.Pending(vendorId, queueCode, 10, tickets[0].KeyCodePrefix, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, position.Before)
+	rows, err := Queues.List(vendorId, queueCode, nil, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{tickets[1].KeyCodePrefix, tickets[2].KeyCodePrefix, tickets[0].KeyCodePrefix, tickets[3].KeyCodePrefix},
+		[]string{rows[0].KeyCodePrefix, rows[1].KeyCodePrefix, rows[2].KeyCodePrefix, rows[3].KeyCodePrefix})
+
+	// to the end, then no more
+	position, err = Queues.Pending(vendorId, queueCode, 10, tickets[0].KeyCodePrefix, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, position.Before)
+	_, err = Queues.Pending(vendorId, queueCode, 10, tickets[0].KeyCodePrefix, 0)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserCannotPending), CodeOf(err, defs.ResponseOk))
+
+	// later joiners stay behind
+	ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 14})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, ticket.Before)
+}
+
+func TestMemoryShelve(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	first, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10})
+	assert.NoError(t, err)
+	_, err = Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 11})
+	assert.NoError(t, err)
+
+	assert.NoError(t, Queues.Shelve(vendorId, first.KeyCodePrefix, true))
+	err = Queues.Shelve(vendorId, first.KeyCodePrefix, true)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgVendorAlreadyShelved), CodeOf(err, defs.ResponseOk))
+	position, err := Queues.Position(vendorId, queueCode, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, position.Before)
+	assert.Equal(t, 1, position.Total)
+	updated, err := Queues.Dequeue(vendorId, first.KeyCodePrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+
+	// back to its place
+	assert.NoError(t, Queues.Shelve(vendorId, first.KeyCodePrefix, false))
+	err = Queues.Shelve(vendorId, first.KeyCodePrefix, false)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgVendorAlreadyUnshelved), CodeOf(err, defs.ResponseOk))
+	position, err = Queues.Position(vendorId, queueCode, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, position.Before)
+
+	// user cancels while shelved
+	assert.NoError(t, Queues.Shelve(vendorId, first.KeyCodePrefix, true))
+	updated, err = Queues.UpdateByUser(vendorId, 10, first.KeyCodePrefix, []defs.QueueStatus{defs.StatusEnqueue, defs.StatusShelved}, defs.StatusCancel)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), updated)
+	position, err = Queues.Position(vendorId, queueCode, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, defs.QueueStatus(defs.StatusCancel), position.Status)
+}
+
+func TestMemoryMove(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	prefixes := []string{}
+	for uid := uint64(10); uid < 14; uid++ {
+		ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: uid})
+		assert.NoError(t, err)
+		prefixes = append(prefixes, ticket.KeyCodePrefix)
+	}
+	order := func() []string {
+		rows, err := Queues.List(vendorId, queueCode, []defs.QueueStatus{defs.StatusEnqueue}, 10, 0)
+		assert.NoError(t, err)
+		result := []string{}
+		for _, row := range rows {
+			result = append(result, row.KeyCodePrefix)
+		}
+		return result
+	}
+
+	before, err := Queues.Move(vendorId, prefixes[3], 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, before)
+	assert.Equal(t, []string{prefixes[3], prefixes[0], prefixes[1], prefixes[2]}, order())
+	_, err = Queues.Move(vendorId, prefixes[3], -1, true)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgVendorConnotMoveup), CodeOf(err, defs.ResponseOk))
+
+	before, err = Queues.Move(vendorId, prefixes[3], 1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, before)
+	before, err = Queues.Move(vendorId, prefixes[0], 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, before)
+	assert.Equal(t, []string{prefixes[3], prefixes[1], prefixes[2], prefixes[0]}, order())
+	position, err := Queues.Position(vendorId, queueCode, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, position.Before)
+}
+
+func TestMemoryCancelByVendor(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10})
+	assert.NoError(t, err)
+
+	assert.NoError(t, Queues.CancelByVendor(vendorId, ticket.KeyCodePrefix, defs.CancelReasonNoShow, "called twice"))
+	position, err := Queues.Position(vendorId, queueCode, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, defs.QueueStatus(defs.StatusVendorCancel), position.Status)
+	assert.Equal(t, defs.CancelReason(defs.CancelReasonNoShow), position.CancelReason)
+	assert.Equal(t, "called twice", position.CancelNote)
+
+	err = Queues.CancelByVendor(vendorId, ticket.KeyCodePrefix, defs.CancelReasonOther, "")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgVendorAlreadyCanceled), CodeOf(err, defs.ResponseOk))
+	updated, err := Queues.UpdateByUser(vendorId, 10, ticket.KeyCodePrefix, []defs.QueueStatus{defs.StatusEnqueue}, defs.StatusCancel)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+
+	// force dequeue leaves the canceled ticket alone
+	updated, err = Queues.Dequeue(vendorId, ticket.KeyCodePrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+	position, err = Queues.Position(vendorId, queueCode, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, defs.QueueStatus(defs.StatusVendorCancel), position.Status)
+}
+
+func TestMemoryAdmit(t *testing.T) {
+	vendorId, _ := provisioned(t, Capacity{AdmitWindow: 60})
+	now := time.Now()
+	Queues.(*memoryQueues).now = func() time.Time { return now }
+	assert.NoError(t, Vendors.Update(vendorId, "name", "caption", []byte("admit"), true, CapacityChange{}))
+	queueCode := base64.StdEncoding.EncodeToString([]byte("admit"))
+	first, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10, KeyCodeSuffix: "suffix"})
+	assert.NoError(t, err)
+	second, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 11, KeyCodeSuffix: "suffix"})
+	assert.NoError(t, err)
+
+	// vendor calls, user confirms in window
+	result, err := Queues.Admit(vendorId, AdmitVendor, 0, first.KeyCodePrefix, "suffix")
+	assert.NoError(t, err)
+	assert.Equal(t, AdmitWaiting, result)
+	_, err = Queues.Admit(vendorId, AdmitUser, 11, first.KeyCodePrefix, "")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgKeyCodeCodeNotfound), CodeOf(err, defs.ResponseOk))
+	result, err = Queues.Admit(vendorId, AdmitUser, 10, first.KeyCodePrefix, "")
+	assert.NoError(t, err)
+	assert.Equal(t, AdmitDequeued, result)
+
+	// user confirms, vendor too late
+	result, err = Queues.Admit(vendorId, AdmitUser, 11, second.KeyCodePrefix, "")
+	assert.NoError(t, err)
+	assert.Equal(t, AdmitWaiting, result)
+	now = now.Add(61 * time.Second)
+	_, err = Queues.Admit(vendorId, AdmitVendor, 0, second.KeyCodePrefix, "wrong")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgSuffixCodeCodeNotfound), CodeOf(err, defs.ResponseOk))
+	result, err = Queues.Admit(vendorId, AdmitVendor, 0, second.KeyCodePrefix, "suffix")
+	assert.NoError(t, err)
+	assert.Equal(t, AdmitExpired, result)
+	position, err := Queues.Position(vendorId, queueCode, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, defs.QueueStatus(defs.StatusEnqueue), position.Status)
+	assert.Equal(t, now.Unix(), position.VendorAuthAt)
+	result, err = Queues.Admit(vendorId, AdmitUser, 11, second.KeyCodePrefix, "")
+	assert.NoError(t, err)
+	assert.Equal(t, AdmitDequeued, result)
+}
+
+func TestMemoryMail(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	first, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10})
+	assert.NoError(t, err)
+	second, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 11})
+	assert.NoError(t, err)
+
+	err = Queues.SetMail(vendorId, queueCode, 11, second.KeyCodePrefix, "")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAlreadyMailOff), CodeOf(err, defs.ResponseOk))
+	assert.NoError(t, Queues.SetMail(vendorId, queueCode, 11, second.KeyCodePrefix, "user@example.com"))
+	err = Queues.SetMail(vendorId, queueCode, 11, second.KeyCodePrefix, "user@example.com")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAlreadyMailOn), CodeOf(err, defs.ResponseOk))
+	err = Queues.SetMail(vendorId, queueCode, 10, second.KeyCodePrefix, "user@example.com")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgKeyCodeCodeNotfound), CodeOf(err, defs.ResponseOk))
+
+	targets, err := Queues.MailTargets(vendorId)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, second.KeyCodePrefix, targets[0].KeyCodePrefix)
+	assert.Equal(t, 1, targets[0].Before)
+
+	// each notice once, capped per ticket
+	ok, err := Queues.MarkMail(vendorId, targets[0].Id, MailNear, 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = Queues.MarkMail(vendorId, targets[0].Id, MailNear, 2)
+	assert.False(t, ok)
+	ok, _ = Queues.MarkMail(vendorId, targets[0].Id, MailCalled, 1)
+	assert.False(t, ok)
+	ok, _ = Queues.MarkMail(vendorId, targets[0].Id, MailCalled, 2)
+	assert.True(t, ok)
+	targets, _ = Queues.MailTargets(vendorId)
+	assert.Equal(t, MailNear|MailCalled, targets[0].Notice)
+	assert.Equal(t, uint16(2), targets[0].MailCount)
+
+	_, err = Queues.Dequeue(vendorId, first.KeyCodePrefix)
+	assert.NoError(t, err)
+	targets, _ = Queues.MailTargets(vendorId)
+	assert.Equal(t, 0, targets[0].Before)
+	assert.NoError(t, Queues.SetMail(vendorId, queueCode, 11, second.KeyCodePrefix, ""))
+	targets, _ = Queues.MailTargets(vendorId)
+	assert.Len(t, targets, 0)
+}
+
+func TestMemoryPush(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10})
+	assert.NoError(t, err)
+
+	err = Queues.SetPush(vendorId, queueCode, 10, ticket.KeyCodePrefix, defs.PushTypeNone, "")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAlreadyPushOff), CodeOf(err, defs.ResponseOk))
+	assert.NoError(t, Queues.SetPush(vendorId, queueCode, 10, ticket.KeyCodePrefix, defs.PushTypeGateway, `{"Token":"a"}`))
+	err = Queues.SetPush(vendorId, queueCode, 10, ticket.KeyCodePrefix, defs.PushTypeGateway, `{"Token":"a"}`)
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgUserAlreadyPushOn), CodeOf(err, defs.ResponseOk))
+	assert.NoError(t, Queues.SetPush(vendorId, queueCode, 10, ticket.KeyCodePrefix, defs.PushTypeGateway, `{"Token":"b"}`))
+
+	targets, err := Queues.PushTargets(vendorId)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, `{"Token":"b"}`, targets[0].Subscription)
+
+	// only nearer places after the first, capped per ticket
+	ok, err := Queues.MarkPush(vendorId, targets[0].Id, 5, 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = Queues.MarkPush(vendorId, targets[0].Id, 5, 2)
+	assert.False(t, ok)
+	ok, _ = Queues.MarkPush(vendorId, targets[0].Id, 3, 2)
+	assert.True(t, ok)
+	ok, _ = Queues.MarkPush(vendorId, targets[0].Id, 1, 2)
+	assert.False(t, ok)
+	targets, _ = Queues.PushTargets(vendorId)
+	assert.Equal(t, uint16(3), targets[0].Notice)
+	assert.Equal(t, uint16(2), targets[0].PushCount)
+}
+
+func TestMemoryVerify(t *testing.T) {
+	vendorId, queueCode := provisioned(t, Capacity{})
+	ticket, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 10, KeyCodeSuffix: "suffix"})
+	assert.NoError(t, err)
+
+	issued, err := Queues.Verify(vendorId, ticket.KeyCodePrefix, "suffix")
+	assert.NoError(t, err)
+	assert.Equal(t, queueCode, issued.QueueCode)
+	assert.True(t, issued.Current)
+	assert.Equal(t, defs.QueueStatus(defs.StatusEnqueue), issued.Status)
+	_, err = Queues.Verify(vendorId, ticket.KeyCodePrefix, "wrong")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgSuffixCodeCodeNotfound), CodeOf(err, defs.ResponseOk))
+	_, err = Queues.Verify(vendorId, "999", "suffix")
+	assert.Equal(t, defs.ResponseCode(defs.ResponseNgKeyCodeCodeNotfound), CodeOf(err, defs.ResponseOk))
+
+	// still issued after reset, of the previous generation
+	assert.NoError(t, Vendors.Update(vendorId, "name", "caption", []byte("queue"), false, CapacityChange{}))
+	issued, err = Queues.Verify(vendorId, ticket.KeyCodePrefix, "suffix")
+	assert.NoError(t, err)
+	assert.False(t, issued.Current)
+	assert.Equal(t, defs.QueueStatus(0), issued.Status)
+
+	// queue ids reused after reset, e.g. auto increment rolled back on restart
+	v, err := Queues.(*memoryQueues).vendor(vendorId)
+	assert.NoError(t, err)
+	v.lastId = 0
+	reused, err := Queues.Enqueue(vendorId, &Entry{QueueCode: queueCode, Uid: 11, KeyCodeSuffix: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, ticket.Id, reused.Id)
+	issued, err = Queues.Verify(vendorId, ticket.KeyCodePrefix, "suffix")
+	assert.NoError(t, err)
+	assert.Equal(t, defs.QueueStatus(0), issued.Status)
+	issued, err = Queues.Verify(vendorId, reused.KeyCodePrefix, "other")
+	assert.NoError(t, err)
+	assert.True(t, issued.Current)
+	assert.Equal(t, defs.QueueStatus(defs.StatusEnqueue), issued.Status)
+}
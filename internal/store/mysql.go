@@ -0,0 +1,576 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"vql/internal/db"
+	"vql/internal/defs"
+)
+
+// Use MySQL stores, opConn is used for privileged operations
+func UseMySQL(conn *db.Conn, opConn *db.Conn) {
+	Auths = &mysqlAuths{conn}
+	Subscriptions = &mysqlSubscriptions{conn}
+	Vendors = &mysqlVendors{conn, opConn}
+	Queues = &mysqlQueues{conn}
+}
+
+// run fn in transaction, begin and commit failures are coded here, query failures by fn
+func transact(x *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := x.Beginx()
+	if err != nil {
+		return fail(defs.ResponseNgTransactBeginFailed, err)
+	}
+	if err = fn(tx); err != nil {
+		return fail(defs.ResponseNgQueryExecuteFailed, db.RollbackResolve(err, tx))
+	}
+	if err = tx.Commit(); err != nil {
+		return fail(defs.ResponseNgCommitFailed, db.RollbackResolve(err, tx))
+	}
+	return nil
+}
+
+func vendorShard(conn *db.Conn, vendorId uint64) (*sqlx.DB, error) {
+	shard, _, err := conn.VendorShard(vendorId)
+	if err != nil {
+		return nil, fail(defs.ResponseNgShardConnectFailed, err)
+	}
+	return shard, nil
+}
+
+type mysqlAuths struct {
+	conn *db.Conn
+}
+
+func (s *mysqlAuths) Create(a *Account) (uint64, error) {
+	var vendorId uint64
+	err := transact(s.conn.Master(), func(tx *sqlx.Tx) error {
+		result, err := db.TxPreparexExec(tx, `insert into domain (
+		service_code, vendor_code, shard, delete_flag, create_at, update_at
+	) values (
+		?, '', ?, 0, utc_timestamp(), utc_timestamp()
+	)`, defs.ServiceCode, db.ShardUnassigned)
+		if err != nil {
+			return err
+		}
+		signedId, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		vendorId = uint64(signedId)
+
+		if _, err = db.TxPreparexExec(tx, `insert into auth (
+		id, identifier_type, platform_type, identifier, seed, secret,
+		ticks, private_code, account_type, agreement_ver, agreement_time, activate,
+		activate_type, activate_keyword, activate_time, session_id, session_private, session_footprint,
+		delete_flag, create_at, update_at
+	) values (
+		?, ?, ?, ?, ?, ?,
+		?, ?, ?, ?, utc_timestamp(), ?,
+		?, ?, utc_timestamp(), ?, ?, utc_timestamp(),
+		0, utc_timestamp(), utc_timestamp()
+	)`, vendorId, a.IdentifierType, a.PlatformType, a.Identifier, a.Seed, "", a.Ticks, a.PrivateCode, defs.NormalUser,
+			a.AgreementVersion, a.Agreed, a.ActivateType, a.ActivateKeyword, a.SessionId, a.SessionPrivate); err != nil {
+			return err
+		}
+
+		_, err = db.TxPreparexExec(tx, `insert into subscription (
+		id, subscription_type, subscription_expire, delete_flag, create_at, update_at
+	) values (
+		?, ?, utc_timestamp(), 0, utc_timestamp(), utc_timestamp()
+	)`, vendorId, defs.FreePlan)
+		return err
+	})
+	return vendorId, err
+}
+
+func (s *mysqlAuths) Get(id uint64) (*db.Auth, error) {
+	auths := []db.Auth{}
+	if err := db.PreparexSelect(s.conn.Master(), "select * from auth where id = ?", &auths, id); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	if len(auths) == 0 {
+		return nil, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, account not found."))
+	}
+	return &auths[0], nil
+}
+
+func (s *mysqlAuths) RenewSession(privateCode string, sessionId []byte, sessionPrivate []byte) (uint64, error) {
+	var id uint64
+	err := transact(s.conn.Master(), func(tx *sqlx.Tx) error {
+		ids := []uint64{}
+		if err := db.TxPreparexSelect(tx, "select id from auth where to_base64(private_code) = ? ", &ids, privateCode); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, private code not found. "+privateCode))
+		} else if len(ids) > 1 {
+			return fail(defs.ResponseNgUserAuthFailed, errors.New("failed, invalid private code. "+privateCode))
+		}
+		id = ids[0]
+		_, err := db.TxPreparexExec(tx, `update auth set session_id = ?, session_private = ?, session_footprint = utc_timestamp(), update_at = utc_timestamp()
+	where to_base64(private_code) = ?`, sessionId, sessionPrivate, privateCode)
+		return err
+	})
+	return id, err
+}
+
+func (s *mysqlAuths) Recover(identifier string, seed string, privateCode []byte, sessionId []byte, sessionPrivate []byte) (uint64, []byte, error) {
+	var id uint64
+	var previous []byte
+	err := transact(s.conn.Master(), func(tx *sqlx.Tx) error {
+		auths := []db.Auth{}
+		if err := db.TxPreparexSelect(tx, "select * from auth where identifier = ? and seed = ? for update", &auths, identifier, seed); err != nil {
+			return err
+		}
+		if len(auths) == 0 {
+			return fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, seed not found. "+identifier))
+		}
+		id = auths[0].Id
+		previous = auths[0].PrivateCode
+		_, err := db.TxPreparexExec(tx, `update auth set private_code = ?, session_id = ?, session_private = ?, session_footprint = utc_timestamp(), update_at = utc_timestamp()
+	where id = ?`, privateCode, sessionId, sessionPrivate, id)
+		return err
+	})
+	return id, previous, err
+}
+
+func (s *mysqlAuths) FindSession(sessionId string) (*Session, error) {
+	results := []Session{}
+	if err := db.PreparexSelect(s.conn.Master(), `select id, to_base64(session_private) as session_private from auth where to_base64(session_id) = ? and date_add(session_footprint, interval `+
+		defs.SessionTimeout+` minute) > utc_timestamp()`, &results, sessionId); err != nil {
+		return nil, fail(defs.ResponseNgUserAuthNotFound, err)
+	}
+	return oneSession(results, sessionId)
+}
+
+func oneSession(results []Session, sessionId string) (*Session, error) {
+	if len(results) == 0 {
+		return nil, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, session not found. "+sessionId))
+	} else if len(results) > 1 {
+		return nil, fail(defs.ResponseNgUserAuthFailed, errors.New("failed, invalid session. "+sessionId))
+	}
+	return &results[0], nil
+}
+
+func (s *mysqlAuths) TouchSession(sessionId string) error {
+	if _, err := db.PreparexExec(s.conn.Master(), "update auth set session_footprint = utc_timestamp() where to_base64(session_id) = ?", sessionId); err != nil {
+		return fail(defs.ResponseNgUserAuthFailed, err)
+	}
+	return nil
+}
+
+func (s *mysqlAuths) SetAccountType(id uint64, accountType defs.AccountType) error {
+	if _, err := db.PreparexExec(s.conn.Master(), "update auth set account_type = ?, update_at = utc_timestamp() where id = ?", accountType, id); err != nil {
+		return fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return nil
+}
+
+func (s *mysqlAuths) LinkIdentity(id uint64, issuer string, subject string) error {
+	return transact(s.conn.Master(), func(tx *sqlx.Tx) error {
+		ids := []uint64{}
+		if err := db.TxPreparexSelect(tx, "select id from sso where issuer = ? and subject = ? for update", &ids, issuer, subject); err != nil {
+			return err
+		}
+		if len(ids) > 0 && ids[0] != id {
+			return fail(defs.ResponseNgVendorAuthFailed, errors.New("failed, identity linked to another account. "+subject))
+		}
+		if _, err := db.TxPreparexExec(tx, "delete from sso where id = ?", id); err != nil {
+			return err
+		}
+		_, err := db.TxPreparexExec(tx, `insert into sso (
+		issuer, subject, id, create_at, update_at
+	) values (
+		?, ?, ?, utc_timestamp(), utc_timestamp()
+	)`, issuer, subject, id)
+		return err
+	})
+}
+
+func (s *mysqlAuths) FindIdentity(issuer string, subject string) (uint64, error) {
+	ids := []uint64{}
+	if err := db.PreparexSelect(s.conn.Master(), "select id from sso where issuer = ? and subject = ?", &ids, issuer, subject); err != nil {
+		return 0, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	if len(ids) == 0 {
+		return 0, fail(defs.ResponseNgUserAuthNotFound, errors.New("failed, identity not linked. "+subject))
+	}
+	return ids[0], nil
+}
+
+type mysqlSubscriptions struct {
+	conn *db.Conn
+}
+
+func (s *mysqlSubscriptions) Get(id uint64) (*db.Subscription, error) {
+	subscription := db.Subscription{}
+	if err := db.PreparexGet(s.conn.Master(), "select * from subscription where id = ?", &subscription, id); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return &subscription, nil
+}
+
+func (s *mysqlSubscriptions) Update(id uint64, plan defs.SubscriptionType, expire time.Time) error {
+	if _, err := db.PreparexExec(s.conn.Master(), "update subscription set subscription_type = ?, subscription_expire = ?, update_at = utc_timestamp() where id = ?",
+		plan, expire.UTC(), id); err != nil {
+		return fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return nil
+}
+
+type mysqlVendors struct {
+	conn   *db.Conn
+	opConn *db.Conn
+}
+
+func (s *mysqlVendors) IdByCode(vendorCode string) (uint64, error) {
+	var vendorId uint64
+	if err := db.PreparexGet(s.conn.Master(), "select id from domain where to_base64(vendor_code) = ?", &vendorId, vendorCode); err != nil {
+		return 0, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return vendorId, nil
+}
+
+func (s *mysqlVendors) Domain(vendorId uint64) (*db.Domain, error) {
+	domain := db.Domain{}
+	if err := db.PreparexGet(s.conn.Master(), "select * from domain where id = ?", &domain, vendorId); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return &domain, nil
+}
+
+func (s *mysqlVendors) Assign(vendorId uint64, vendorCode []byte) error {
+	return transact(s.conn.Master(), func(tx *sqlx.Tx) error {
+		if _, err := db.TxPreparexExec(tx, "update domain set vendor_code = ?, update_at = utc_timestamp() where id = ?", vendorCode, vendorId); err != nil {
+			return err
+		}
+		// shard = -1 -> shard = placed shard num, kept as is on re-upgrade
+		_, err := db.AssignShard(tx, vendorId)
+		return err
+	})
+}
+
+func (s *mysqlVendors) Provision(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	return transact(shard, func(tx *sqlx.Tx) error {
+		for _, query := range []string{db.CreateSummaryQuery(vendorId), db.CreateQueueQuery(vendorId), db.CreateKeyCodeQuery(vendorId)} {
+			if _, err := db.TxPreparexExec(tx, query); err != nil {
+				return err
+			}
+		}
+		if _, err := db.TxPreparexExec(tx, `insert into summary_`+db.ToSuffix(vendorId)+` (
+		id, queue_code, reset_count, name, caption, require_admit, max_waiting, daily_cap, max_pending, admit_window, schedule, maintenance, mail_template, delete_flag, create_at, update_at
+	) values (
+		?, '', 0, ?, ?, 0, ?, ?, ?, ?, '', 0, '', 0, utc_timestamp(), utc_timestamp()
+	)`, PrimaryQueueId, name, caption, capacity.MaxWaiting, capacity.DailyCap, capacity.MaxPending, capacity.AdmitWindow); err != nil {
+			return err
+		}
+		if _, err := db.TxPreparexExec(tx, db.CreateSequenceQuery(vendorId)); err != nil {
+			return err
+		}
+		if _, err := db.TxPreparexExec(tx, db.NewSequenceQuery(vendorId), "NUM", 0, 1); err != nil {
+			return err
+		}
+		for _, query := range []string{db.CreateFuncCurrSeqQuery(vendorId), db.CreateFuncNextSeqQuery(vendorId), db.CreateFuncUpdateSeqQuery(vendorId)} {
+			rows, err := tx.Query(query)
+			if err != nil {
+				return fail(defs.ResponseNgPreparedStatementFailed, err)
+			}
+			rows.Close()
+		}
+		return resetQueue(tx, vendorId, queueCode, requireAdmit)
+	})
+}
+
+// backup and empty primary queue, then set new queue code. other queues are kept
+func resetQueue(tx *sqlx.Tx, vendorId uint64, queueCode []byte, requireAdmit bool) error {
+	suffix := db.ToSuffix(vendorId)
+	db.TxPreparexExec(tx, `drop table queue_backup_`+suffix)
+	if _, err := db.TxPreparexExec(tx, `create table queue_backup_`+suffix+` like queue_`+suffix); err != nil {
+		return err
+	}
+	primary := `(select queue_code from summary_` + suffix + ` where id = ?)`
+	if _, err := db.TxPreparexExec(tx, `insert into queue_backup_`+suffix+` select * from queue_`+suffix+` where queue_code = `+primary, PrimaryQueueId); err != nil {
+		return err
+	}
+	if _, err := db.TxPreparexExec(tx, `delete from queue_`+suffix+` where queue_code = `+primary, PrimaryQueueId); err != nil {
+		return err
+	}
+	_, err := db.TxPreparexExec(tx, `update summary_`+suffix+`
+	set queue_code = ?, reset_count = cast(nextseq_`+suffix+`("NUM") as char), require_admit = ?, update_at = utc_timestamp()
+	where id = ?`, queueCode, requireAdmit, PrimaryQueueId)
+	return err
+}
+
+func (s *mysqlVendors) Summary(vendorId uint64) (*db.Summary, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summary := db.Summary{}
+	if err = db.PreparexGet(shard, "select * from summary_"+db.ToSuffix(vendorId)+" where id = ?", &summary, PrimaryQueueId); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return &summary, nil
+}
+
+func (s *mysqlVendors) Update(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity CapacityChange) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	set, args := capacitySet(capacity)
+	return transact(shard, func(tx *sqlx.Tx) error {
+		if _, err := db.TxPreparexExec(tx, `update summary_`+db.ToSuffix(vendorId)+`
+	set name = ?, caption = ?, `+set+`update_at = utc_timestamp()
+	where id = ?`, append(append([]interface{}{name, caption}, args...), PrimaryQueueId)...); err != nil {
+			return err
+		}
+		if queueCode == nil {
+			return nil
+		}
+		return resetQueue(tx, vendorId, queueCode, requireAdmit)
+	})
+}
+
+func (s *mysqlVendors) ListQueues(vendorId uint64) ([]db.Summary, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []db.Summary{}
+	if err = db.PreparexSelect(shard, "select * from summary_"+db.ToSuffix(vendorId)+" where delete_flag = 0 order by id", &summaries); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return summaries, nil
+}
+
+func (s *mysqlVendors) QueueSummary(vendorId uint64, queueCode string) (*db.Summary, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []db.Summary{}
+	if err = db.PreparexSelect(shard, "select * from summary_"+db.ToSuffix(vendorId)+" where to_base64(queue_code) = ? and delete_flag = 0",
+		&summaries, queueCode); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	if len(summaries) == 0 {
+		return nil, fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	return &summaries[0], nil
+}
+
+func (s *mysqlVendors) AddQueue(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) (uint64, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return 0, err
+	}
+	var id uint64
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		if err := db.TxPreparexGet(tx, "select max(id) + 1 from summary_"+db.ToSuffix(vendorId)+" for update", &id); err != nil {
+			return err
+		}
+		_, err := db.TxPreparexExec(tx, `insert into summary_`+db.ToSuffix(vendorId)+` (
+		id, queue_code, reset_count, name, caption, require_admit, max_waiting, daily_cap, max_pending, admit_window, schedule, maintenance, mail_template, delete_flag, create_at, update_at
+	) values (
+		?, ?, 0, ?, ?, ?, ?, ?, ?, ?, '', 0, '', 0, utc_timestamp(), utc_timestamp()
+	)`, id, queueCode, name, caption, requireAdmit, capacity.MaxWaiting, capacity.DailyCap, capacity.MaxPending, capacity.AdmitWindow)
+		return err
+	})
+	return id, err
+}
+
+// live summary id owning the base64 queue code, locked until the transaction ends
+func lockQueue(tx *sqlx.Tx, vendorId uint64, queueCode string) (uint64, error) {
+	ids := []uint64{}
+	if err := db.TxPreparexSelect(tx, "select id from summary_"+db.ToSuffix(vendorId)+" where to_base64(queue_code) = ? and delete_flag = 0 for update",
+		&ids, queueCode); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+	}
+	return ids[0], nil
+}
+
+func (s *mysqlVendors) UpdateQueue(vendorId uint64, queueCode string, name string, caption string, requireAdmit bool, capacity CapacityChange) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	set, args := capacitySet(capacity)
+	return transact(shard, func(tx *sqlx.Tx) error {
+		id, err := lockQueue(tx, vendorId, queueCode)
+		if err != nil {
+			return err
+		}
+		_, err = db.TxPreparexExec(tx, `update summary_`+db.ToSuffix(vendorId)+`
+	set name = ?, caption = ?, require_admit = ?, `+set+`update_at = utc_timestamp()
+	where id = ?`, append(append([]interface{}{name, caption, requireAdmit}, args...), id)...)
+		return err
+	})
+}
+
+// set clauses of the changed capacity columns, each followed by a comma
+func capacitySet(change CapacityChange) (string, []interface{}) {
+	set, args := "", []interface{}{}
+	if change.MaxWaiting != nil {
+		set, args = set+"max_waiting = ?, ", append(args, *change.MaxWaiting)
+	}
+	if change.DailyCap != nil {
+		set, args = set+"daily_cap = ?, ", append(args, *change.DailyCap)
+	}
+	if change.MaxPending != nil {
+		set, args = set+"max_pending = ?, ", append(args, *change.MaxPending)
+	}
+	if change.AdmitWindow != nil {
+		set, args = set+"admit_window = ?, ", append(args, *change.AdmitWindow)
+	}
+	return set, args
+}
+
+func (s *mysqlVendors) SetSchedule(vendorId uint64, queueCode string, schedule string) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	return transact(shard, func(tx *sqlx.Tx) error {
+		id, err := lockQueue(tx, vendorId, queueCode)
+		if err != nil {
+			return err
+		}
+		_, err = db.TxPreparexExec(tx, `update summary_`+db.ToSuffix(vendorId)+`
+	set schedule = ?, update_at = utc_timestamp()
+	where id = ?`, schedule, id)
+		return err
+	})
+}
+
+func (s *mysqlVendors) SetMaintenance(vendorId uint64, queueCode string, maintenance Maintenance) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	return transact(shard, func(tx *sqlx.Tx) error {
+		id, err := lockQueue(tx, vendorId, queueCode)
+		if err != nil {
+			return err
+		}
+		_, err = db.TxPreparexExec(tx, `update summary_`+db.ToSuffix(vendorId)+`
+	set maintenance = ?, maintenance_message = ?, maintenance_until = ?, update_at = utc_timestamp()
+	where id = ?`, maintenance.Maintenance, maintenance.Message, maintenance.Until, id)
+		return err
+	})
+}
+
+func (s *mysqlVendors) SetMailTemplate(vendorId uint64, template string) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	if _, err = db.PreparexExec(shard, `update summary_`+db.ToSuffix(vendorId)+`
+	set mail_template = ?, update_at = utc_timestamp()
+	where id = ?`, template, PrimaryQueueId); err != nil {
+		return fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return nil
+}
+
+func (s *mysqlVendors) SetPushThresholds(vendorId uint64, thresholds string) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	if _, err = db.PreparexExec(shard, `update summary_`+db.ToSuffix(vendorId)+`
+	set push_thresholds = ?, update_at = utc_timestamp()
+	where id = ?`, thresholds, PrimaryQueueId); err != nil {
+		return fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return nil
+}
+
+func (s *mysqlVendors) DeleteQueue(vendorId uint64, queueCode string) (int64, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return 0, err
+	}
+	var canceled int64
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		id, err := lockQueue(tx, vendorId, queueCode)
+		if err != nil {
+			return err
+		}
+		if id == PrimaryQueueId {
+			return fail(defs.ResponseNgVendorCannotDeleteQueue, errors.New("failed, primary queue cannot be deleted. "+queueCode))
+		}
+		if _, err = db.TxPreparexExec(tx, "update summary_"+db.ToSuffix(vendorId)+" set delete_flag = 1, update_at = utc_timestamp() where id = ?", id); err != nil {
+			return err
+		}
+		result, err := db.TxPreparexExec(tx, "update queue_"+db.ToSuffix(vendorId)+
+			" set status = ?, cancel_reason = ?, cancel_note = '', update_at = utc_timestamp() where to_base64(queue_code) = ? and status in (?, ?)",
+			defs.StatusVendorCancel, defs.CancelReasonQueueDeleted, queueCode, defs.StatusEnqueue, defs.StatusShelved)
+		if err != nil {
+			return err
+		}
+		canceled, err = result.RowsAffected()
+		return err
+	})
+	return canceled, err
+}
+
+func (s *mysqlVendors) Drop(vendorId uint64) error {
+	domain := db.Domain{}
+	if err := db.PreparexGet(s.opConn.Master(), `select * from domain where id = ?`, &domain, vendorId); err != nil {
+		return fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	shard, err := vendorShard(s.opConn, domain.Id)
+	if err != nil {
+		return err
+	}
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		// tables may be partially created, drop errors are ignored
+		db.TxPreparexExec(tx, db.DropSummaryQuery(domain.Id))
+		db.TxPreparexExec(tx, db.DropQueueQuery(domain.Id))
+		db.TxPreparexExec(tx, db.DropKeyCodeQuery(domain.Id))
+		return nil
+	})
+	db.ForgetVendorShard(domain.Id)
+	return err
+}
+
+func (s *mysqlVendors) Side(vendorId uint64) string {
+	_, side, err := s.conn.VendorShard(vendorId)
+	if err != nil {
+		return ""
+	}
+	return side.String()
+}
@@ -0,0 +1,733 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"strconv"
+	"strings"
+	"time"
+	"vql/internal/db"
+	"vql/internal/defs"
+)
+
+type mysqlQueues struct {
+	conn *db.Conn
+}
+
+func (s *mysqlQueues) Enqueue(vendorId uint64, entry *Entry) (*Ticket, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	ticket := &Ticket{}
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		// the summary row lock serializes joiners of the queue until commit
+		capacities := []Capacity{}
+		if err := db.TxPreparexSelect(tx, `select max_waiting, daily_cap from summary_`+suffix+
+			` where to_base64(queue_code) = ? and delete_flag = 0 for update`,
+			&capacities, entry.QueueCode); err != nil {
+			return err
+		}
+		if len(capacities) == 0 {
+			return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+entry.QueueCode))
+		}
+		var count int
+		if capacity := capacities[0]; capacity.MaxWaiting > 0 || capacity.DailyCap > 0 {
+			if err := db.TxPreparexGet(tx, `select count(1) from queue_`+suffix+
+				` where to_base64(queue_code) = ? and status = ? and delete_flag = 0`,
+				&count, entry.QueueCode, defs.StatusEnqueue); err != nil {
+				return err
+			}
+			if capacity.MaxWaiting > 0 && count >= int(capacity.MaxWaiting) {
+				return fail(defs.ResponseNgUserMaxover, errors.New("failed, queue is full. "+entry.QueueCode))
+			}
+			if err := db.TxPreparexGet(tx, `select count(1) from queue_`+suffix+
+				` where to_base64(queue_code) = ? and create_at >= utc_date()`,
+				&count, entry.QueueCode); err != nil {
+				return err
+			}
+			if capacity.DailyCap > 0 && count >= int(capacity.DailyCap) {
+				return fail(defs.ResponseNgUserMaxover, errors.New("failed, daily cap reached. "+entry.QueueCode))
+			}
+		}
+
+		if !entry.AllowDuplicate {
+			if err := db.TxPreparexGet(tx, `select count(1) from queue_`+suffix+
+				` where to_base64(queue_code) = ? and uid = ? and status = ? and delete_flag = 0`,
+				&count, entry.QueueCode, entry.Uid, defs.StatusEnqueue); err != nil {
+				return err
+			}
+			if count > 0 {
+				return fail(defs.ResponseNgUserAlreadyEnqueue, errors.New("already enqueue. queue code:"+entry.QueueCode+" uid:"+strconv.FormatUint(entry.Uid, 10)))
+			}
+		}
+
+		summary := struct {
+			Name    string `db:"name"`
+			Caption string `db:"caption"`
+		}{}
+		if err := db.TxPreparexGet(tx, `select name, caption from summary_`+suffix+
+			` where id = ?`, &summary, PrimaryQueueId); err != nil {
+			return err
+		}
+		ticket.VendorName, ticket.VendorCaption = summary.Name, summary.Caption
+		if err := db.TxPreparexGet(tx, `select name from summary_`+suffix+
+			` where to_base64(queue_code) = ? and delete_flag = 0`,
+			&ticket.QueueName, entry.QueueCode); err != nil {
+			return err
+		}
+
+		var position uint64
+		if err := db.TxPreparexGet(tx, `select coalesce(max(position), 0) + 1 from queue_`+suffix+
+			` where to_base64(queue_code) = ?`, &position, entry.QueueCode); err != nil {
+			return err
+		}
+		result, err := db.TxPreparexExec(tx, `insert into queue_`+suffix+` (
+		queue_code, uid, keycode_prefix, keycode_suffix, mail_addr, mail_count,
+		push_type, push_count, position, pending_count, status, delete_flag, create_at, update_at
+	) values (
+		from_base64(?), ?, cast(nextseq_`+suffix+`("NUM") as char), ?, "", 0, 0, 0, ?, 0, ?, 0, utc_timestamp(), utc_timestamp()
+	)`, entry.QueueCode, entry.Uid, entry.KeyCodeSuffix, position, defs.StatusEnqueue)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		// registry entry outlives the queue row, generation is of the queue at issue
+		if _, err = db.TxPreparexExec(tx, `insert into keycode_`+suffix+` (
+		queue_id, queue_code, reset_count, keycode_prefix, keycode_suffix, delete_flag, create_at, update_at
+	) select q.id, q.queue_code, s.reset_count, q.keycode_prefix, q.keycode_suffix, 0, q.create_at, q.create_at
+		from queue_`+suffix+` q join summary_`+suffix+` s on s.queue_code = q.queue_code and s.delete_flag = 0 where q.id = ?`, id); err != nil {
+			return err
+		}
+
+		added := struct {
+			Id            uint64
+			KeyCodePrefix string `db:"keycode_prefix"`
+			KeyCodeSuffix string `db:"keycode_suffix"`
+		}{}
+		if err = db.TxPreparexGet(tx, `select id, keycode_prefix, keycode_suffix from queue_`+suffix+` where id = ?`,
+			&added, id); err != nil {
+			return err
+		}
+		ticket.Id, ticket.KeyCodePrefix, ticket.KeyCodeSuffix = added.Id, added.KeyCodePrefix, added.KeyCodeSuffix
+
+		if err = db.TxPreparexGet(tx, `select count(1) from queue_`+suffix+
+			` where to_base64(queue_code) = ? and position < ? and status = ? and delete_flag = 0`,
+			&ticket.Before, entry.QueueCode, position, defs.StatusEnqueue); err != nil {
+			return err
+		}
+		return db.TxPreparexGet(tx, `select count(1) from queue_`+suffix+
+			` where to_base64(queue_code) = ? and status = ? and delete_flag = 0`,
+			&ticket.Total, entry.QueueCode, defs.StatusEnqueue)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+func (s *mysqlQueues) Position(vendorId uint64, queueCode string, uid uint64) (*Position, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	results := []Position{}
+	if err = db.PreparexSelect(shard, `select id, position, status, cancel_reason, cancel_note, vendor_auth_at, mail_addr, push_type from queue_`+suffix+
+		` where to_base64(queue_code) = ? and uid = ? and delete_flag = 0 order by status in (?, ?) desc, if(status in (?, ?), id, 0), id desc limit 1`,
+		&results, queueCode, uid, defs.StatusEnqueue, defs.StatusShelved, defs.StatusEnqueue, defs.StatusShelved); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	if len(results) == 0 {
+		return nil, fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+	}
+	position := &results[0]
+	if position.Status != defs.StatusEnqueue && position.Status != defs.StatusShelved {
+		return position, nil
+	}
+	if err = db.PreparexGet(shard, `select count(1) from queue_`+suffix+
+		` where to_base64(queue_code) = ? and position < ? and status = ? and delete_flag = 0`,
+		&position.Before, queueCode, position.Order, defs.StatusEnqueue); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	if err = db.PreparexGet(shard, `select count(1) from queue_`+suffix+
+		` where to_base64(queue_code) = ? and status = ? and delete_flag = 0`,
+		&position.Total, queueCode, defs.StatusEnqueue); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return position, nil
+}
+
+func (s *mysqlQueues) Pending(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, steps int) (*Position, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		// the summary row lock serializes with joiners and other deferrals
+		limits := []Capacity{}
+		if err := db.TxPreparexSelect(tx, `select max_pending from summary_`+suffix+
+			` where to_base64(queue_code) = ? and delete_flag = 0 for update`,
+			&limits, queueCode); err != nil {
+			return err
+		}
+		if len(limits) == 0 {
+			return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+		}
+		type waiting struct {
+			Id           uint64
+			Position     uint64 `db:"position"`
+			PendingCount uint16 `db:"pending_count"`
+		}
+		entries := []waiting{}
+		if err := db.TxPreparexSelect(tx, `select id, position, pending_count from queue_`+suffix+
+			` where to_base64(queue_code) = ? and uid = ? and keycode_prefix = ? and status = ? and delete_flag = 0 for update`,
+			&entries, queueCode, uid, keyCodePrefix, defs.StatusEnqueue); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		mine := entries[0]
+		if mine.PendingCount >= limits[0].MaxPending {
+			return fail(defs.ResponseNgUserCannotPending, errors.New("failed, pending limit reached. "+keyCodePrefix))
+		}
+
+		after := []waiting{}
+		query := `select id, position from queue_` + suffix +
+			` where to_base64(queue_code) = ? and position > ? and status = ? and delete_flag = 0 order by position`
+		args := []interface{}{queueCode, mine.Position, defs.StatusEnqueue}
+		if steps > 0 {
+			query += ` limit ?`
+			args = append(args, steps)
+		}
+		if err := db.TxPreparexSelect(tx, query+` for update`, &after, args...); err != nil {
+			return err
+		}
+		if len(after) == 0 {
+			return fail(defs.ResponseNgUserCannotPending, errors.New("failed, already at the end. "+keyCodePrefix))
+		}
+
+		position := after[len(after)-1].Position
+		if steps <= 0 {
+			// behind everyone, no reordering of the others
+			position++
+		} else {
+			// those passed move up one place each
+			previous := mine.Position
+			for _, a := range after {
+				if _, err := db.TxPreparexExec(tx, `update queue_`+suffix+` set position = ? where id = ?`, previous, a.Id); err != nil {
+					return err
+				}
+				previous = a.Position
+			}
+		}
+		_, err := db.TxPreparexExec(tx, `update queue_`+suffix+
+			` set position = ?, pending_count = pending_count + 1, update_at = utc_timestamp() where id = ?`, position, mine.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Position(vendorId, queueCode, uid)
+}
+
+// run single update in transaction, returns affected rows, rolled back when more than one row
+func (s *mysqlQueues) update(vendorId uint64, query string, args ...interface{}) (int64, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return 0, err
+	}
+	var updated int64
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		var result sql.Result
+		var err error
+		if result, err = db.TxPreparexExec(tx, query, args...); err != nil {
+			return err
+		}
+		if updated, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		if updated > 1 {
+			return errors.New("failed, multiple rows updated. " + strconv.FormatInt(updated, 10))
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (s *mysqlQueues) UpdateByUser(vendorId uint64, uid uint64, keyCodePrefix string, from []defs.QueueStatus, to defs.QueueStatus) (int64, error) {
+	args := []interface{}{to, uid}
+	for _, status := range from {
+		args = append(args, status)
+	}
+	args = append(args, keyCodePrefix)
+	return s.update(vendorId, `update queue_`+db.ToSuffix(vendorId)+
+		` set status = ?, update_at = utc_timestamp() where uid = ? and status in (?`+strings.Repeat(", ?", len(from)-1)+`) and keycode_prefix = ?`,
+		args...)
+}
+
+func (s *mysqlQueues) Shelve(vendorId uint64, keyCodePrefix string, shelve bool) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	suffix := db.ToSuffix(vendorId)
+	return transact(shard, func(tx *sqlx.Tx) error {
+		statuses := []defs.QueueStatus{}
+		if err := db.TxPreparexSelect(tx, `select status from queue_`+suffix+
+			` where keycode_prefix = ? and delete_flag = 0 for update`, &statuses, keyCodePrefix); err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		to, err := shelveStatus(statuses[0], shelve, keyCodePrefix)
+		if err != nil {
+			return err
+		}
+		_, err = db.TxPreparexExec(tx, `update queue_`+suffix+
+			` set status = ?, update_at = utc_timestamp() where keycode_prefix = ? and delete_flag = 0`, to, keyCodePrefix)
+		return err
+	})
+}
+
+// status after shelve or unshelve from current status
+func shelveStatus(from defs.QueueStatus, shelve bool, keyCodePrefix string) (defs.QueueStatus, error) {
+	switch {
+	case shelve && from == defs.StatusShelved:
+		return from, fail(defs.ResponseNgVendorAlreadyShelved, errors.New("failed, already shelved. "+keyCodePrefix))
+	case !shelve && from == defs.StatusEnqueue:
+		return from, fail(defs.ResponseNgVendorAlreadyUnshelved, errors.New("failed, already unshelved. "+keyCodePrefix))
+	case shelve && from == defs.StatusEnqueue:
+		return defs.StatusShelved, nil
+	case !shelve && from == defs.StatusShelved:
+		return defs.StatusEnqueue, nil
+	}
+	return from, fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+}
+
+func (s *mysqlQueues) Move(vendorId uint64, keyCodePrefix string, index int, relative bool) (int, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return 0, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	queueCodes := []string{}
+	if err = db.PreparexSelect(shard, `select to_base64(queue_code) from queue_`+suffix+
+		` where keycode_prefix = ? and status = ? and delete_flag = 0`, &queueCodes, keyCodePrefix, defs.StatusEnqueue); err != nil {
+		return 0, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	if len(queueCodes) == 0 {
+		return 0, fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+	}
+	queueCode := queueCodes[0]
+	var target int
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		// the summary row lock serializes with joiners, then the waiting rows are locked in order
+		var count int
+		if err := db.TxPreparexGet(tx, `select count(1) from summary_`+suffix+
+			` where to_base64(queue_code) = ? and delete_flag = 0 for update`, &count, queueCode); err != nil {
+			return err
+		}
+		if count == 0 {
+			return fail(defs.ResponseNgQueueCodeNotfound, errors.New("failed, queue code not found. "+queueCode))
+		}
+		waiting := []struct {
+			Id            uint64
+			Position      uint64 `db:"position"`
+			KeyCodePrefix string `db:"keycode_prefix"`
+		}{}
+		if err := db.TxPreparexSelect(tx, `select id, position, keycode_prefix from queue_`+suffix+
+			` where to_base64(queue_code) = ? and status = ? and delete_flag = 0 order by position for update`,
+			&waiting, queueCode, defs.StatusEnqueue); err != nil {
+			return err
+		}
+		current := -1
+		ids := []uint64{}
+		for i, w := range waiting {
+			if w.KeyCodePrefix == keyCodePrefix {
+				current = i
+			}
+			ids = append(ids, w.Id)
+		}
+		if current < 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+		}
+		var err error
+		if target, err = moveTarget(current, len(waiting), index, relative, keyCodePrefix); err != nil {
+			return err
+		}
+		// same positions handed out in the new order
+		for i, id := range reorder(ids, current, target) {
+			if id == ids[i] {
+				continue
+			}
+			if _, err := db.TxPreparexExec(tx, `update queue_`+suffix+
+				` set position = ?, update_at = utc_timestamp() where id = ?`, waiting[i].Position, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return target, err
+}
+
+func (s *mysqlQueues) CancelByVendor(vendorId uint64, keyCodePrefix string, reason defs.CancelReason, note string) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	suffix := db.ToSuffix(vendorId)
+	return transact(shard, func(tx *sqlx.Tx) error {
+		statuses := []defs.QueueStatus{}
+		if err := db.TxPreparexSelect(tx, `select status from queue_`+suffix+
+			` where keycode_prefix = ? and delete_flag = 0 for update`, &statuses, keyCodePrefix); err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		if err := cancelable(statuses[0], keyCodePrefix); err != nil {
+			return err
+		}
+		_, err := db.TxPreparexExec(tx, `update queue_`+suffix+
+			` set status = ?, cancel_reason = ?, cancel_note = ?, update_at = utc_timestamp() where keycode_prefix = ? and delete_flag = 0`,
+			defs.StatusVendorCancel, reason, note, keyCodePrefix)
+		return err
+	})
+}
+
+// entry in status can be canceled by vendor
+func cancelable(status defs.QueueStatus, keyCodePrefix string) error {
+	switch status {
+	case defs.StatusEnqueue, defs.StatusShelved:
+		return nil
+	case defs.StatusVendorCancel:
+		return fail(defs.ResponseNgVendorAlreadyCanceled, errors.New("failed, already canceled. "+keyCodePrefix))
+	}
+	return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+}
+
+func (s *mysqlQueues) Admit(vendorId uint64, side AdmitSide, uid uint64, keyCodePrefix string, keyCodeSuffix string) (AdmitResult, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return 0, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	var result AdmitResult
+	err = transact(shard, func(tx *sqlx.Tx) error {
+		// admit settings of the owning queue, locked with the entry
+		summaries := []db.Summary{}
+		if err := db.TxPreparexSelect(tx, `select s.* from summary_`+suffix+` s join queue_`+suffix+` q on q.queue_code = s.queue_code`+
+			` where q.keycode_prefix = ? and q.delete_flag = 0 and s.delete_flag = 0 for update`, &summaries, keyCodePrefix); err != nil {
+			return err
+		}
+		if len(summaries) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		entries := []struct {
+			Id            uint64
+			Uid           uint64
+			KeyCodeSuffix string           `db:"keycode_suffix"`
+			Status        defs.QueueStatus `db:"status"`
+			VendorAuthAt  int64            `db:"vendor_auth_at"`
+			UserAuthAt    int64            `db:"user_auth_at"`
+		}{}
+		if err := db.TxPreparexSelect(tx, `select id, uid, keycode_suffix, status, vendor_auth_at, user_auth_at from queue_`+suffix+
+			` where keycode_prefix = ? and delete_flag = 0 for update`, &entries, keyCodePrefix); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		e := entries[0]
+		if err := admittable(side, uid, keyCodeSuffix, e.Uid, e.KeyCodeSuffix, e.Status, keyCodePrefix); err != nil {
+			return err
+		}
+		var vendorAt, userAt int64
+		result, vendorAt, userAt = admitStep(&summaries[0], side, e.VendorAuthAt, e.UserAuthAt, time.Now())
+		status := defs.QueueStatus(defs.StatusEnqueue)
+		if result == AdmitDequeued {
+			status = defs.StatusDequeue
+		}
+		_, err := db.TxPreparexExec(tx, `update queue_`+suffix+
+			` set status = ?, vendor_auth_at = ?, user_auth_at = ?, update_at = utc_timestamp() where id = ?`,
+			status, vendorAt, userAt, e.Id)
+		return err
+	})
+	return result, err
+}
+
+// entry can be confirmed by side
+func admittable(side AdmitSide, uid uint64, keyCodeSuffix string, entryUid uint64, entrySuffix string, status defs.QueueStatus, keyCodePrefix string) error {
+	if side == AdmitUser && uid != entryUid {
+		return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+	}
+	if side == AdmitVendor && keyCodeSuffix != entrySuffix {
+		return fail(defs.ResponseNgSuffixCodeCodeNotfound, errors.New("failed, suffix code not matched. "+keyCodePrefix))
+	}
+	if status != defs.StatusEnqueue {
+		return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+	}
+	return nil
+}
+
+// shelved entries are skipped
+func (s *mysqlQueues) Dequeue(vendorId uint64, keyCodePrefix string) (int64, error) {
+	return s.update(vendorId, `update queue_`+db.ToSuffix(vendorId)+
+		` set status = ?, update_at = utc_timestamp() where keycode_prefix = ? and status = ? and delete_flag = 0`,
+		defs.StatusDequeue, keyCodePrefix, defs.StatusEnqueue)
+}
+
+func (s *mysqlQueues) SetMail(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, mailAddr string) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	suffix := db.ToSuffix(vendorId)
+	return transact(shard, func(tx *sqlx.Tx) error {
+		entries := []struct {
+			Id       uint64
+			MailAddr string           `db:"mail_addr"`
+			Status   defs.QueueStatus `db:"status"`
+		}{}
+		if err := db.TxPreparexSelect(tx, `select id, mail_addr, status from queue_`+suffix+
+			` where to_base64(queue_code) = ? and uid = ? and keycode_prefix = ? and delete_flag = 0 for update`,
+			&entries, queueCode, uid, keyCodePrefix); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		e := entries[0]
+		if err := mailable(e.Status, e.MailAddr, mailAddr, keyCodePrefix); err != nil {
+			return err
+		}
+		_, err := db.TxPreparexExec(tx, `update queue_`+suffix+` set mail_addr = ? where id = ?`, mailAddr, e.Id)
+		return err
+	})
+}
+
+// mail address of entry in status can be changed from current to mailAddr
+func mailable(status defs.QueueStatus, current string, mailAddr string, keyCodePrefix string) error {
+	switch {
+	case status != defs.StatusEnqueue && status != defs.StatusShelved:
+		return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+	case mailAddr == "" && current == "":
+		return fail(defs.ResponseNgUserAlreadyMailOff, errors.New("failed, mail already off. "+keyCodePrefix))
+	case mailAddr != "" && current == mailAddr:
+		return fail(defs.ResponseNgUserAlreadyMailOn, errors.New("failed, mail already on. "+keyCodePrefix))
+	}
+	return nil
+}
+
+func (s *mysqlQueues) MailTargets(vendorId uint64) ([]MailTarget, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	targets := []MailTarget{}
+	if err = db.PreparexSelect(shard, `select q.id, to_base64(q.queue_code) as queue_code, q.keycode_prefix, q.mail_addr, q.mail_count, q.mail_notice, q.vendor_auth_at,`+
+		` (select count(1) from queue_`+suffix+` w where w.queue_code = q.queue_code and w.position < q.position and w.status = ? and w.delete_flag = 0) as ahead`+
+		` from queue_`+suffix+` q where q.mail_addr <> '' and q.status = ? and q.delete_flag = 0 order by q.queue_code, q.position`,
+		&targets, defs.StatusEnqueue, defs.StatusEnqueue); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return targets, nil
+}
+
+func (s *mysqlQueues) MarkMail(vendorId uint64, id uint64, notice MailNotice, max int) (bool, error) {
+	updated, err := s.update(vendorId, `update queue_`+db.ToSuffix(vendorId)+
+		` set mail_notice = mail_notice | ?, mail_count = mail_count + 1 where id = ? and mail_notice & ? = 0 and mail_count < ? and delete_flag = 0`,
+		notice, id, notice, max)
+	return updated > 0, err
+}
+
+func (s *mysqlQueues) SetPush(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, pushType defs.PushType, subscription string) error {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return err
+	}
+	suffix := db.ToSuffix(vendorId)
+	return transact(shard, func(tx *sqlx.Tx) error {
+		entries := []struct {
+			Id           uint64
+			PushType     defs.PushType    `db:"push_type"`
+			Subscription string           `db:"push_subscription"`
+			Status       defs.QueueStatus `db:"status"`
+		}{}
+		if err := db.TxPreparexSelect(tx, `select id, push_type, push_subscription, status from queue_`+suffix+
+			` where to_base64(queue_code) = ? and uid = ? and keycode_prefix = ? and delete_flag = 0 for update`,
+			&entries, queueCode, uid, keyCodePrefix); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not found."))
+		}
+		e := entries[0]
+		if err := pushable(e.Status, e.PushType, e.Subscription, pushType, subscription, keyCodePrefix); err != nil {
+			return err
+		}
+		_, err := db.TxPreparexExec(tx, `update queue_`+suffix+` set push_type = ?, push_subscription = ? where id = ?`, pushType, subscription, e.Id)
+		return err
+	})
+}
+
+// push subscription of entry in status can be changed to pushType and subscription
+func pushable(status defs.QueueStatus, currentType defs.PushType, current string, pushType defs.PushType, subscription string, keyCodePrefix string) error {
+	switch {
+	case status != defs.StatusEnqueue && status != defs.StatusShelved:
+		return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not waiting. "+keyCodePrefix))
+	case pushType == defs.PushTypeNone && currentType == defs.PushTypeNone:
+		return fail(defs.ResponseNgUserAlreadyPushOff, errors.New("failed, push already off. "+keyCodePrefix))
+	case pushType != defs.PushTypeNone && pushType == currentType && subscription == current:
+		return fail(defs.ResponseNgUserAlreadyPushOn, errors.New("failed, push already on. "+keyCodePrefix))
+	}
+	return nil
+}
+
+func (s *mysqlQueues) PushTargets(vendorId uint64) ([]PushTarget, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	targets := []PushTarget{}
+	if err = db.PreparexSelect(shard, `select q.id, to_base64(q.queue_code) as queue_code, q.uid, q.keycode_prefix, q.push_type, q.push_subscription, q.push_count, q.push_notice,`+
+		` (select count(1) from queue_`+suffix+` w where w.queue_code = q.queue_code and w.position < q.position and w.status = ? and w.delete_flag = 0) as ahead`+
+		` from queue_`+suffix+` q where q.push_type <> ? and q.status = ? and q.delete_flag = 0 order by q.queue_code, q.position`,
+		&targets, defs.StatusEnqueue, defs.PushTypeNone, defs.StatusEnqueue); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return targets, nil
+}
+
+func (s *mysqlQueues) MarkPush(vendorId uint64, id uint64, place uint16, max int) (bool, error) {
+	updated, err := s.update(vendorId, `update queue_`+db.ToSuffix(vendorId)+
+		` set push_notice = ?, push_count = push_count + 1 where id = ? and (push_notice = 0 or push_notice > ?) and push_count < ? and delete_flag = 0`,
+		place, id, place, max)
+	return updated > 0, err
+}
+
+func (s *mysqlQueues) CountByStatus(vendorId uint64, queueCode string) (map[defs.QueueStatus]int, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	results := []struct {
+		Status defs.QueueStatus
+		Count  int
+	}{}
+	if err = db.PreparexSelect(shard, `select status, count(1) as count from queue_`+db.ToSuffix(vendorId)+
+		` where to_base64(queue_code) = ? and delete_flag = 0 group by status`,
+		&results, queueCode); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	counts := map[defs.QueueStatus]int{}
+	for _, result := range results {
+		counts[result.Status] = result.Count
+	}
+	return counts, nil
+}
+
+func (s *mysqlQueues) Dequeued(vendorId uint64, queueCode string, since time.Time) ([]time.Time, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	times := []time.Time{}
+	if err = db.PreparexSelect(shard, `select update_at from queue_`+db.ToSuffix(vendorId)+
+		` where to_base64(queue_code) = ? and status = ? and update_at >= ? and delete_flag = 0 order by update_at`,
+		&times, queueCode, defs.StatusDequeue, since.UTC()); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return times, nil
+}
+
+func (s *mysqlQueues) Verify(vendorId uint64, keyCodePrefix string, keyCodeSuffix string) (*Issued, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	suffix := db.ToSuffix(vendorId)
+	issued := []struct {
+		Issued
+		KeyCodeSuffix string `db:"keycode_suffix"`
+	}{}
+	if err = db.PreparexSelect(shard, `select to_base64(k.queue_code) as queue_code, k.reset_count, k.keycode_suffix, k.create_at,`+
+		` (s.id is not null and s.reset_count = k.reset_count) as current, coalesce(q.status, 0) as status`+
+		` from keycode_`+suffix+` k left join queue_`+suffix+` q on q.id = k.queue_id and q.queue_code = k.queue_code and q.keycode_prefix = k.keycode_prefix and q.delete_flag = 0`+
+		` left join summary_`+suffix+` s on s.queue_code = k.queue_code and s.delete_flag = 0`+
+		` where k.keycode_prefix = ? and k.delete_flag = 0 order by k.id desc`,
+		&issued, keyCodePrefix); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	for i := range issued {
+		if issued[i].KeyCodeSuffix == keyCodeSuffix {
+			return &issued[i].Issued, nil
+		}
+	}
+	return nil, unverified(len(issued) > 0, keyCodePrefix)
+}
+
+// keycode not in registry, or prefix issued with another suffix
+func unverified(prefixFound bool, keyCodePrefix string) error {
+	if prefixFound {
+		return fail(defs.ResponseNgSuffixCodeCodeNotfound, errors.New("failed, suffix code not matched. "+keyCodePrefix))
+	}
+	return fail(defs.ResponseNgKeyCodeCodeNotfound, errors.New("failed, keycode not issued. "+keyCodePrefix))
+}
+
+func (s *mysqlQueues) List(vendorId uint64, queueCode string, statuses []defs.QueueStatus, limit int, offset int) ([]Row, error) {
+	shard, err := vendorShard(s.conn, vendorId)
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{queueCode}
+	filter := ""
+	if len(statuses) > 0 {
+		filter = " and status in (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	args = append(args, limit, offset)
+	rows := []Row{}
+	if err = db.PreparexSelect(shard, `select keycode_prefix, status from queue_`+db.ToSuffix(vendorId)+
+		` where to_base64(queue_code) = ? and delete_flag = 0`+filter+` order by position limit ? offset ?`,
+		&rows, args...); err != nil {
+		return nil, fail(defs.ResponseNgQueryExecuteFailed, err)
+	}
+	return rows, nil
+}
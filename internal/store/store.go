@@ -0,0 +1,466 @@
+/*
+  The MIT License
+  Copyright (c) 2020 FurtherSystem Co.,Ltd.
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in
+  all copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  THE SOFTWARE.
+*/
+
+// Storage repository package
+//
+// Handlers depend on the store interfaces below, MySQL (db.Conns) is the default
+// implementation, the in-memory one serves tests and local runs without a database.
+package store
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"vql/internal/db"
+	"vql/internal/defs"
+)
+
+// Store error carrying the response code reported to the client
+type Error struct {
+	Code defs.ResponseCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return defs.ResponseCodeText(e.Code) + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func fail(code defs.ResponseCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{code, err}
+}
+
+// Response code of store error, fallback if err is not a store error
+func CodeOf(err error, fallback defs.ResponseCode) defs.ResponseCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return fallback
+}
+
+// New account, written with its domain and free subscription
+type Account struct {
+	IdentifierType   uint8
+	PlatformType     string
+	Identifier       string
+	Seed             string
+	Ticks            int64
+	PrivateCode      []byte
+	AgreementVersion uint16
+	Agreed           bool
+	ActivateType     defs.ActivateType
+	ActivateKeyword  string
+	SessionId        []byte
+	SessionPrivate   []byte
+}
+
+// Live session
+type Session struct {
+	Id uint64
+	// base64 encoded
+	SessionPrivate string `db:"session_private"`
+}
+
+// Queue entry to add
+type Entry struct {
+	// base64 encoded
+	QueueCode     string
+	Uid           uint64
+	KeyCodeSuffix string
+	// skip already enqueued check, for vendor dummy entries
+	AllowDuplicate bool
+}
+
+// Added queue entry
+type Ticket struct {
+	Id            uint64
+	KeyCodePrefix string
+	KeyCodeSuffix string
+	VendorName    string
+	VendorCaption string
+	QueueName     string
+	// persons waiting before this entry
+	Before int
+	// persons waiting in queue
+	Total int
+}
+
+// Queue entry position
+type Position struct {
+	Id uint64
+	// waiting order, changed by pending
+	Order  uint64 `db:"position"`
+	Status defs.QueueStatus
+	// set when canceled by vendor
+	CancelReason defs.CancelReason `db:"cancel_reason"`
+	CancelNote   string            `db:"cancel_note"`
+	// unix time vendor confirmed the dequeue, 0 is not called yet
+	VendorAuthAt int64 `db:"vendor_auth_at"`
+	// mail notices address, empty is off
+	MailAddr string `db:"mail_addr"`
+	// push subscription type, PushTypeNone is off
+	PushType defs.PushType `db:"push_type"`
+	Before   int
+	Total    int
+}
+
+// Queue capacity, 0 is unlimited
+type Capacity struct {
+	// waiting entries
+	MaxWaiting uint32 `db:"max_waiting"`
+	// entries issued per utc day
+	DailyCap uint32 `db:"daily_cap"`
+	// deferrals per ticket, 0 disables pending
+	MaxPending uint16 `db:"max_pending"`
+	// seconds for the other side to confirm a dequeue on require admit queue, 0 is DefaultAdmitWindow
+	AdmitWindow uint32 `db:"admit_window"`
+}
+
+// Capacity fields to change on update, nil keeps the current value
+type CapacityChange struct {
+	MaxWaiting  *uint32
+	DailyCap    *uint32
+	MaxPending  *uint16
+	AdmitWindow *uint32
+}
+
+// capacity with the set fields replaced
+func (change CapacityChange) Apply(capacity Capacity) Capacity {
+	if change.MaxWaiting != nil {
+		capacity.MaxWaiting = *change.MaxWaiting
+	}
+	if change.DailyCap != nil {
+		capacity.DailyCap = *change.DailyCap
+	}
+	if change.MaxPending != nil {
+		capacity.MaxPending = *change.MaxPending
+	}
+	if change.AdmitWindow != nil {
+		capacity.AdmitWindow = *change.AdmitWindow
+	}
+	return capacity
+}
+
+// Queue maintenance, joining is refused while active
+type Maintenance struct {
+	Maintenance bool   `db:"maintenance"`
+	Message     string `db:"maintenance_message"`
+	// unix time maintenance ends, 0 is until turned off
+	Until int64 `db:"maintenance_until"`
+}
+
+// Queue is in maintenance at now
+func InMaintenance(summary *db.Summary, now time.Time) bool {
+	return summary.Maintenance && (summary.MaintenanceUntil == 0 || now.Unix() < summary.MaintenanceUntil)
+}
+
+// Side confirming a dequeue
+type AdmitSide uint8
+
+const (
+	AdmitVendor AdmitSide = iota + 1
+	AdmitUser
+)
+
+// Dequeue handshake result
+type AdmitResult uint8
+
+const (
+	// confirmed, waiting for the other side
+	AdmitWaiting AdmitResult = iota + 1
+	// both sides confirmed, entry is dequeued
+	AdmitDequeued
+	// the other side confirmation expired, confirmed again waiting for the other side
+	AdmitExpired
+)
+
+// window used when admit window of the queue is 0
+const DefaultAdmitWindow = 5 * time.Minute
+
+// admit window of the queue
+func AdmitWindow(summary *db.Summary) time.Duration {
+	if summary.AdmitWindow == 0 {
+		return DefaultAdmitWindow
+	}
+	return time.Duration(summary.AdmitWindow) * time.Second
+}
+
+// handshake step of side at now, returns result and new vendor and user confirmation times
+func admitStep(summary *db.Summary, side AdmitSide, vendorAt int64, userAt int64, now time.Time) (AdmitResult, int64, int64) {
+	if !summary.RequireAdmit {
+		return AdmitDequeued, vendorAt, userAt
+	}
+	mine, other := &vendorAt, &userAt
+	if side == AdmitUser {
+		mine, other = &userAt, &vendorAt
+	}
+	if *other == 0 {
+		*mine = now.Unix()
+		return AdmitWaiting, vendorAt, userAt
+	}
+	if now.Sub(time.Unix(*other, 0)) <= AdmitWindow(summary) {
+		*mine = now.Unix()
+		return AdmitDequeued, vendorAt, userAt
+	}
+	*other, *mine = 0, now.Unix()
+	return AdmitExpired, vendorAt, userAt
+}
+
+// Mail notices of an entry, bits of mail_notice
+type MailNotice uint8
+
+const (
+	// few persons left waiting before the entry
+	MailNear MailNotice = 1 << iota
+	// vendor called the entry to confirm the dequeue
+	MailCalled
+)
+
+// Waiting entry with mail address
+type MailTarget struct {
+	Id            uint64
+	QueueCode     string     `db:"queue_code"`
+	KeyCodePrefix string     `db:"keycode_prefix"`
+	MailAddr      string     `db:"mail_addr"`
+	MailCount     uint16     `db:"mail_count"`
+	Notice        MailNotice `db:"mail_notice"`
+	VendorAuthAt  int64      `db:"vendor_auth_at"`
+	Before        int        `db:"ahead"`
+}
+
+// Waiting entry with push subscription
+type PushTarget struct {
+	Id            uint64
+	QueueCode     string        `db:"queue_code"`
+	Uid           uint64        `db:"uid"`
+	KeyCodePrefix string        `db:"keycode_prefix"`
+	PushType      defs.PushType `db:"push_type"`
+	Subscription  string        `db:"push_subscription"`
+	PushCount     uint16        `db:"push_count"`
+	// lowest place in line notified, 0 is none
+	Notice uint16 `db:"push_notice"`
+	Before int    `db:"ahead"`
+}
+
+// Keycode found in the registry
+type Issued struct {
+	// base64 queue code at issue
+	QueueCode  string    `db:"queue_code"`
+	ResetCount uint16    `db:"reset_count"`
+	IssueAt    time.Time `db:"create_at"`
+	// issued in the live generation of a live queue
+	Current bool `db:"current"`
+	// status of the entry, 0 once its queue was reset
+	Status defs.QueueStatus `db:"status"`
+}
+
+// Queue list row
+type Row struct {
+	KeyCodePrefix string           `db:"keycode_prefix"`
+	Status        defs.QueueStatus `db:"status"`
+}
+
+// Auth accounts and sessions on master
+type AuthStore interface {
+	// create account, returns account id which is also the vendor id
+	Create(a *Account) (uint64, error)
+	Get(id uint64) (*db.Auth, error)
+	// replace session of the account with base64 private code, returns account id
+	RenewSession(privateCode string, sessionId []byte, sessionPrivate []byte) (uint64, error)
+	// replace private code and session of the account with identifier and seed, returns account id and previous private code
+	Recover(identifier string, seed string, privateCode []byte, sessionId []byte, sessionPrivate []byte) (uint64, []byte, error)
+	// live session by base64 session id
+	FindSession(sessionId string) (*Session, error)
+	// extend session timeout
+	TouchSession(sessionId string) error
+	SetAccountType(id uint64, accountType defs.AccountType) error
+	// link openid connect identity to the account, replaces its previous identity
+	LinkIdentity(id uint64, issuer string, subject string) error
+	// account id linked to the identity
+	FindIdentity(issuer string, subject string) (uint64, error)
+}
+
+// Subscription plans on master
+type SubscriptionStore interface {
+	Get(id uint64) (*db.Subscription, error)
+	Update(id uint64, plan defs.SubscriptionType, expire time.Time) error
+}
+
+// Vendor domain on master and vendor summary on shard
+type VendorStore interface {
+	// vendor id by base64 vendor code
+	IdByCode(vendorCode string) (uint64, error)
+	Domain(vendorId uint64) (*db.Domain, error)
+	// set vendor code and place vendor shard
+	Assign(vendorId uint64, vendorCode []byte) error
+	// create vendor shard tables and initialize queue
+	Provision(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) error
+	// primary queue summary, holds vendor name and caption
+	Summary(vendorId uint64) (*db.Summary, error)
+	// live queues in id order, primary queue first
+	ListQueues(vendorId uint64) ([]db.Summary, error)
+	// live queue by base64 queue code
+	QueueSummary(vendorId uint64, queueCode string) (*db.Summary, error)
+	// add queue next to the primary queue, returns summary id
+	AddQueue(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity Capacity) (uint64, error)
+	// update name, caption, admit setting and the changed capacity of the queue
+	UpdateQueue(vendorId uint64, queueCode string, name string, caption string, requireAdmit bool, capacity CapacityChange) error
+	// set business hours json of the queue, empty is always open
+	SetSchedule(vendorId uint64, queueCode string, schedule string) error
+	// start or end maintenance of the queue, waiting entries are kept
+	SetMaintenance(vendorId uint64, queueCode string, maintenance Maintenance) error
+	// set mail templates json of the vendor, empty is default
+	SetMailTemplate(vendorId uint64, template string) error
+	// set push thresholds of the vendor, comma separated places, empty is default
+	SetPushThresholds(vendorId uint64, thresholds string) error
+	// delete queue other than primary, waiting and shelved entries are canceled by vendor. returns canceled count
+	DeleteQueue(vendorId uint64, queueCode string) (int64, error)
+	// update name, caption and the changed capacity, non nil queueCode resets the queue with it
+	Update(vendorId uint64, name string, caption string, queueCode []byte, requireAdmit bool, capacity CapacityChange) error
+	// drop vendor shard tables
+	Drop(vendorId uint64) error
+	// side of the shard pair serving the vendor, empty if not applicable
+	Side(vendorId uint64) string
+}
+
+// Vendor queue entries on shard
+type QueueStore interface {
+	Enqueue(vendorId uint64, entry *Entry) (*Ticket, error)
+	// position of the uid entry in queue with base64 queue code, the first waiting or shelved one, the latest otherwise
+	Position(vendorId uint64, queueCode string, uid uint64) (*Position, error)
+	// change status of uid entry, returns updated count
+	UpdateByUser(vendorId uint64, uid uint64, keyCodePrefix string, from []defs.QueueStatus, to defs.QueueStatus) (int64, error)
+	// move waiting uid entry back by steps, to the end if steps is 0 or more than waiting after it
+	Pending(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, steps int) (*Position, error)
+	// park waiting entry or put it back to its place, by vendor
+	Shelve(vendorId uint64, keyCodePrefix string, shelve bool) error
+	// move waiting entry to index among waiting entries of its queue, relative index is from its current index.
+	// returns the new index
+	Move(vendorId uint64, keyCodePrefix string, index int, relative bool) (int, error)
+	// cancel waiting or shelved entry by vendor with reason
+	CancelByVendor(vendorId uint64, keyCodePrefix string, reason defs.CancelReason, note string) error
+	// confirm dequeue by a side, uid is checked for user and suffix for vendor.
+	// the entry is dequeued when both sides confirmed in window, or at once unless require admit
+	Admit(vendorId uint64, side AdmitSide, uid uint64, keyCodePrefix string, keyCodeSuffix string) (AdmitResult, error)
+	// force dequeue of waiting entry by vendor, suffix is not checked. returns updated count
+	Dequeue(vendorId uint64, keyCodePrefix string) (int64, error)
+	// entry counts by status
+	CountByStatus(vendorId uint64, queueCode string) (map[defs.QueueStatus]int, error)
+	// update times of entries dequeued since, ascending
+	Dequeued(vendorId uint64, queueCode string, since time.Time) ([]time.Time, error)
+	// set mail address of waiting uid entry, empty address turns mail off
+	SetMail(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, mailAddr string) error
+	// waiting entries with mail address in queue and position order, shelved ones are left out
+	MailTargets(vendorId uint64) ([]MailTarget, error)
+	// record notice sent to entry, false if already sent or max mails of the entry reached
+	MarkMail(vendorId uint64, id uint64, notice MailNotice, max int) (bool, error)
+	// set push subscription of waiting uid entry, PushTypeNone turns push off
+	SetPush(vendorId uint64, queueCode string, uid uint64, keyCodePrefix string, pushType defs.PushType, subscription string) error
+	// waiting entries with push subscription in queue and position order, shelved ones are left out
+	PushTargets(vendorId uint64) ([]PushTarget, error)
+	// record push sent at place to entry, false if a place at or before it was already notified or max pushes of the entry reached
+	MarkPush(vendorId uint64, id uint64, place uint16, max int) (bool, error)
+	// look up keycode issued by the vendor, kept in the registry across queue resets
+	Verify(vendorId uint64, keyCodePrefix string, keyCodeSuffix string) (*Issued, error)
+	// entries in position order, empty statuses means all
+	List(vendorId uint64, queueCode string, statuses []defs.QueueStatus, limit int, offset int) ([]Row, error)
+}
+
+// target index of a move among total waiting entries, end of line for index past it
+func moveTarget(current int, total int, index int, relative bool, keyCodePrefix string) (int, error) {
+	target := index
+	if relative {
+		target += current
+	}
+	if current == 0 && target <= 0 && (index < 0 || !relative) {
+		return 0, fail(defs.ResponseNgVendorConnotMoveup, errors.New("failed, already at the top. "+keyCodePrefix))
+	}
+	if target < 0 {
+		target = 0
+	}
+	if target >= total {
+		target = total - 1
+	}
+	return target, nil
+}
+
+// ids in new order after moving ids[current] to target
+func reorder(ids []uint64, current int, target int) []uint64 {
+	moved := make([]uint64, 0, len(ids))
+	for i, id := range ids {
+		if i == current {
+			continue
+		}
+		if len(moved) == target {
+			moved = append(moved, ids[current])
+		}
+		moved = append(moved, id)
+	}
+	if len(moved) < len(ids) {
+		moved = append(moved, ids[current])
+	}
+	return moved
+}
+
+// summary id of the queue created with the vendor
+const PrimaryQueueId = 1
+
+var Auths AuthStore
+var Subscriptions SubscriptionStore
+var Vendors VendorStore
+var Queues QueueStore
+
+func init() {
+	UseMySQL(&db.Conns, &db.OpConns)
+}
+
+// Report which side of the vendor shard pair serves the request
+func ShardSide(header http.Header, vendorId uint64) {
+	if side := Vendors.Side(vendorId); side != "" {
+		header.Set(db.HeaderShardSide, side)
+	}
+}
+
+// Queue names of the vendor by base64 queue code
+func QueueNames(vendorId uint64) (map[string]string, error) {
+	queues, err := Vendors.ListQueues(vendorId)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, q := range queues {
+		names[encode(q.QueueCode)] = q.Name
+	}
+	return names, nil
+}
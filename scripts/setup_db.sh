@@ -124,6 +124,20 @@ grant_all_db(){
 ${flush}"
 }
 
+create_table_sso(){
+  query="use ${1};create table if not exists sso (
+    issuer              varchar(255) not null,
+    subject             varchar(255) not null,
+    id                  bigint unsigned not null,
+    create_at           datetime not null,
+    update_at           datetime not null,
+    primary key (issuer, subject),
+    unique key (id)
+  ) engine=innodb;
+"
+  ${DRYRUN} ${DBCLIENT} -u${DBUSER} -h${DBADDR} -p${DBPASS} -e "${query}"
+}
+
 # main logics.
 
 create_user ${CREATE_USER} ${CREATE_PASS} || die "error create user ${CREATE_USER}"
@@ -138,6 +152,7 @@ fi
 create_table_domain ${DBPREFIX}_master || die "erro create table vendor ${DBPREFIX}_master"
 create_table_auth ${DBPREFIX}_master || die "erro create table vendor ${DBPREFIX}_master"
 create_table_subscription ${DBPREFIX}_master || die "erro create table vendor ${DBPREFIX}_master"
+create_table_sso ${DBPREFIX}_master || die "erro create table sso ${DBPREFIX}_master"
 
 for suffix in `seq -w ${NUM_START} ${NUM_END}`
 do